@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/go-compile/localrelay/v2"
+)
+
+// accessLogKey is the log descriptor name of a relay's access log
+func accessLogKey(relay string) string {
+	return relay + ".access"
+}
+
+// newAccessLog creates the relay's access log. The daemon writes to a file
+// in the log dir, relays ran in the foreground write to stdout.
+func newAccessLog(r Relay) (*localrelay.AccessLog, error) {
+	format, err := localrelay.ParseAccessLogFormat(r.AccessLog)
+	if err != nil {
+		return nil, err
+	}
+
+	var w io.Writer = os.Stdout
+	if isService {
+		f, err := os.OpenFile(accessLogPath(r.Name), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		addLogDescriptor(f, accessLogKey(r.Name))
+		w = f
+	}
+
+	return localrelay.NewAccessLog(w, format), nil
+}
+
+// closeAccessLog closes the relay's access log file if open
+func closeAccessLog(relay string) {
+	activeRelaysM.Lock()
+	defer activeRelaysM.Unlock()
+
+	c, found := logDescriptors[accessLogKey(relay)]
+	if !found {
+		return
+	}
+
+	(*c).Close()
+	delete(logDescriptors, accessLogKey(relay))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/go-compile/localrelay/pkg/api"
+	"github.com/go-compile/localrelay/v2"
+)
+
+// relayACL handles:
+//
+//	localrelay acl <relay>
+//	localrelay acl <relay> clear
+//	localrelay acl <relay> allow 10.0.0.0/8 deny all
+func relayACL(opt *options) error {
+	if len(opt.commands) < 2 {
+		Println("Usage: localrelay acl <relay> [<allow|deny> <ip|cidr|all>]...")
+		return nil
+	}
+
+	relayName := opt.commands[1]
+	if !validateName(relayName) {
+		Println("[WARN] Invalid relay name.")
+		return nil
+	}
+
+	c, err := api.Connect()
+	if err != nil {
+		return err
+	}
+
+	defer c.Close()
+
+	args := opt.commands[2:]
+	if len(args) == 0 {
+		rules, err := c.GetACL(relayName)
+		if err != nil {
+			return err
+		}
+
+		if len(rules) == 0 {
+			Printf("Relay %q has no ACL rules, all clients are allowed.\n", relayName)
+			return nil
+		}
+
+		for i, rule := range rules {
+			Printf("  \x1b[90m%.2d\x1b[0m: %s\n", i+1, rule)
+		}
+
+		return nil
+	}
+
+	rules := []string{}
+	if !(len(args) == 1 && args[0] == "clear") {
+		if len(args)%2 != 0 {
+			Println("[WARN] Each rule requires an action and a network.")
+			return nil
+		}
+
+		for i := 0; i < len(args); i += 2 {
+			rule := args[i] + " " + args[i+1]
+
+			// validate before sending to the daemon
+			if _, err := localrelay.ParseACLRule(rule); err != nil {
+				return err
+			}
+
+			rules = append(rules, rule)
+		}
+	}
+
+	if err := c.SetACL(relayName, rules); err != nil {
+		return err
+	}
+
+	Printf("Relay %q ACL has been updated with %d rules.\n", relayName, len(rules))
+	return nil
+}
@@ -36,10 +36,13 @@ type options struct {
 	isFork           bool
 	DisableAutoStart bool
 	store            bool
+	all              bool
 
 	ipcPipe string
 
 	interval time.Duration
+	// drain is how long stop waits for a relay's connections to close
+	drain time.Duration
 }
 
 /*
@@ -129,6 +132,20 @@ func parseArgs() (*options, error) {
 			opt.loadbalance = true
 		case "store", "s":
 			opt.store = true
+		case "all", "-all":
+			opt.all = true
+		case "drain", "-drain":
+			value, err := getAnswer(args, arg, &i)
+			if err != nil {
+				return nil, err
+			}
+
+			dur, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, err
+			}
+
+			opt.drain = dur
 		case "timeout":
 			value, err := getAnswer(args, arg, &i)
 			if err != nil {
@@ -196,6 +213,8 @@ func parseArgs() (*options, error) {
 			opt.proxyType = localrelay.ProxyTCP
 		case "udp":
 			opt.proxyType = localrelay.ProxyUDP
+		case "unix":
+			opt.proxyType = localrelay.ProxyUnix
 		case "http":
 			opt.proxyType = localrelay.ProxyHTTP
 		case "https":
@@ -254,13 +273,31 @@ func help() {
 	Println("  localrelay monitor")
 	Println("  localrelay connections")
 	Println("  localrelay connections <relay>")
+	Println("  localrelay conn <id>")
 	Println("  localrelay ips")
 	Println("  localrelay drop")
 	Println("  localrelay dropip <ip>")
 	Println("  localrelay droprelay <relay>")
+	Println("  localrelay dropconn <id>")
+	Println("  localrelay limit <relay> <relay|conn|ip> <upload> <download>")
+	Println("  localrelay acl <relay> [<allow|deny> <ip|cidr|all>]...")
+	Println("  localrelay dst <relay>")
+	Println("  localrelay dst <add|rm|enable|disable|drain> <relay> <destination>")
+	Println("  localrelay dst weight <relay> <destination> <weight>")
+	Println("  localrelay proxy set <relay> <name> <socks5://host:port>")
+	Println("  localrelay proxy rm <relay> <name>")
+	Println("  localrelay capture <relay> [ip]...")
+	Println("  localrelay capture <relay> stop")
+	Println("  localrelay replay <capture> <destination> [conn_id]")
+	Println("  localrelay validate <relay_config>... | @<relay> | -all")
+	Println("  localrelay reload")
+	Println("  localrelay bans")
+	Println("  localrelay unban <ip>")
 	Println("  localrelay stop")
 	Println("  localrelay stop <relay>")
+	Println("  localrelay stop <relay> -drain=30s")
 	Println("  localrelay restart")
+	Println("  localrelay restart <relay>")
 	Println("  localrelay install")
 	Println("  localrelay uninstall")
 	Println()
@@ -269,11 +306,12 @@ func help() {
 	Printf("  %-28s %s\n", "-destination, -dst, -rhost", "Set forward address")
 	Printf("  %-28s %s\n", "-tcp", "Set relay to TCP relay")
 	Printf("  %-28s %s\n", "-udp", "Set relay to UDP relay")
+	Printf("  %-28s %s\n", "-unix", "Set relay to unix socket relay")
 	Printf("  %-28s %s\n", "-http", "Set relay to HTTP relay")
 	Printf("  %-28s %s\n", "-https", "Set relay to HTTPS relay")
 	Printf("  %-28s %s\n", "-proxy", "Set socks5 proxy via URL")
 	Printf("  %-28s %s\n", "-loadbalance", "Enables load balancing")
-	Printf("  %-28s %s\n", "-output, -o", "Set output file path")
+	Printf("  %-28s %s\n", "-output, -o", "Set output file path, the extension sets the format")
 	Printf("  %-28s %s\n", "-proxy_ignore", "Destination indexes to ignore proxy settings")
 	Printf("  %-28s %s\n", "-version", "View version page")
 	Printf("  %-28s %s\n", "-timeout", "Set dial timeout for non proxied relays")
@@ -284,6 +322,7 @@ func help() {
 	Printf("  %-28s %s\n", "-noauto", "Set relay to not autostart with daemon")
 	Printf("  %-28s %s\n", "-store", "Output relay configs to config dir")
 	Printf("  %-28s %s\n", "-interval", "Metrics refresh interval")
+	Printf("  %-28s %s\n", "-drain", "Wait for connections to close when stopping a relay")
 }
 
 func version() {
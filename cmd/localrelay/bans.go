@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-compile/localrelay/pkg/api"
+	"github.com/go-compile/localrelay/v2"
+	"github.com/pkg/errors"
+)
+
+var (
+	// bansFileName is stored within the config dir and holds the bans of
+	// every relay so they persist across daemon restarts
+	bansFileName = "bans.json"
+	bansFileM    sync.Mutex
+
+	defaultBanWindow   = time.Minute * 10
+	defaultBanDuration = time.Hour
+)
+
+// newJail creates a jail from the relay's ban config
+func newJail(b Bans) (*localrelay.Jail, error) {
+	config := localrelay.JailConfig{
+		Thresholds: map[localrelay.Offense]int{
+			localrelay.OffenseRejected:  b.Rejected,
+			localrelay.OffenseAuth:      b.Auth,
+			localrelay.OffenseRateLimit: b.RateLimit,
+		},
+		Window:   defaultBanWindow,
+		Duration: defaultBanDuration,
+	}
+
+	if b.Window != "" {
+		d, err := time.ParseDuration(b.Window)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing ban window")
+		}
+
+		config.Window = d
+	}
+
+	if b.Duration != "" {
+		d, err := time.ParseDuration(b.Duration)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing ban duration")
+		}
+
+		config.Duration = d
+	}
+
+	return localrelay.NewJail(config), nil
+}
+
+func bansFile() string {
+	return filepath.Join(relaysDir(), bansFileName)
+}
+
+// readBans reads the stored bans, the caller must hold bansFileM
+func readBans() (map[string][]localrelay.Ban, error) {
+	bans := make(map[string][]localrelay.Ban)
+
+	f, err := os.Open(bansFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return bans, nil
+		}
+
+		return nil, err
+	}
+
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&bans); err != nil {
+		return nil, errors.Wrapf(err, "file:%q", bansFile())
+	}
+
+	return bans, nil
+}
+
+// writeBans replaces the stored bans, the caller must hold bansFileM
+func writeBans(bans map[string][]localrelay.Ban) error {
+	tmp := bansFile() + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(f).Encode(bans); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, bansFile())
+}
+
+// storedBans returns the persisted bans of a relay
+func storedBans(relay string) []localrelay.Ban {
+	bansFileM.Lock()
+	defer bansFileM.Unlock()
+
+	bans, err := readBans()
+	if err != nil {
+		return nil
+	}
+
+	return bans[relay]
+}
+
+// saveBans persists the bans of all running relays. Bans of relays which
+// are not running are kept so they can be restored when it next starts.
+func saveBans() error {
+	bansFileM.Lock()
+	defer bansFileM.Unlock()
+
+	bans, err := readBans()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range runningRelays() {
+		if jail := r.Jail(); jail != nil {
+			bans[r.Name] = jail.Bans()
+		}
+	}
+
+	return writeBans(bans)
+}
+
+// unbanIP removes the ip from every relay, running or stored.
+// Returns false if the ip was not banned.
+func unbanIP(ip string) (bool, error) {
+	found := false
+	for _, r := range runningRelays() {
+		if jail := r.Jail(); jail != nil && jail.Unban(ip) {
+			found = true
+		}
+	}
+
+	bansFileM.Lock()
+	defer bansFileM.Unlock()
+
+	bans, err := readBans()
+	if err != nil {
+		return found, err
+	}
+
+	for relay, list := range bans {
+		kept := list[:0]
+		for _, ban := range list {
+			if ban.IP == ip {
+				found = true
+				continue
+			}
+
+			kept = append(kept, ban)
+		}
+
+		bans[relay] = kept
+	}
+
+	return found, writeBans(bans)
+}
+
+// activeBans lists the bans of every running relay
+func activeBans() []api.Ban {
+	bans := []api.Ban{}
+	for _, r := range runningRelays() {
+		jail := r.Jail()
+		if jail == nil {
+			continue
+		}
+
+		for _, ban := range jail.Bans() {
+			bans = append(bans, api.Ban{
+				Relay:   r.Name,
+				IP:      ban.IP,
+				Reason:  string(ban.Reason),
+				Created: ban.Created.Unix(),
+				Expires: ban.Expires.Unix(),
+			})
+		}
+	}
+
+	return bans
+}
+
+// displayBans handles: localrelay bans
+func displayBans() error {
+	bans, err := listBans()
+	if err != nil {
+		Printf("Daemon:    \x1b[31m [OFFLINE] \x1b[0m\r\n")
+		return err
+	}
+
+	if len(bans) == 0 {
+		Println("No clients are banned.")
+		return nil
+	}
+
+	for _, ban := range bans {
+		Printf("%s (%s) [%s] expires in %s\r\n", ban.IP, ban.Relay, ban.Reason, formatDuration(time.Until(time.Unix(ban.Expires, 0))))
+	}
+
+	return nil
+}
+
+// unbanCommand handles: localrelay unban <ip>
+func unbanCommand(opt *options) error {
+	if len(opt.commands) < 2 {
+		Println("Provide an ip address.")
+		return nil
+	}
+
+	return unban(opt.commands[1])
+}
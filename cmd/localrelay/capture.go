@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-compile/localrelay/pkg/api"
+	"github.com/go-compile/localrelay/v2"
+	"github.com/pkg/errors"
+)
+
+// replayDrainTimeout is how long replay waits for the destination to
+// finish responding once the client stream has been sent
+const replayDrainTimeout = time.Second * 10
+
+// startCapture opens a new capture file for the relay and starts capturing
+func startCapture(relay *localrelay.Relay, ips []string) (string, error) {
+	path := filepath.Join(captureDir(), relay.Name+"-"+time.Now().Format("20060102-150405")+".lrcap")
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	if err := relay.StartCapture(f, ips...); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// captureCommand handles:
+//
+//	localrelay capture <relay> [ip]...
+//	localrelay capture <relay> stop
+func captureCommand(opt *options) error {
+	if len(opt.commands) < 2 {
+		Println("Usage: localrelay capture <relay> [ip]...")
+		Println("       localrelay capture <relay> stop")
+		return nil
+	}
+
+	relayName := opt.commands[1]
+	if !validateName(relayName) {
+		Println("[WARN] Invalid relay name.")
+		return nil
+	}
+
+	c, err := api.Connect()
+	if err != nil {
+		return err
+	}
+
+	defer c.Close()
+
+	args := opt.commands[2:]
+	if len(args) == 1 && args[0] == "stop" {
+		if err := c.StopCapture(relayName); err != nil {
+			return err
+		}
+
+		Printf("Relay %q is no longer capturing.\n", relayName)
+		return nil
+	}
+
+	for _, ip := range args {
+		if net.ParseIP(ip) == nil {
+			Printf("[WARN] Invalid IP %q.\n", ip)
+			return nil
+		}
+	}
+
+	path, err := c.StartCapture(relayName, args)
+	if err != nil {
+		return err
+	}
+
+	Printf("Relay %q is capturing to %q.\n", relayName, path)
+	Printf("Stop with: localrelay capture %s stop\n", relayName)
+	return nil
+}
+
+// replayCommand handles:
+//
+//	localrelay replay <capture> <destination> [conn_id]
+//
+// The client side of a captured connection is sent to the destination with
+// its original timing. The destination's response is written to stdout.
+func replayCommand(opt *options) error {
+	if len(opt.commands) < 3 {
+		Println("Usage: localrelay replay <capture> <destination> [conn_id]")
+		return nil
+	}
+
+	var connID uint64
+	if len(opt.commands) > 3 {
+		id, err := strconv.ParseUint(opt.commands[3], 10, 64)
+		if err != nil {
+			Println("[WARN] Invalid connection ID.")
+			return nil
+		}
+
+		connID = id
+	}
+
+	frames, meta, err := readCapturedConn(opt.commands[1], connID)
+	if err != nil {
+		return err
+	}
+
+	Printf("[Info] Replaying connection from %s to %s (originally %s)\n", meta.Client, opt.commands[2], meta.Destination)
+
+	conn, err := net.DialTimeout("tcp", strings.TrimPrefix(opt.commands[2], "tcp://"), localrelay.Timeout)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	done := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(stdout, conn)
+		done <- n
+	}()
+
+	var sent int64
+	for i, f := range frames {
+		if i > 0 {
+			time.Sleep(f.Time.Sub(frames[i-1].Time))
+		}
+
+		n, err := conn.Write(f.Data)
+		sent += int64(n)
+
+		if err != nil {
+			return errors.Wrap(err, "writing to destination")
+		}
+	}
+
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+
+	conn.SetReadDeadline(time.Now().Add(replayDrainTimeout))
+	received := <-done
+
+	Printf("\n[Info] Replay finished, sent %s received %s\n", formatBytes(int(sent)), formatBytes(int(received)))
+	return nil
+}
+
+// readCapturedConn returns the upload frames of a connection in the capture.
+// When connID is 0 the first connection is used.
+func readCapturedConn(path string, connID uint64) ([]localrelay.Frame, *localrelay.CaptureConn, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer f.Close()
+
+	r, err := localrelay.NewCaptureReader(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		meta   *localrelay.CaptureConn
+		frames []localrelay.Frame
+	)
+
+	for {
+		frame, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if connID == 0 && frame.Type == localrelay.FrameOpen {
+			connID = frame.ConnID
+		}
+
+		if frame.ConnID != connID {
+			continue
+		}
+
+		switch frame.Type {
+		case localrelay.FrameOpen:
+			meta = &localrelay.CaptureConn{}
+			if err := json.Unmarshal(frame.Data, meta); err != nil {
+				return nil, nil, err
+			}
+		case localrelay.FrameUpload:
+			frames = append(frames, frame)
+		}
+	}
+
+	if meta == nil {
+		return nil, nil, errors.New("connection not found in capture")
+	}
+
+	return frames, meta, nil
+}
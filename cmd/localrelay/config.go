@@ -1,40 +1,141 @@
 package main
 
-import "github.com/go-compile/localrelay/v2"
+import (
+	"time"
+
+	"github.com/go-compile/localrelay/v2"
+	"github.com/pkg/errors"
+)
 
 // Relay is a config for a relay server
 type Relay struct {
-	Name     string
-	Listener localrelay.TargetLink
-	// DisableAutoStart will stop the daemon from auto starting this relay
-	AutoRestart bool
+	Name     string                `yaml:"name" json:"name"`
+	Listener localrelay.TargetLink `yaml:"listener" json:"listener"`
+	// Listeners are additional addresses to listen on. Listeners may use
+	// port ranges such as tcp://0.0.0.0:9000-9010.
+	Listeners []localrelay.TargetLink `yaml:"listeners" json:"listeners"`
+	// AutoRestart starts the relay when the daemon starts. Relays which
+	// fail are restarted by the daemon's supervisor either way.
+	AutoRestart bool `yaml:"auto_restart" json:"auto_restart"`
 	// Logging; stdout, ./filename.log
-	Logging string
+	Logging string `yaml:"logging" json:"logging"`
+	// LogFormat is either "text" (default) or "json"
+	LogFormat string `yaml:"log_format" json:"log_format"`
+	// LogLevel is one of debug, info (default), warn or error
+	LogLevel string `yaml:"log_level" json:"log_level"`
+	// AccessLog enables access logging in the "combined" or "json" format
+	AccessLog string `yaml:"access_log" json:"access_log"`
+
+	Destinations []localrelay.TargetLink `yaml:"destinations" json:"destinations"`
+
+	Tls     TLS              `yaml:"tls" json:"tls"`
+	Proxies map[string]Proxy `yaml:"proxies" json:"proxies"`
+
+	Loadbalance Loadbalance `yaml:"loadbalance" json:"loadbalance"`
+
+	// Limits sets the bandwidth limits in bytes per second and the new
+	// connections per second each client IP may open
+	Limits localrelay.Limits `yaml:"limits" json:"limits"`
 
-	Destinations []localrelay.TargetLink
+	// ACL is an ordered list of rules such as "allow 10.0.0.0/8" or "deny all".
+	// The first matching rule decides if a client may connect.
+	ACL []string `yaml:"acl" json:"acl"`
 
-	Tls     TLS
-	Proxies map[string]Proxy
+	// Bans temporarily bans clients which repeatedly offend
+	Bans Bans `yaml:"bans" json:"bans"`
 
-	Loadbalance Loadbalance
+	// Timeouts closes idle and long lived connections
+	Timeouts Timeouts `yaml:"timeouts" json:"timeouts"`
 }
 
 // TLS is used when configuring https proxies
 type TLS struct {
-	Certificate string
-	Private     string
+	Certificate string `yaml:"certificate" json:"certificate"`
+	Private     string `yaml:"private" json:"private"`
 }
 
 // Proxy is used for relay forwarding
 type Proxy struct {
-	Protocol string
-	Address  string
-	Username string
-	Password string
+	Protocol string `yaml:"protocol" json:"protocol"`
+	Address  string `yaml:"address" json:"address"`
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"password"`
+}
+
+// Bans configures automatic temporary banning of abusive clients.
+// A threshold of zero disables banning for that offense.
+type Bans struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Rejected is the amount of connections denied by the ACL before a ban
+	Rejected int `yaml:"rejected" json:"rejected"`
+	// Auth is the amount of failed authentications before a ban, these are
+	// 401 Unauthorized responses from a HTTP(S) relay's destination
+	Auth int `yaml:"auth" json:"auth"`
+	// RateLimit is the amount of connections rejected by the connection
+	// rate limit before a ban
+	RateLimit int `yaml:"rate_limit" json:"rate_limit"`
+	// Window is the period offenses are counted over e.g. "10m"
+	Window string `yaml:"window" json:"window"`
+	// Duration is how long a client is banned for e.g. "1h"
+	Duration string `yaml:"duration" json:"duration"`
+}
+
+// Timeouts configures how long relayed connections are kept alive.
+// Durations are written as "30s", "5m" etc, empty values are disabled.
+type Timeouts struct {
+	// Idle closes connections without traffic in either direction
+	Idle string `yaml:"idle" json:"idle"`
+	// Lifetime closes connections which have been open this long
+	Lifetime string `yaml:"lifetime" json:"lifetime"`
+	// KeepAlive sets the TCP keepalive period, "-1s" disables keepalives
+	KeepAlive string `yaml:"keep_alive" json:"keep_alive"`
+}
+
+// parse converts the config into the relay's timeouts
+func (t Timeouts) parse() (localrelay.Timeouts, error) {
+	var timeouts localrelay.Timeouts
+
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"idle", t.Idle, &timeouts.Idle},
+		{"lifetime", t.Lifetime, &timeouts.Lifetime},
+		{"keepalive", t.KeepAlive, &timeouts.KeepAlive},
+	} {
+		if d.value == "" {
+			continue
+		}
+
+		dur, err := time.ParseDuration(d.value)
+		if err != nil {
+			return timeouts, errors.Wrapf(err, "parsing %s timeout", d.name)
+		}
+
+		*d.dst = dur
+	}
+
+	return timeouts, nil
+}
+
+// validateTargets parses every listener and destination so invalid
+// links are reported when the config is loaded rather than when dialled
+func (r *Relay) validateTargets() error {
+	targets := append([]localrelay.TargetLink{r.Listener}, r.Listeners...)
+	targets = append(targets, r.Destinations...)
+
+	for _, t := range targets {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 type Loadbalance struct {
-	Enabled bool
+	Enabled bool `yaml:"enabled" json:"enabled"`
 }
 
 // IsSet returns true if a proxy has been set
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/naoina/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// configFormat is the encoding of a relay config file
+type configFormat string
+
+const (
+	formatTOML configFormat = "toml"
+	formatYAML configFormat = "yaml"
+	formatJSON configFormat = "json"
+)
+
+var (
+	// ErrNoRelays is returned when a config file does not define any relays
+	ErrNoRelays = errors.New("config does not define any relays")
+	// ErrDuplicateRelay is returned when a config file defines the same relay twice
+	ErrDuplicateRelay = errors.New("relay is defined more than once")
+)
+
+// ConfigFile holds multiple relays and the proxies they share. Relays
+// reference shared proxies by name in the same way as their own, a
+// relay's own proxy takes priority over a shared one of the same name.
+//
+//	[proxies.tor]
+//	protocol = "socks5"
+//	address = "127.0.0.1:9050"
+//
+//	[[relays]]
+//	name = "git"
+//	...
+type ConfigFile struct {
+	Proxies map[string]Proxy `yaml:"proxies" json:"proxies"`
+	Relays  []Relay          `yaml:"relays" json:"relays"`
+}
+
+// formatOf returns the config format for the file's extension, files
+// without a known extension are read as TOML
+func formatOf(file string) configFormat {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".json":
+		return formatJSON
+	default:
+		return formatTOML
+	}
+}
+
+// isConfigFile returns true if the file has a relay config extension and
+// is not one of the daemon's own files
+func isConfigFile(file string) bool {
+	switch filepath.Base(file) {
+	case daemonConfigName, bansFileName:
+		return false
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".toml", ".yaml", ".yml", ".json":
+		return true
+	default:
+		return false
+	}
+}
+
+// readRelayConfigs reads and validates every relay defined in the file
+func readRelayConfigs(file string) ([]Relay, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "file:%q", file)
+	}
+
+	relays, err := decodeRelayConfigs(data, formatOf(file))
+	if err != nil {
+		return nil, errors.Wrapf(err, "file:%q", file)
+	}
+
+	for i := range relays {
+		if err := relays[i].validateTargets(); err != nil {
+			return nil, errors.Wrapf(err, "file:%q", file)
+		}
+
+		if err := relays[i].resolveSecrets(); err != nil {
+			return nil, errors.Wrapf(err, "file:%q relay %q", file, relays[i].Name)
+		}
+	}
+
+	return relays, nil
+}
+
+// decodeRelayConfigs decodes either a single relay or a ConfigFile. A
+// document with a top level "relays" key is treated as a ConfigFile.
+// Environment variables are substituted into the decoded values.
+func decodeRelayConfigs(data []byte, format configFormat) ([]Relay, error) {
+	var keys map[string]interface{}
+	if err := decodeConfig(data, format, &keys, false); err != nil {
+		return nil, err
+	}
+
+	if _, found := keys["relays"]; !found {
+		var relay Relay
+		if err := decodeConfig(data, format, &relay, true); err != nil {
+			return nil, err
+		}
+
+		if err := interpolateEnv(&relay); err != nil {
+			return nil, err
+		}
+
+		return []Relay{relay}, nil
+	}
+
+	var config ConfigFile
+	if err := decodeConfig(data, format, &config, true); err != nil {
+		return nil, err
+	}
+
+	if err := interpolateEnv(&config); err != nil {
+		return nil, err
+	}
+
+	if len(config.Relays) == 0 {
+		return nil, ErrNoRelays
+	}
+
+	names := make(map[string]struct{}, len(config.Relays))
+	for i := range config.Relays {
+		r := &config.Relays[i]
+
+		if _, found := names[r.Name]; found {
+			return nil, errors.Wrapf(ErrDuplicateRelay, "%q", r.Name)
+		}
+
+		names[r.Name] = struct{}{}
+
+		if len(config.Proxies) == 0 {
+			continue
+		}
+
+		proxies := make(map[string]Proxy, len(config.Proxies)+len(r.Proxies))
+		for name, proxy := range config.Proxies {
+			proxies[name] = proxy
+		}
+
+		for name, proxy := range r.Proxies {
+			proxies[name] = proxy
+		}
+
+		r.Proxies = proxies
+	}
+
+	return config.Relays, nil
+}
+
+// decodeConfig decodes data into v. When strict is set unknown fields
+// are rejected, TOML always rejects unknown fields.
+func decodeConfig(data []byte, format configFormat, v interface{}, strict bool) error {
+	switch format {
+	case formatYAML:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(strict)
+
+		// an empty document is reported as io.EOF
+		if err := dec.Decode(v); err != nil && err != io.EOF {
+			return err
+		}
+
+		return nil
+	case formatJSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		if strict {
+			dec.DisallowUnknownFields()
+		}
+
+		return dec.Decode(v)
+	default:
+		if keys, ok := v.(*map[string]interface{}); ok {
+			// TOML keys are matched ignoring case and underscores
+			var raw map[string]interface{}
+			if err := toml.Unmarshal(data, &raw); err != nil {
+				return err
+			}
+
+			*keys = make(map[string]interface{}, len(raw))
+			for key, value := range raw {
+				(*keys)[strings.ToLower(strings.ReplaceAll(key, "_", ""))] = value
+			}
+
+			return nil
+		}
+
+		return toml.Unmarshal(data, v)
+	}
+}
+
+// encodeRelayConfig writes the relay in the given format
+func encodeRelayConfig(w io.Writer, relay Relay, format configFormat) error {
+	switch format {
+	case formatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+
+		if err := enc.Encode(relay); err != nil {
+			return err
+		}
+
+		return enc.Close()
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(relay)
+	default:
+		return toml.NewEncoder(w).Encode(relay)
+	}
+}
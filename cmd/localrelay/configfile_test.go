@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-compile/localrelay/v2"
+	"github.com/pkg/errors"
+)
+
+func TestReadRelayConfigs(t *testing.T) {
+	dir := t.TempDir()
+
+	configs := map[string]string{
+		"single.toml": `name = "single"
+listener = "tcp://127.0.0.1:9000"
+destinations = ["tcp://10.0.0.1:443"]
+`,
+		"multi.toml": `[proxies.tor]
+protocol = "socks5"
+address = "127.0.0.1:9050"
+
+[[relays]]
+name = "git"
+listener = "tcp://127.0.0.1:9000"
+destinations = ["tcp://10.0.0.1:22?proxy=tor"]
+
+[[relays]]
+name = "web"
+listener = "tcp://127.0.0.1:9001"
+destinations = ["tcp://10.0.0.1:443?proxy=tor"]
+`,
+		"multi.yaml": `proxies:
+  tor:
+    protocol: socks5
+    address: 127.0.0.1:9050
+relays:
+  - name: git
+    listener: tcp://127.0.0.1:9000
+    destinations: ["tcp://10.0.0.1:22?proxy=tor"]
+    log_level: debug
+  - name: web
+    listener: tcp://127.0.0.1:9001
+    destinations: ["tcp://10.0.0.1:443?proxy=tor"]
+    proxies:
+      tor:
+        protocol: socks5
+        address: 127.0.0.1:9150
+`,
+		"multi.json": `{
+  "proxies": {"tor": {"protocol": "socks5", "address": "127.0.0.1:9050"}},
+  "relays": [
+    {"name": "git", "listener": "tcp://127.0.0.1:9000", "destinations": ["tcp://10.0.0.1:22?proxy=tor"]},
+    {"name": "web", "listener": "tcp://127.0.0.1:9001", "destinations": ["tcp://10.0.0.1:443?proxy=tor"]}
+  ]
+}`,
+	}
+
+	for name, config := range configs {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	relays, err := readRelayConfigs(filepath.Join(dir, "single.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(relays) != 1 || relays[0].Name != "single" {
+		t.Fatalf("expected relay single got %+v", relays)
+	}
+
+	for _, name := range []string{"multi.toml", "multi.yaml", "multi.json"} {
+		relays, err := readRelayConfigs(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if len(relays) != 2 || relays[0].Name != "git" || relays[1].Name != "web" {
+			t.Fatalf("%s: expected relays git and web got %+v", name, relays)
+		}
+
+		if relays[0].Proxies["tor"].Address != "127.0.0.1:9050" {
+			t.Errorf("%s: shared proxy was not applied to git", name)
+		}
+	}
+
+	relays, err = readRelayConfigs(filepath.Join(dir, "multi.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if relays[0].LogLevel != "debug" {
+		t.Errorf("expected log level debug got %q", relays[0].LogLevel)
+	}
+
+	if relays[1].Proxies["tor"].Address != "127.0.0.1:9150" {
+		t.Errorf("expected the relay's own proxy to override the shared proxy")
+	}
+}
+
+func TestReadRelayConfigsErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	configs := map[string]error{
+		`{"name": "a", "listner": "tcp://127.0.0.1:9000"}`: nil,
+		`{"relays": []}`: ErrNoRelays,
+		`{"relays": [{"name": "a", "listener": "tcp://127.0.0.1:1", "destinations": ["tcp://127.0.0.1:2"]}, {"name": "a", "listener": "tcp://127.0.0.1:3", "destinations": ["tcp://127.0.0.1:4"]}]}`: ErrDuplicateRelay,
+	}
+
+	for config, expected := range configs {
+		file := filepath.Join(dir, "relay.json")
+		if err := os.WriteFile(file, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := readRelayConfigs(file)
+		if err == nil || (expected != nil && !errors.Is(err, expected)) {
+			t.Errorf("%s: expected error %v got %v", config, expected, err)
+		}
+	}
+}
+
+func TestEncodeRelayConfig(t *testing.T) {
+	relay := Relay{
+		Name:         "round-trip",
+		Listener:     "tcp://127.0.0.1:9000",
+		Destinations: []localrelay.TargetLink{"tcp://10.0.0.1:443?proxy=tor"},
+		LogFormat:    "json",
+		Proxies:      map[string]Proxy{"tor": {Protocol: "socks5", Address: "127.0.0.1:9050"}},
+	}
+
+	for _, format := range []configFormat{formatTOML, formatYAML, formatJSON} {
+		var buf bytes.Buffer
+		if err := encodeRelayConfig(&buf, relay, format); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		relays, err := decodeRelayConfigs(buf.Bytes(), format)
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", format, err, buf.String())
+		}
+
+		r := relays[0]
+		if r.Name != relay.Name || r.Listener != relay.Listener || r.LogFormat != relay.LogFormat ||
+			len(r.Destinations) != 1 || r.Proxies["tor"] != relay.Proxies["tor"] {
+			t.Errorf("%s: decoded %+v", format, r)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/containerd/console"
@@ -65,7 +66,67 @@ func printConn(conn api.Connection, onlyIPS bool) {
 		return
 	}
 
-	Printf("%s -> %s (%s) (%s)\r\n", conn.RemoteAddr, conn.ForwardedAddr, conn.RelayName, formatDuration(time.Since(time.Unix(conn.Opened, 0))))
+	Printf("[%d] %s -> %s (%s) (%s)\r\n", conn.ID, conn.RemoteAddr, fmtForwarded(conn), conn.RelayName, formatDuration(time.Since(time.Unix(conn.Opened, 0))))
+}
+
+// fmtForwarded returns the destination address or the connection state
+// if the destination is still being dialled
+func fmtForwarded(conn api.Connection) string {
+	if conn.ForwardedAddr == "" {
+		return "\x1b[92m" + conn.State + "\x1b[0m"
+	}
+
+	return conn.ForwardedAddr
+}
+
+func displayConn(opt *options) error {
+	if len(opt.commands) < 2 {
+		Println("Provide a connection ID.")
+		return nil
+	}
+
+	id, err := strconv.ParseUint(opt.commands[1], 10, 64)
+	if err != nil {
+		Println("Invalid connection ID.")
+		return nil
+	}
+
+	conn, err := getConnection(id)
+	if err != nil {
+		return err
+	}
+
+	proxy := conn.Proxy
+	if proxy == "" {
+		proxy = "-"
+	}
+
+	Printf("ID:          %d\r\n", conn.ID)
+	Printf("Relay:       %s (%s)\r\n", conn.RelayName, conn.RelayHost)
+	Printf("Client:      %s\r\n", conn.RemoteAddr)
+	Printf("Destination: %s -> %s\r\n", conn.Destination, fmtForwarded(*conn))
+	Printf("Proxy:       %s\r\n", proxy)
+	Printf("State:       %s\r\n", conn.State)
+	Printf("In/Out:      [%s/%s]\r\n", formatBytes(int(conn.BytesIn)), formatBytes(int(conn.BytesOut)))
+	Printf("Opened:      %s ago\r\n", formatDuration(time.Since(time.Unix(conn.Opened, 0))))
+	Printf("Last Active: %s ago\r\n", formatDuration(time.Since(time.Unix(conn.LastActive, 0))))
+
+	return nil
+}
+
+func dropConnID(opt *options) error {
+	if len(opt.commands) < 2 {
+		Println("Provide a connection ID.")
+		return nil
+	}
+
+	id, err := strconv.ParseUint(opt.commands[1], 10, 64)
+	if err != nil {
+		Println("Invalid connection ID.")
+		return nil
+	}
+
+	return dropConn(id)
 }
 
 func arrayContains(arr []string, element string) bool {
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-compile/localrelay/internal/ipc"
+	"github.com/go-compile/localrelay/v2"
+	"github.com/naoina/toml"
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+// daemonConfigName is the file within the config dir which configures
+// the daemon. It is never read as a relay config.
+const daemonConfigName = "daemon.toml"
+
+var (
+	// ErrAdminNotLoopback is returned when the admin listener is not a loopback address
+	ErrAdminNotLoopback = errors.New("admin listener must be a loopback address")
+	// ErrAdminToken is returned when the admin listener has no token set
+	ErrAdminToken = errors.New("admin listener requires an admin_token")
+	// ErrMaxRelays is returned when launching a relay would exceed the max relays
+	ErrMaxRelays = errors.New("the maximum amount of relays are running")
+
+	daemonConfigM sync.Mutex
+	daemonConf    DaemonConfig
+
+	// defaultDialTimeout is used when the daemon config does not set one
+	defaultDialTimeout = localrelay.Timeout
+)
+
+// DaemonConfig configures the service daemon. Empty values keep the
+// defaults. The CLI reads the same file to find the IPC socket.
+type DaemonConfig struct {
+	// IPCDir is the dir the IPC unix socket is created in, unused on Windows
+	IPCDir string
+	// RelaysDir holds the relay configs launched when the daemon starts
+	RelaysDir string
+	// LogDir holds relay logs, access logs and captures
+	LogDir string
+
+	// Metrics is the address OpenMetrics is served on e.g. 127.0.0.1:9100.
+	// The LOCALRELAY_METRICS environment variable takes priority.
+	Metrics string
+	// Admin is a loopback TCP address to serve the IPC API on
+	Admin string
+	// AdminToken must be sent as a bearer token by admin API requests, it
+	// may be read from a file with the "file:" prefix
+	AdminToken string
+
+	// DialTimeout is the default timeout when dialling destinations e.g. "5s"
+	DialTimeout string
+	// IPCTimeout is the read and write timeout of IPC requests
+	IPCTimeout string
+	// DrainTimeout is how long relays wait for their connections to close
+	// when the daemon stops, after which the connections are closed
+	DrainTimeout string
+
+	// Defaults are applied to relays which do not set them
+	Defaults RelayDefaults
+
+	// Resources limit the daemon's use of the system
+	Resources Resources
+
+	// Supervisor restarts relays which fail
+	Supervisor Supervisor
+}
+
+// RelayDefaults are the settings the daemon uses for relays which leave
+// them unset
+type RelayDefaults struct {
+	LogFormat string
+	LogLevel  string
+	AccessLog string
+	ACL       []string
+	Limits    localrelay.Limits
+	Timeouts  Timeouts
+}
+
+// Resources limits the daemon
+type Resources struct {
+	// MaxRelays is the most relays which can run at once
+	MaxRelays int
+	// OpenFiles raises the open file limit, each connection uses at least two
+	OpenFiles uint64
+}
+
+// daemonConfigPath is where the daemon config is read from
+func daemonConfigPath() string {
+	return filepath.Join(configSystemDir(), configDirSuffix, daemonConfigName)
+}
+
+// readDaemonConfig reads and validates the daemon config. A missing file
+// returns the defaults.
+func readDaemonConfig(file string) (DaemonConfig, error) {
+	var conf DaemonConfig
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return conf, nil
+	}
+
+	if err != nil {
+		return conf, err
+	}
+
+	if err := toml.Unmarshal(data, &conf); err != nil {
+		return conf, errors.Wrapf(err, "file:%q", file)
+	}
+
+	if err := interpolateEnv(&conf); err != nil {
+		return conf, errors.Wrapf(err, "file:%q", file)
+	}
+
+	if err := conf.validate(); err != nil {
+		return conf, errors.Wrapf(err, "file:%q", file)
+	}
+
+	return conf, nil
+}
+
+// validate checks the values which are parsed when applied
+func (c *DaemonConfig) validate() error {
+	for name, d := range map[string]string{
+		"dial_timeout":  c.DialTimeout,
+		"ipc_timeout":   c.IPCTimeout,
+		"drain_timeout": c.DrainTimeout,
+	} {
+		if d == "" {
+			continue
+		}
+
+		if _, err := time.ParseDuration(d); err != nil {
+			return errors.Wrapf(err, "parsing %s", name)
+		}
+	}
+
+	if c.Admin != "" {
+		host, _, err := net.SplitHostPort(c.Admin)
+		if err != nil {
+			return errors.Wrap(err, "admin")
+		}
+
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return errors.Wrapf(ErrAdminNotLoopback, "%q", c.Admin)
+		}
+
+		// any local user or web page could otherwise control the daemon
+		if token, err := readSecret(c.AdminToken); err != nil {
+			return errors.Wrap(err, "admin_token")
+		} else if token == "" {
+			return ErrAdminToken
+		}
+	}
+
+	if _, err := localrelay.ParseLogFormat(c.Defaults.LogFormat); err != nil {
+		return errors.Wrap(err, "defaults")
+	}
+
+	if _, err := localrelay.ParseLogLevel(c.Defaults.LogLevel); err != nil {
+		return errors.Wrap(err, "defaults")
+	}
+
+	if c.Defaults.AccessLog != "" {
+		if _, err := localrelay.ParseAccessLogFormat(c.Defaults.AccessLog); err != nil {
+			return errors.Wrap(err, "defaults")
+		}
+	}
+
+	if _, err := localrelay.ParseACL(c.Defaults.ACL); err != nil {
+		return errors.Wrap(err, "defaults")
+	}
+
+	if _, err := c.Defaults.Timeouts.parse(); err != nil {
+		return errors.Wrap(err, "defaults")
+	}
+
+	return c.Supervisor.validate()
+}
+
+// daemonConfig returns the loaded daemon config
+func daemonConfig() DaemonConfig {
+	daemonConfigM.Lock()
+	defer daemonConfigM.Unlock()
+
+	return daemonConf
+}
+
+// setDaemonConfig stores the config and applies the settings which do not
+// need the daemon to be running
+func setDaemonConfig(c DaemonConfig) {
+	daemonConfigM.Lock()
+	daemonConf = c
+	daemonConfigM.Unlock()
+
+	if c.IPCDir != "" {
+		ipc.SetPathPrefix(filepath.Clean(c.IPCDir) + string(filepath.Separator))
+	}
+
+	localrelay.Timeout = defaultDialTimeout
+	if c.DialTimeout != "" {
+		localrelay.Timeout, _ = time.ParseDuration(c.DialTimeout)
+	}
+}
+
+// loadDaemonConfig reads the daemon config so the CLI and daemon agree on
+// paths. Errors are reported but the defaults are still usable.
+func loadDaemonConfig() error {
+	conf, err := readDaemonConfig(daemonConfigPath())
+	if err != nil {
+		return err
+	}
+
+	setDaemonConfig(conf)
+	return nil
+}
+
+// reloadDaemonConfig rereads the daemon config while the daemon is running.
+// Dirs and the IPC socket are only changed by restarting the daemon.
+func reloadDaemonConfig() {
+	old := daemonConfig()
+
+	conf, err := readDaemonConfig(daemonConfigPath())
+	if err != nil {
+		log.Printf("[Error] Reloading daemon config: %s\n", err)
+		return
+	}
+
+	if conf.IPCDir != old.IPCDir || conf.RelaysDir != old.RelaysDir || conf.LogDir != old.LogDir {
+		log.Printf("[Warn] Dir changes in %s apply after the daemon restarts\n", daemonConfigName)
+		conf.IPCDir, conf.RelaysDir, conf.LogDir = old.IPCDir, old.RelaysDir, old.LogDir
+	}
+
+	setDaemonConfig(conf)
+
+	if err := applyResources(conf.Resources); err != nil {
+		log.Printf("[Error] Applying resource limits: %s\n", err)
+	}
+
+	if conf.Metrics != old.Metrics {
+		closeMetrics()
+
+		if err := serveMetrics(); err != nil {
+			log.Printf("[Error] Failed to serve metrics: %s\n", err)
+		}
+	}
+
+	if conf.Admin != old.Admin || conf.AdminToken != old.AdminToken {
+		closeAdmin()
+
+		if err := serveAdmin(); err != nil {
+			log.Printf("[Error] Failed to serve admin API: %s\n", err)
+		}
+	}
+
+	log.Printf("[Info] Reloaded %s\n", daemonConfigName)
+}
+
+// applyResources sets the daemon's resource limits
+func applyResources(r Resources) error {
+	if r.OpenFiles == 0 {
+		return nil
+	}
+
+	return setOpenFilesLimit(r.OpenFiles)
+}
+
+// apply sets the defaults on a relay which leaves them unset
+func (d RelayDefaults) apply(r *Relay) {
+	if r.LogFormat == "" {
+		r.LogFormat = d.LogFormat
+	}
+
+	if r.LogLevel == "" {
+		r.LogLevel = d.LogLevel
+	}
+
+	if r.AccessLog == "" {
+		r.AccessLog = d.AccessLog
+	}
+
+	if len(r.ACL) == 0 {
+		r.ACL = d.ACL
+	}
+
+	if !r.Limits.Enabled() {
+		r.Limits = d.Limits
+	}
+
+	for _, t := range []struct{ value, fallback *string }{
+		{&r.Timeouts.Idle, &d.Timeouts.Idle},
+		{&r.Timeouts.Lifetime, &d.Timeouts.Lifetime},
+		{&r.Timeouts.KeepAlive, &d.Timeouts.KeepAlive},
+	} {
+		if *t.value == "" {
+			*t.value = *t.fallback
+		}
+	}
+}
+
+// ipcTimeouts returns the read and write timeout of the IPC server
+func ipcTimeouts() time.Duration {
+	if d, err := time.ParseDuration(daemonConfig().IPCTimeout); err == nil {
+		return d
+	}
+
+	return time.Second * 60
+}
+
+// drainTimeout returns how long relays are drained for when the daemon stops
+func drainTimeout() time.Duration {
+	if d, err := time.ParseDuration(daemonConfig().DrainTimeout); err == nil {
+		return d
+	}
+
+	return time.Second * 10
+}
+
+// adminListener is set while the IPC API is served over TCP
+var adminListener net.Listener
+
+// serveAdmin serves the IPC API on the admin address if one has been set
+func serveAdmin() error {
+	conf := daemonConfig()
+	if conf.Admin == "" {
+		return nil
+	}
+
+	token, err := readSecret(conf.AdminToken)
+	if err != nil {
+		return err
+	}
+
+	if token == "" {
+		return ErrAdminToken
+	}
+
+	l, err := net.Listen("tcp", conf.Admin)
+	if err != nil {
+		return err
+	}
+
+	adminListener = l
+	log.Printf("[Info] Serving admin API on http://%s\n", l.Addr())
+
+	srv := newIPCServer()
+	srv.Handler = adminAuthMiddleware(token, srv.Handler)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			if err != nil {
+				continue
+			}
+
+			go handleConn(conn, srv, l)
+		}
+	}()
+
+	return nil
+}
+
+// adminAuthMiddleware rejects admin API requests without the bearer token.
+// Requests with an Origin header are rejected as they were sent by a web
+// page, browsers always set it on cross origin requests.
+func adminAuthMiddleware(token string, handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	expected := []byte("Bearer " + token)
+
+	return func(ctx *fasthttp.RequestCtx) {
+		if len(ctx.Request.Header.Peek("Origin")) > 0 {
+			ctx.SetContentType("application/json")
+			ctx.SetStatusCode(403)
+			ctx.Write([]byte(`{"message":"Cross origin requests are not allowed."}`))
+			return
+		}
+
+		if subtle.ConstantTimeCompare(ctx.Request.Header.Peek("Authorization"), expected) != 1 {
+			ctx.SetContentType("application/json")
+			ctx.SetStatusCode(401)
+			ctx.Write([]byte(`{"message":"Invalid admin token."}`))
+			return
+		}
+
+		handler(ctx)
+	}
+}
+
+// closeAdmin stops serving the IPC API over TCP
+func closeAdmin() {
+	if adminListener != nil {
+		adminListener.Close()
+		adminListener = nil
+	}
+}
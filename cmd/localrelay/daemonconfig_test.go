@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+func TestReadDaemonConfig(t *testing.T) {
+	t.Setenv("LR_TEST_ADMIN_TOKEN", "s3cret")
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, daemonConfigName)
+
+	conf, err := readDaemonConfig(file)
+	if err != nil {
+		t.Fatalf("missing config should use the defaults: %v", err)
+	}
+
+	if conf.RelaysDir != "" || conf.Resources.MaxRelays != 0 {
+		t.Fatalf("expected an empty config got %+v", conf)
+	}
+
+	err = os.WriteFile(file, []byte(`relays_dir = "/srv/localrelay"
+metrics = "127.0.0.1:9100"
+admin = "127.0.0.1:9101"
+admin_token = "${LR_TEST_ADMIN_TOKEN}"
+dial_timeout = "10s"
+drain_timeout = "30s"
+
+[defaults]
+log_format = "json"
+acl = ["allow 10.0.0.0/8", "deny all"]
+
+[defaults.timeouts]
+idle = "5m"
+
+[resources]
+max_relays = 20
+
+[supervisor]
+backoff = "2s"
+max_attempts = 10
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err = readDaemonConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.RelaysDir != "/srv/localrelay" || conf.Metrics != "127.0.0.1:9100" || conf.Resources.MaxRelays != 20 {
+		t.Fatalf("unexpected config %+v", conf)
+	}
+
+	if conf.AdminToken != "s3cret" {
+		t.Fatalf("expected the admin token from the environment got %q", conf.AdminToken)
+	}
+
+	if conf.Supervisor.Backoff != "2s" || conf.Supervisor.MaxAttempts != 10 {
+		t.Fatalf("unexpected supervisor %+v", conf.Supervisor)
+	}
+
+	setDaemonConfig(conf)
+	t.Cleanup(func() { setDaemonConfig(DaemonConfig{}) })
+
+	if drainTimeout() != time.Second*30 {
+		t.Errorf("expected a 30s drain timeout got %s", drainTimeout())
+	}
+
+	relay := Relay{LogFormat: "text", Timeouts: Timeouts{Lifetime: "1h"}}
+	conf.Defaults.apply(&relay)
+
+	if relay.LogFormat != "text" {
+		t.Errorf("default overwrote the relay's log format")
+	}
+
+	if len(relay.ACL) != 2 || relay.Timeouts.Idle != "5m" || relay.Timeouts.Lifetime != "1h" {
+		t.Errorf("defaults were not applied %+v", relay)
+	}
+
+	if err := os.WriteFile(file, []byte(`admin = "0.0.0.0:9101"`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readDaemonConfig(file); !errors.Is(err, ErrAdminNotLoopback) {
+		t.Fatalf("expected ErrAdminNotLoopback got %v", err)
+	}
+
+	if err := os.WriteFile(file, []byte(`admin = "127.0.0.1:9101"`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readDaemonConfig(file); !errors.Is(err, ErrAdminToken) {
+		t.Fatalf("expected ErrAdminToken got %v", err)
+	}
+
+	if err := os.WriteFile(file, []byte("[supervisor]\nbackoff = \"soon\""), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readDaemonConfig(file); err == nil {
+		t.Fatal("expected an invalid backoff to be rejected")
+	}
+}
+
+func TestIsConfigFile(t *testing.T) {
+	tests := map[string]bool{
+		"/etc/localrelay/git.toml":       true,
+		"/etc/localrelay/relays.yml":     true,
+		"/etc/localrelay/relays.json":    true,
+		"/etc/localrelay/daemon.toml":    false,
+		"/etc/localrelay/bans.json":      false,
+		"/etc/localrelay/git.access.log": false,
+		"/etc/localrelay/git-1.lrcap":    false,
+	}
+
+	for file, expected := range tests {
+		if isConfigFile(file) != expected {
+			t.Errorf("%s: expected %v", file, expected)
+		}
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	handler := adminAuthMiddleware("s3cret", func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(200)
+	})
+
+	tests := []struct {
+		authorization, origin string
+		status                int
+	}{
+		{"", "", 401},
+		{"Bearer wrong", "", 401},
+		{"s3cret", "", 401},
+		{"Bearer s3cret", "https://example.com", 403},
+		{"Bearer s3cret", "null", 403},
+		{"Bearer s3cret", "", 200},
+	}
+
+	for _, test := range tests {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.SetRequestURI("/stop/test")
+
+		if test.authorization != "" {
+			ctx.Request.Header.Set("Authorization", test.authorization)
+		}
+
+		if test.origin != "" {
+			ctx.Request.Header.Set("Origin", test.origin)
+		}
+
+		handler(&ctx)
+
+		if status := ctx.Response.StatusCode(); status != test.status {
+			t.Errorf("%q %q: expected %d got %d", test.authorization, test.origin, test.status, status)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-compile/localrelay/v2"
+)
+
+// metricsAddrEnv is the environment variable holding the TCP address the
+// daemon serves OpenMetrics on, e.g. 127.0.0.1:9100. It overrides the
+// metrics address of the daemon config.
+const metricsAddrEnv = "LOCALRELAY_METRICS"
+
+// metricsServer is set while the daemon is serving metrics
+var metricsServer *http.Server
+
+// serveMetrics starts the OpenMetrics endpoint if an address has been set
+func serveMetrics() error {
+	addr := os.Getenv(metricsAddrEnv)
+	if addr == "" {
+		addr = daemonConfig().Metrics
+	}
+
+	if addr == "" {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", &localrelay.MetricsExporter{
+		Relays:  runningRelays,
+		Version: VERSION,
+		Started: daemonStarted,
+	})
+
+	metricsServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: time.Second * 10,
+		WriteTimeout:      time.Second * 30,
+	}
+
+	log.Printf("[Info] Serving metrics on http://%s/metrics\n", l.Addr())
+
+	go func() {
+		if err := metricsServer.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Printf("[Error] Metrics server: %s\n", err)
+		}
+	}()
+
+	return nil
+}
+
+// closeMetrics stops the OpenMetrics endpoint
+func closeMetrics() {
+	if metricsServer != nil {
+		metricsServer.Close()
+		metricsServer = nil
+	}
+}
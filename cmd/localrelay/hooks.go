@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+
+	"github.com/go-compile/localrelay/pkg/api"
+	"github.com/go-compile/localrelay/v2"
+)
+
+// daemonTotals counts traffic across all relays for the lifetime of the
+// daemon, unlike relay metrics these survive relays being stopped
+var daemonTotals struct {
+	in, out   int64
+	accepted  uint64
+	streams   uint64
+	dialFails uint64
+	// restarts counts the failed relays restarted by the daemon
+	restarts uint64
+}
+
+// relayHooks feeds the daemon's log and totals from relay events
+func relayHooks() localrelay.Hooks {
+	return localrelay.Hooks{
+		OnStart: func(r *localrelay.Relay) {
+			log.Printf("[Info] [Relay:%s] Listening on %q\n", r.Name, r.Listener)
+		},
+		OnStop: func(r *localrelay.Relay, err error) {
+			if err != nil {
+				log.Printf("[Error] [Relay:%s] Stopped: %s\n", r.Name, err)
+				return
+			}
+
+			log.Printf("[Info] [Relay:%s] Stopped\n", r.Name)
+		},
+		OnAccept: func(r *localrelay.Relay, e localrelay.ConnEvent) {
+			atomic.AddUint64(&daemonTotals.accepted, 1)
+		},
+		OnDialFail: func(r *localrelay.Relay, e localrelay.DialEvent) {
+			atomic.AddUint64(&daemonTotals.dialFails, 1)
+
+			if e.Proxy != "" {
+				log.Printf("[Warn] [Relay:%s] Dialling %q through proxy %q failed: %s\n", r.Name, e.Destination, e.Proxy, e.Err)
+				return
+			}
+
+			log.Printf("[Warn] [Relay:%s] Dialling %q failed: %s\n", r.Name, e.Destination, e.Err)
+		},
+		OnStreamEnd: func(r *localrelay.Relay, e localrelay.StreamEvent) {
+			atomic.AddUint64(&daemonTotals.streams, 1)
+			atomic.AddInt64(&daemonTotals.in, e.BytesIn)
+			atomic.AddInt64(&daemonTotals.out, e.BytesOut)
+		},
+	}
+}
+
+func totals() api.Totals {
+	return api.Totals{
+		Accepted:  atomic.LoadUint64(&daemonTotals.accepted),
+		Streams:   atomic.LoadUint64(&daemonTotals.streams),
+		DialFails: atomic.LoadUint64(&daemonTotals.dialFails),
+		In:        atomic.LoadInt64(&daemonTotals.in),
+		Out:       atomic.LoadInt64(&daemonTotals.out),
+		Restarts:  atomic.LoadUint64(&daemonTotals.restarts),
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"time"
+
 	"github.com/go-compile/localrelay/pkg/api"
+	"github.com/go-compile/localrelay/v2"
+	"github.com/pkg/errors"
 )
 
 // serviceRun takes paths to relay config files and then connects via IPC to
@@ -41,7 +45,9 @@ func serviceStatus() (*api.Status, error) {
 	return status, err
 }
 
-func stopRelay(relayName string) error {
+// stopRelay closes the relay, if drain is set the relay's connections are
+// given until the drain timeout to close
+func stopRelay(relayName string, drain time.Duration) error {
 	c, err := api.Connect()
 	if err != nil {
 		return err
@@ -49,7 +55,13 @@ func stopRelay(relayName string) error {
 
 	defer c.Close()
 
-	err = c.StopRelay(relayName)
+	if drain > 0 {
+		Printf("Draining relay %q for up to %s.\n", relayName, drain)
+		err = c.ShutdownRelay(relayName, drain)
+	} else {
+		err = c.StopRelay(relayName)
+	}
+
 	if err == nil {
 		Printf("Relay %q has been stopped.\n", relayName)
 		return nil
@@ -58,6 +70,23 @@ func stopRelay(relayName string) error {
 	return err
 }
 
+// requestRestart reopens the listeners of a relay or starts a failed relay
+func requestRestart(relayName string) error {
+	c, err := api.Connect()
+	if err != nil {
+		return err
+	}
+
+	defer c.Close()
+
+	if err := c.RestartRelay(relayName); err != nil {
+		return err
+	}
+
+	Printf("Relay %q has been restarted.\n", relayName)
+	return nil
+}
+
 func activeConnections() ([]api.Connection, error) {
 	c, err := api.Connect()
 	if err != nil {
@@ -69,6 +98,34 @@ func activeConnections() ([]api.Connection, error) {
 	return c.GetConnections()
 }
 
+func getConnection(id uint64) (*api.Connection, error) {
+	c, err := api.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.Close()
+
+	return c.GetConnection(id)
+}
+
+func dropConn(id uint64) error {
+	c, err := api.Connect()
+	if err != nil {
+		return err
+	}
+
+	defer c.Close()
+
+	if err := c.DropConn(id); err != nil {
+		Printf("Failed to drop connection. Err: %s.\n", err)
+		return nil
+	}
+
+	Printf("Connection %d has been dropped.\r\n", id)
+	return nil
+}
+
 func dropAll() error {
 	c, err := api.Connect()
 	if err != nil {
@@ -114,3 +171,110 @@ func dropRelay(relay string) error {
 
 	return nil
 }
+
+// setLimit updates one scope of a relay's bandwidth limits
+func setLimit(relay, scope string, limit localrelay.Limit) error {
+	c, err := api.Connect()
+	if err != nil {
+		return err
+	}
+
+	defer c.Close()
+
+	status, err := c.GetStatus()
+	if err != nil {
+		return err
+	}
+
+	m, found := status.Metrics[relay]
+	if !found {
+		return api.ErrNotFound
+	}
+
+	limits := m.Limits
+	switch scope {
+	case "relay":
+		limits.Relay = limit
+	case "conn", "connection":
+		limits.Conn = limit
+	case "ip":
+		limits.IP = limit
+	default:
+		return ErrInvalidLimitScope
+	}
+
+	if err := c.SetLimits(relay, limits); err != nil {
+		return err
+	}
+
+	Printf("Relay %q %s limit set to %s.\n", relay, scope, fmtLimit(limit))
+	return nil
+}
+
+// reloadCommand asks the daemon to reload the relay configs and prints
+// the changes
+func reloadCommand() error {
+	c, err := api.Connect()
+	if err != nil {
+		return err
+	}
+
+	defer c.Close()
+
+	result, err := c.Reload()
+	if err != nil {
+		return err
+	}
+
+	for _, change := range []struct {
+		action string
+		relays []string
+	}{
+		{"Started", result.Started},
+		{"Stopped", result.Stopped},
+		{"Updated", result.Updated},
+		{"Restarted", result.Restarted},
+	} {
+		for _, name := range change.relays {
+			Printf("%s %q\n", change.action, name)
+		}
+	}
+
+	for _, e := range result.Errors {
+		Printf("[Error] %s\n", e)
+	}
+
+	if len(result.Errors) > 0 {
+		return errors.Errorf("%d errors reloading relays", len(result.Errors))
+	}
+
+	Println("Relays have been reloaded.")
+	return nil
+}
+
+func listBans() ([]api.Ban, error) {
+	c, err := api.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.Close()
+
+	return c.GetBans()
+}
+
+func unban(ip string) error {
+	c, err := api.Connect()
+	if err != nil {
+		return err
+	}
+
+	defer c.Close()
+
+	if err := c.Unban(ip); err != nil {
+		return err
+	}
+
+	Printf("%q has been unbanned.\n", ip)
+	return nil
+}
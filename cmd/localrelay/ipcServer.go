@@ -11,7 +11,7 @@ import (
 	"github.com/fasthttp/router"
 	"github.com/go-compile/localrelay/pkg/api"
 	"github.com/go-compile/localrelay/v2"
-	"github.com/naoina/toml"
+	"github.com/pkg/errors"
 
 	"github.com/valyala/fasthttp"
 )
@@ -27,20 +27,34 @@ func newIPCServer() *fasthttp.Server {
 	return &fasthttp.Server{
 		Handler:      ipcHeadersMiddleware(r.Handler),
 		Name:         "localrelay-ipc",
-		ReadTimeout:  time.Second * 60,
-		WriteTimeout: time.Second * 60,
+		ReadTimeout:  ipcTimeouts(),
+		WriteTimeout: ipcTimeouts(),
 	}
 }
 
 func assignIPCRoutes(r *router.Router) {
 	r.GET("/", ipcRouteRoot)
-	r.GET("/stop/{relay}", ipcRouteStop)
+	r.POST("/stop/{relay}", ipcRouteStop)
+	r.POST("/restart/{relay}", ipcRouteRestart)
 	r.POST("/run", ipcRouteRun)
+	r.POST("/reload", ipcRouteReload)
 	r.GET("/status", ipcRouteStatus)
 	r.GET("/connections", ipcRouteConns)
-	r.GET("/drop", ipcRouteDropAll)
-	r.GET("/drop/ip/{ip}", ipcRouteDropIP)
-	r.GET("/drop/relay/{relay}", ipcRouteDropRelay)
+	r.GET("/connection/{id}", ipcRouteConn)
+	r.POST("/drop", ipcRouteDropAll)
+	r.POST("/drop/ip/{ip}", ipcRouteDropIP)
+	r.POST("/drop/relay/{relay}", ipcRouteDropRelay)
+	r.POST("/drop/conn/{id}", ipcRouteDropConn)
+	r.POST("/limits/{relay}", ipcRouteLimits)
+	r.GET("/acl/{relay}", ipcRouteGetACL)
+	r.POST("/acl/{relay}", ipcRouteSetACL)
+	r.GET("/destinations/{relay}", ipcRouteDestinations)
+	r.POST("/destinations/{relay}", ipcRouteChangeDestination)
+	r.POST("/proxies/{relay}", ipcRouteChangeProxy)
+	r.POST("/capture/{relay}", ipcRouteStartCapture)
+	r.POST("/capture/stop/{relay}", ipcRouteStopCapture)
+	r.GET("/bans", ipcRouteBans)
+	r.POST("/unban/{ip}", ipcRouteUnban)
 }
 
 func ipcHeadersMiddleware(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
@@ -72,6 +86,30 @@ func ipcRouteStop(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	// failed relays have already stopped and are kept to report their error
+	if relay.State() == localrelay.StateFailed {
+		releaseRelay(relay)
+
+		ctx.SetStatusCode(200)
+		ctx.Write([]byte(`{"message":"Relay has been closed."}`))
+		return
+	}
+
+	if drain := ctx.QueryArgs().Peek("drain"); len(drain) > 0 {
+		timeout, err := time.ParseDuration(string(drain))
+		if err != nil {
+			ctx.SetStatusCode(400)
+			ctx.Write([]byte(`{"message":"Invalid drain timeout."}`))
+			return
+		}
+
+		shutdownRelay(relay, timeout)
+
+		ctx.SetStatusCode(200)
+		ctx.Write([]byte(`{"message":"Relay has been drained and closed."}`))
+		return
+	}
+
 	if err := relay.Close(); err != nil {
 		ctx.SetStatusCode(500)
 		ctx.Write([]byte(`{"message":"Error encountered when trying to close the relay."}`))
@@ -84,6 +122,24 @@ func ipcRouteStop(ctx *fasthttp.RequestCtx) {
 	return
 }
 
+func ipcRouteRestart(ctx *fasthttp.RequestCtx) {
+	relay, found := getRelay(ctx.UserValue("relay").(string))
+	if !found {
+		ctx.SetStatusCode(404)
+		ctx.Write([]byte(`{"message":"Relay not found."}`))
+		return
+	}
+
+	if err := relaunchRelay(relay); err != nil {
+		ctx.SetStatusCode(409)
+		ctx.Write([]byte(`{"message":` + strconv.Quote(err.Error()) + `}`))
+		return
+	}
+
+	ctx.SetStatusCode(200)
+	ctx.Write([]byte(`{"message":"Relay has been restarted."}`))
+}
+
 func ipcRouteRun(ctx *fasthttp.RequestCtx) {
 	var files []string
 
@@ -115,54 +171,97 @@ func ipcRouteRun(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	f, err := os.Open(relayFile)
+	relays, err := readRelayConfigs(relayFile)
 	if err != nil {
-		ctx.SetStatusCode(500)
-		ctx.Write([]byte(`{"message":"Failed to open relay config."}`))
-		return
-	}
-
-	var relay Relay
-	if err := toml.NewDecoder(f).Decode(&relay); err != nil {
-		f.Close()
 		ctx.SetStatusCode(500)
 		ctx.Write([]byte(`{"message":"Failed to decode relay config."}`))
 		return
 	}
 
-	f.Close()
+	// relays from the same file which are already running are left as is
+	stopped := make([]Relay, 0, len(relays))
+	for _, relay := range relays {
+		if !isRunning(relay.Name) {
+			stopped = append(stopped, relay)
+		}
+	}
 
-	if isRunning(relay.Name) {
+	if len(stopped) == 0 {
 		ctx.SetStatusCode(500)
 		ctx.Write([]byte(`{"message":"Relay is already running."}`))
 		return
 	}
 
-	if err := launchRelays([]Relay{relay}, false); err != nil {
+	if err := launchRelays(stopped, false); err != nil {
 		ctx.SetStatusCode(500)
 		ctx.Write([]byte(`{"message":` + strconv.Quote("Error launching relay. "+err.Error()) + `}`))
 		return
 	}
 
+	trackRelays(relayFile, stopped...)
+
 	ctx.SetStatusCode(200)
 	ctx.Write([]byte(`{"message":"Relay successfully launched."}`))
 	return
 }
 
+func ipcRouteReload(ctx *fasthttp.RequestCtx) {
+	result := reloadRelays()
+	logReload(result)
+
+	ctx.SetStatusCode(200)
+	json.NewEncoder(ctx).Encode(result)
+}
+
 func ipcRouteStatus(ctx *fasthttp.RequestCtx) {
 	relayMetrics := make(map[string]api.Metrics)
+	states := make(map[string]api.RelayState)
+
+	running := runningRelays()
+	relays := make([]localrelay.Relay, 0, len(running))
+
+	for _, r := range running {
+		status := r.Status()
+
+		state := api.RelayState{
+			State:    string(status.State),
+			Restarts: status.Restarts,
+		}
+
+		if status.LastError != nil {
+			state.LastError = status.LastError.Error()
+		}
 
-	relays := runningRelaysCopy()
-	for _, r := range relays {
-		active, total := r.Metrics.Connections()
+		if !status.Started.IsZero() {
+			state.Started = status.Started.Unix()
+		}
+
+		states[r.Name] = state
+
+		m := r.Metrics.Snapshot()
 		relayMetrics[r.Name] = api.Metrics{
-			In:            r.Metrics.Download(),
-			Out:           r.Metrics.Upload(),
-			Active:        active,
+			In:            int(m.Download),
+			Out:           int(m.Upload),
+			Active:        m.Active,
 			DialAvg:       r.DialerAvg(),
-			TotalConns:    total,
-			TotalRequests: r.Metrics.Requests(),
+			TotalConns:    m.TotalConns,
+			TotalRequests: m.Requests,
+
+			Limits:     r.Limits(),
+			Throttled:  m.Throttled,
+			Throttling: r.Throttling(),
+			Denied:     m.Denied,
+			Closed:     closeReasons(m.CloseReasons),
+
+			DialLatency:  latency(m.DialLatency),
+			ConnDuration: latency(m.ConnDuration),
+			TTFB:         latency(m.TTFB),
+			Destinations: targets(m.Destinations),
+			Proxies:      targets(m.Proxies),
 		}
+
+		// relays are changed at runtime so are copied under their lock
+		relays = append(relays, r.Snapshot())
 	}
 
 	ctx.SetStatusCode(200)
@@ -171,28 +270,53 @@ func ipcRouteStatus(ctx *fasthttp.RequestCtx) {
 		Pid:     os.Getpid(),
 		Version: VERSION,
 		Started: daemonStarted.Unix(),
+		Totals:  totals(),
 
 		Metrics: relayMetrics,
+		States:  states,
 	})
 }
 
+// latency converts a histogram into its percentiles in milliseconds
+func latency(h localrelay.HistogramSnapshot) api.Latency {
+	return api.Latency{
+		Count: h.Count,
+		P50:   float64(h.P50) / float64(time.Millisecond),
+		P90:   float64(h.P90) / float64(time.Millisecond),
+		P99:   float64(h.P99) / float64(time.Millisecond),
+	}
+}
+
+func targets(metrics map[string]localrelay.TargetMetrics) map[string]api.Target {
+	targets := make(map[string]api.Target, len(metrics))
+	for name, m := range metrics {
+		targets[name] = api.Target{
+			Dials:       m.Dials,
+			Failures:    m.Failures,
+			In:          m.Download,
+			Out:         m.Upload,
+			DialLatency: latency(m.DialLatency),
+		}
+	}
+
+	return targets
+}
+
+func closeReasons(reasons map[localrelay.CloseReason]uint64) map[string]uint64 {
+	closed := make(map[string]uint64, len(reasons))
+	for reason, n := range reasons {
+		closed[string(reason)] = n
+	}
+
+	return closed
+}
+
 func ipcRouteConns(ctx *fasthttp.RequestCtx) {
 	relayConns := make([]api.Connection, 0, 200)
 
-	relays := runningRelaysCopy()
-	for _, r := range relays {
+	for _, r := range runningRelays() {
 		for _, conn := range r.GetConns() {
-			relayConns = append(relayConns, api.Connection{
-				LocalAddr:  conn.Conn.LocalAddr().String(),
-				RemoteAddr: conn.Conn.RemoteAddr().String(),
-				Network:    conn.Conn.LocalAddr().Network(),
-
-				RelayName:     r.Name,
-				RelayHost:     string(r.Listener),
-				ForwardedAddr: conn.RemoteAddr,
-
-				Opened: conn.Opened.Unix(),
-			})
+			relayConns = append(relayConns, apiConnection(r, conn))
 		}
 	}
 
@@ -200,12 +324,55 @@ func ipcRouteConns(ctx *fasthttp.RequestCtx) {
 	json.NewEncoder(ctx).Encode(relayConns)
 }
 
+func ipcRouteConn(ctx *fasthttp.RequestCtx) {
+	id, err := strconv.ParseUint(ctx.UserValue("id").(string), 10, 64)
+	if err != nil {
+		ctx.SetStatusCode(400)
+		ctx.Write([]byte(`{"message":"Invalid connection ID."}`))
+		return
+	}
+
+	for _, r := range runningRelays() {
+		if conn, found := r.GetConn(id); found {
+			ctx.SetStatusCode(200)
+			json.NewEncoder(ctx).Encode(apiConnection(r, conn))
+			return
+		}
+	}
+
+	ctx.SetStatusCode(404)
+	ctx.Write([]byte(`{"message":"Connection not found."}`))
+}
+
+// apiConnection converts a connection snapshot into its API model
+func apiConnection(r *localrelay.Relay, conn *localrelay.PooledConn) api.Connection {
+	return api.Connection{
+		ID: conn.ID,
+
+		LocalAddr:  conn.Conn.LocalAddr().String(),
+		RemoteAddr: conn.Conn.RemoteAddr().String(),
+		Network:    conn.Conn.LocalAddr().Network(),
+
+		RelayName:     r.Name,
+		RelayHost:     string(r.Listener),
+		ForwardedAddr: conn.RemoteAddr,
+		Destination:   string(conn.Destination),
+		Proxy:         conn.Proxy,
+		State:         string(conn.State),
+
+		BytesIn:  conn.BytesIn,
+		BytesOut: conn.BytesOut,
+
+		Opened:     conn.Opened.Unix(),
+		LastActive: conn.LastActive.Unix(),
+	}
+}
+
 func ipcRouteDropAll(ctx *fasthttp.RequestCtx) {
-	relays := runningRelaysCopy()
 	// iterate through all relays and close every connection
-	for _, r := range relays {
+	for _, r := range runningRelays() {
 		for _, conn := range r.GetConns() {
-			go conn.Conn.Close()
+			go conn.Drop()
 		}
 	}
 }
@@ -213,9 +380,8 @@ func ipcRouteDropAll(ctx *fasthttp.RequestCtx) {
 func ipcRouteDropIP(ctx *fasthttp.RequestCtx) {
 	ip := ctx.UserValue("ip").(string)
 
-	relays := runningRelaysCopy()
 	// iterate through all relays and close every connection
-	for _, r := range relays {
+	for _, r := range runningRelays() {
 		for _, conn := range r.GetConns() {
 			host, _, err := net.SplitHostPort(conn.Conn.RemoteAddr().String())
 			if err != nil {
@@ -224,7 +390,7 @@ func ipcRouteDropIP(ctx *fasthttp.RequestCtx) {
 			}
 
 			if host == ip {
-				go conn.Conn.Close()
+				go conn.Drop()
 			}
 		}
 	}
@@ -233,15 +399,248 @@ func ipcRouteDropIP(ctx *fasthttp.RequestCtx) {
 func ipcRouteDropRelay(ctx *fasthttp.RequestCtx) {
 	relay := ctx.UserValue("relay").(string)
 
-	relays := runningRelaysCopy()
 	// iterate through all relays and close every connection
-	for _, r := range relays {
+	for _, r := range runningRelays() {
 		if r.Name != relay {
 			continue
 		}
 
 		for _, conn := range r.GetConns() {
-			go conn.Conn.Close()
+			go conn.Drop()
+		}
+	}
+}
+
+func ipcRouteDropConn(ctx *fasthttp.RequestCtx) {
+	id, err := strconv.ParseUint(ctx.UserValue("id").(string), 10, 64)
+	if err != nil {
+		ctx.SetStatusCode(400)
+		ctx.Write([]byte(`{"message":"Invalid connection ID."}`))
+		return
+	}
+
+	for _, r := range runningRelays() {
+		if r.DropConn(id) {
+			ctx.SetStatusCode(200)
+			ctx.Write([]byte(`{"message":"Connection dropped."}`))
+			return
+		}
+	}
+
+	ctx.SetStatusCode(404)
+	ctx.Write([]byte(`{"message":"Connection not found."}`))
+}
+
+func ipcRouteLimits(ctx *fasthttp.RequestCtx) {
+	relay, found := getRelay(ctx.UserValue("relay").(string))
+	if !found {
+		ctx.SetStatusCode(404)
+		ctx.Write([]byte(`{"message":"Relay not found."}`))
+		return
+	}
+
+	// fields missing from the body keep their current value
+	limits := relay.Limits()
+	if err := json.Unmarshal(ctx.Request.Body(), &limits); err != nil {
+		ctx.SetStatusCode(400)
+		ctx.Write([]byte(`{"message":"Invalid json body."}`))
+		return
+	}
+
+	relay.SetLimits(limits)
+
+	ctx.SetStatusCode(200)
+	ctx.Write([]byte(`{"message":"Relay limits have been updated."}`))
+}
+
+func ipcRouteGetACL(ctx *fasthttp.RequestCtx) {
+	relay, found := getRelay(ctx.UserValue("relay").(string))
+	if !found {
+		ctx.SetStatusCode(404)
+		ctx.Write([]byte(`{"message":"Relay not found."}`))
+		return
+	}
+
+	acl := relay.ACL()
+
+	rules := make([]string, len(acl))
+	for i, rule := range acl {
+		rules[i] = rule.String()
+	}
+
+	ctx.SetStatusCode(200)
+	json.NewEncoder(ctx).Encode(rules)
+}
+
+func ipcRouteSetACL(ctx *fasthttp.RequestCtx) {
+	relay, found := getRelay(ctx.UserValue("relay").(string))
+	if !found {
+		ctx.SetStatusCode(404)
+		ctx.Write([]byte(`{"message":"Relay not found."}`))
+		return
+	}
+
+	var rules []string
+	if err := json.Unmarshal(ctx.Request.Body(), &rules); err != nil {
+		ctx.SetStatusCode(400)
+		ctx.Write([]byte(`{"message":"Invalid json body."}`))
+		return
+	}
+
+	acl, err := localrelay.ParseACL(rules)
+	if err != nil {
+		ctx.SetStatusCode(400)
+		ctx.Write([]byte(`{"message":` + strconv.Quote(err.Error()) + `}`))
+		return
+	}
+
+	relay.SetACL(acl)
+
+	ctx.SetStatusCode(200)
+	ctx.Write([]byte(`{"message":"Relay ACL has been updated."}`))
+}
+
+func ipcRouteDestinations(ctx *fasthttp.RequestCtx) {
+	relay, found := getRelay(ctx.UserValue("relay").(string))
+	if !found {
+		ctx.SetStatusCode(404)
+		ctx.Write([]byte(`{"message":"Relay not found."}`))
+		return
+	}
+
+	statuses := relay.DestinationStatuses()
+
+	destinations := make([]api.Destination, len(statuses))
+	for i, d := range statuses {
+		destinations[i] = api.Destination{
+			Destination: string(d.Destination),
+			State:       string(d.State),
+			Conns:       d.Conns,
 		}
 	}
+
+	ctx.SetStatusCode(200)
+	json.NewEncoder(ctx).Encode(destinations)
+}
+
+func ipcRouteChangeDestination(ctx *fasthttp.RequestCtx) {
+	relay, found := getRelay(ctx.UserValue("relay").(string))
+	if !found {
+		ctx.SetStatusCode(404)
+		ctx.Write([]byte(`{"message":"Relay not found."}`))
+		return
+	}
+
+	var change api.DestinationChange
+	if err := json.Unmarshal(ctx.Request.Body(), &change); err != nil {
+		ctx.SetStatusCode(400)
+		ctx.Write([]byte(`{"message":"Invalid json body."}`))
+		return
+	}
+
+	if err := changeDestination(relay, change); err != nil {
+		ctx.SetStatusCode(409)
+		ctx.Write([]byte(`{"message":` + strconv.Quote(err.Error()) + `}`))
+		return
+	}
+
+	ctx.SetStatusCode(200)
+	ctx.Write([]byte(`{"message":"Relay destinations have been updated."}`))
+}
+
+func ipcRouteChangeProxy(ctx *fasthttp.RequestCtx) {
+	relay, found := getRelay(ctx.UserValue("relay").(string))
+	if !found {
+		ctx.SetStatusCode(404)
+		ctx.Write([]byte(`{"message":"Relay not found."}`))
+		return
+	}
+
+	var change api.ProxyChange
+	if err := json.Unmarshal(ctx.Request.Body(), &change); err != nil {
+		ctx.SetStatusCode(400)
+		ctx.Write([]byte(`{"message":"Invalid json body."}`))
+		return
+	}
+
+	if err := changeProxy(relay, change); err != nil {
+		ctx.SetStatusCode(409)
+		ctx.Write([]byte(`{"message":` + strconv.Quote(err.Error()) + `}`))
+		return
+	}
+
+	ctx.SetStatusCode(200)
+	ctx.Write([]byte(`{"message":"Relay proxies have been updated."}`))
+}
+
+func ipcRouteStartCapture(ctx *fasthttp.RequestCtx) {
+	relay, found := getRelay(ctx.UserValue("relay").(string))
+	if !found {
+		ctx.SetStatusCode(404)
+		ctx.Write([]byte(`{"message":"Relay not found."}`))
+		return
+	}
+
+	var ips []string
+	if err := json.Unmarshal(ctx.Request.Body(), &ips); err != nil {
+		ctx.SetStatusCode(400)
+		ctx.Write([]byte(`{"message":"Invalid json body."}`))
+		return
+	}
+
+	path, err := startCapture(relay, ips)
+	if err != nil {
+		if errors.Is(err, localrelay.ErrCaptureRunning) {
+			ctx.SetStatusCode(409)
+		} else {
+			ctx.SetStatusCode(500)
+		}
+
+		ctx.Write([]byte(`{"message":` + strconv.Quote(err.Error()) + `}`))
+		return
+	}
+
+	ctx.SetStatusCode(200)
+	json.NewEncoder(ctx).Encode(api.Capture{Path: path})
+}
+
+func ipcRouteStopCapture(ctx *fasthttp.RequestCtx) {
+	relay, found := getRelay(ctx.UserValue("relay").(string))
+	if !found {
+		ctx.SetStatusCode(404)
+		ctx.Write([]byte(`{"message":"Relay not found."}`))
+		return
+	}
+
+	if err := relay.StopCapture(); err != nil {
+		ctx.SetStatusCode(409)
+		ctx.Write([]byte(`{"message":` + strconv.Quote(err.Error()) + `}`))
+		return
+	}
+
+	ctx.SetStatusCode(200)
+	ctx.Write([]byte(`{"message":"Capture has been stopped."}`))
+}
+
+func ipcRouteBans(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(200)
+	json.NewEncoder(ctx).Encode(activeBans())
+}
+
+func ipcRouteUnban(ctx *fasthttp.RequestCtx) {
+	found, err := unbanIP(ctx.UserValue("ip").(string))
+	if err != nil {
+		ctx.SetStatusCode(500)
+		ctx.Write([]byte(`{"message":` + strconv.Quote("Error updating stored bans. "+err.Error()) + `}`))
+		return
+	}
+
+	if !found {
+		ctx.SetStatusCode(404)
+		ctx.Write([]byte(`{"message":"IP is not banned."}`))
+		return
+	}
+
+	ctx.SetStatusCode(200)
+	ctx.Write([]byte(`{"message":"IP has been unbanned."}`))
 }
@@ -46,8 +46,28 @@ func (l *logger) Close() error {
 	return l.w.Close()
 }
 
+// logDir is where the daemon writes relay logs, it can be moved by the
+// daemon config
+func logDir() string {
+	if dir := daemonConfig().LogDir; dir != "" {
+		return dir
+	}
+
+	return "/var/log/localrelay/"
+}
+
+// accessLogPath is where the daemon writes a relay's access log
+func accessLogPath(relayName string) string {
+	return filepath.Join(logDir(), relayName+".access.log")
+}
+
+// captureDir is where the daemon writes traffic captures
+func captureDir() string {
+	return logDir()
+}
+
 func newLogger(relayName string) *logger {
-	f, err := os.OpenFile(filepath.Join("/var/log/localrelay/", relayName+".log"), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	f, err := os.OpenFile(filepath.Join(logDir(), relayName+".log"), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"os"
+	"path/filepath"
 
 	"golang.org/x/sys/windows"
 	"golang.org/x/sys/windows/svc/eventlog"
@@ -33,6 +34,27 @@ func (l *logger) Close() error {
 	return l.w.Close()
 }
 
+// logDir is where the daemon writes access logs and captures. Relay logs
+// are sent to the event log. Windows has no log dir so unless set by the
+// daemon config files are kept with the relay configs.
+func logDir() string {
+	if dir := daemonConfig().LogDir; dir != "" {
+		return dir
+	}
+
+	return relaysDir()
+}
+
+// accessLogPath is where the daemon writes a relay's access log
+func accessLogPath(relayName string) string {
+	return filepath.Join(logDir(), relayName+".access.log")
+}
+
+// captureDir is where the daemon writes traffic captures
+func captureDir() string {
+	return logDir()
+}
+
 func newLogger(relayName string) *logger {
 	w, err := eventlog.Open("localrelayd")
 	if err != nil {
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-compile/localrelay/v2"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidLimitScope is returned when the limit scope is not relay, conn or ip
+	ErrInvalidLimitScope = errors.New("limit scope must be relay, conn or ip")
+)
+
+// relayLimit handles: localrelay limit <relay> <relay|conn|ip> <upload> <download>
+func relayLimit(opt *options) error {
+	if len(opt.commands) < 5 {
+		Println("Usage: localrelay limit <relay> <relay|conn|ip> <upload> <download>")
+		return nil
+	}
+
+	relayName := opt.commands[1]
+	if !validateName(relayName) {
+		Println("[WARN] Invalid relay name.")
+		return nil
+	}
+
+	up, err := parseBytes(opt.commands[3])
+	if err != nil {
+		return errors.Wrap(err, "parsing upload limit")
+	}
+
+	down, err := parseBytes(opt.commands[4])
+	if err != nil {
+		return errors.Wrap(err, "parsing download limit")
+	}
+
+	return setLimit(relayName, strings.ToLower(opt.commands[2]), localrelay.Limit{
+		Upload:   up,
+		Download: down,
+	})
+}
+
+// parseBytes parses sizes such as "500", "500kb" or "2mb" into bytes
+func parseBytes(size string) (int64, error) {
+	size = strings.ToLower(strings.TrimSpace(size))
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"gb", 1000000000},
+		{"mb", 1000000},
+		{"kb", 1000},
+		{"b", 1},
+	} {
+		if strings.HasSuffix(size, unit.suffix) {
+			size = strings.TrimSuffix(size, unit.suffix)
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(size, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if n < 0 {
+		return 0, errors.New("size can not be negative")
+	}
+
+	return int64(n * float64(multiplier)), nil
+}
+
+// fmtLimit formats a limit as upload/download per second
+func fmtLimit(l localrelay.Limit) string {
+	return fmtRate(l.Upload) + "/" + fmtRate(l.Download)
+}
+
+func fmtRate(rate int64) string {
+	if rate <= 0 {
+		return "-"
+	}
+
+	return formatBytes(int(rate)) + "/s"
+}
@@ -49,6 +49,11 @@ func main() {
 
 	httperror.SetVersion(VERSION)
 
+	// read before the arguments so flags take priority
+	if err := loadDaemonConfig(); err != nil {
+		log.Printf("[Warn] %s\n", err)
+	}
+
 	opt, err := parseArgs()
 	if err == nil && opt == nil {
 		return
@@ -118,7 +123,7 @@ func main() {
 				return
 			}
 
-			if err := stopRelay(opt.commands[1]); err != nil {
+			if err := stopRelay(opt.commands[1], opt.drain); err != nil {
 				log.Fatalf("[Error] Failed to stop service: %s\n", err)
 			}
 
@@ -171,12 +176,21 @@ func main() {
 			}
 
 			return
-			// restart will rerun the service but will not restore previously ran relays
+			// restart will rerun the service but will not restore previously ran relays,
+			// with a relay name only the relay is restarted
 		case "restart":
 			if !privCommand(true) {
 				return
 			}
 
+			if len(opt.commands) > 1 {
+				if err := requestRestart(opt.commands[1]); err != nil {
+					log.Fatalf("[Error] Failed to restart relay: %s\n", err)
+				}
+
+				return
+			}
+
 			if err := s.Restart(); err != nil {
 				log.Fatalf("[Error] Failed to restart service: %s\n", err)
 			}
@@ -255,6 +269,110 @@ func main() {
 				Println(err)
 			}
 			return
+		case "conn":
+			if !privCommand(true) {
+				return
+			}
+
+			if err := displayConn(opt); err != nil {
+				Println(err)
+				os.Exit(1)
+			}
+			return
+		case "dropconn":
+			if !privCommand(true) {
+				return
+			}
+
+			if err := dropConnID(opt); err != nil {
+				Println(err)
+			}
+			return
+		case "limit":
+			if !privCommand(true) {
+				return
+			}
+
+			if err := relayLimit(opt); err != nil {
+				Println(err)
+			}
+			return
+		case "acl":
+			if !privCommand(true) {
+				return
+			}
+
+			if err := relayACL(opt); err != nil {
+				Println(err)
+			}
+			return
+		case "dst", "destination", "destinations":
+			if !privCommand(true) {
+				return
+			}
+
+			if err := dstCommand(opt); err != nil {
+				Println(err)
+				os.Exit(1)
+			}
+			return
+		case "proxy":
+			if !privCommand(true) {
+				return
+			}
+
+			if err := proxyCommand(opt); err != nil {
+				Println(err)
+				os.Exit(1)
+			}
+			return
+		case "capture":
+			if !privCommand(true) {
+				return
+			}
+
+			if err := captureCommand(opt); err != nil {
+				Println(err)
+			}
+			return
+		case "replay":
+			if err := replayCommand(opt); err != nil {
+				Println(err)
+				os.Exit(1)
+			}
+			return
+		case "validate", "lint":
+			validateCommand(opt, i)
+			return
+		case "reload":
+			if !privCommand(true) {
+				return
+			}
+
+			if err := reloadCommand(); err != nil {
+				Println(err)
+				os.Exit(1)
+			}
+			return
+		case "bans":
+			if !privCommand(true) {
+				return
+			}
+
+			if err := displayBans(); err != nil {
+				Println(err)
+				os.Exit(1)
+			}
+			return
+		case "unban":
+			if !privCommand(true) {
+				return
+			}
+
+			if err := unbanCommand(opt); err != nil {
+				Println(err)
+			}
+			return
 		case "droprelay":
 			if !privCommand(true) {
 				return
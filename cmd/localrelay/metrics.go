@@ -2,11 +2,13 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -93,6 +95,8 @@ func relayMetrics(opt *options) error {
 	}()
 
 	running := 0
+	// lines is the height of the last render
+	lines := 0
 	// set ticker to micro second to triger metrics to render instantly
 	// then change tricker within case statement to correct interval
 	ticker := time.NewTicker(time.Microsecond)
@@ -101,11 +105,9 @@ func relayMetrics(opt *options) error {
 	for {
 		select {
 		case <-sig:
-			// make a guess how far to move the cursor
-			if len(relays) != 0 {
-				Printf("\x1b[%dB", (len(relays)*2)+2)
-			} else {
-				Printf("\x1b[%dB", (len(status.Metrics)*2)+2)
+			// move the cursor below the last render
+			if lines > 0 {
+				Printf("\x1b[%dB", lines)
 			}
 
 			return nil
@@ -136,14 +138,12 @@ func relayMetrics(opt *options) error {
 				return metrics[i].name < metrics[j].name
 			})
 
-			count := len(relays)
+			lines = 2
 
 			// if not filter present, show all
 			if len(relays) == 0 {
-				count = len(metrics)
-
 				for _, m := range metrics {
-					printMetrics(m.name, m.Metrics)
+					lines += printMetrics(m.name, m.Metrics)
 				}
 			} else {
 				// sort will be based on order of input args
@@ -153,16 +153,70 @@ func relayMetrics(opt *options) error {
 						return errors.Wrapf(ErrRelayNotRunning, "%s", v)
 					}
 
-					printMetrics(v, m)
+					lines += printMetrics(v, m)
 				}
 			}
 
-			Printf("\r\n\x1b[2K  [Running Relays: %d] [In/Out: %s/%s]\r\n", running, formatBytes(totalInOut[0]), formatBytes(totalInOut[1]))
-			Printf("\x1b[%dA", (count*2)+2)
+			// clear any lines left over from a taller render
+			Printf("\r\n\x1b[2K  [Running Relays: %d] [In/Out: %s/%s]\r\n\x1b[0J", running, formatBytes(totalInOut[0]), formatBytes(totalInOut[1]))
+			Printf("\x1b[%dA", lines)
 		}
 	}
 }
 
-func printMetrics(name string, m api.Metrics) {
-	Printf("\x1b[2K \x1b[90m%s\x1b[0m\r\n\x1b[2K  [In/Out:%s/%s] [DialAvg:%dms] [Active:%d] [Total:%d]\r\n", name, formatBytes(m.In), formatBytes(m.Out), m.DialAvg, m.Active, m.TotalConns+m.TotalRequests)
+// printMetrics renders a relay's metrics and returns the lines printed
+func printMetrics(name string, m api.Metrics) int {
+	throttle := ""
+	if m.Limits.Enabled() {
+		throttle = fmt.Sprintf(" [Limits relay:%s conn:%s ip:%s] [Throttling:%d/%d]", fmtLimit(m.Limits.Relay),
+			fmtLimit(m.Limits.Conn), fmtLimit(m.Limits.IP), m.Throttling, m.Throttled)
+	}
+
+	Printf("\x1b[2K \x1b[90m%s\x1b[0m\r\n\x1b[2K  [In/Out:%s/%s] [DialAvg:%dms] [Active:%d] [Total:%d]%s\r\n", name, formatBytes(m.In), formatBytes(m.Out), m.DialAvg, m.Active, m.TotalConns+m.TotalRequests, throttle)
+
+	latency := fmt.Sprintf("[Dial p50/p90/p99:%s] [Duration p50/p90/p99:%s]", fmtLatency(m.DialLatency), fmtLatency(m.ConnDuration))
+	if m.TTFB.Count > 0 {
+		latency += fmt.Sprintf(" [TTFB p50/p90/p99:%s]", fmtLatency(m.TTFB))
+	}
+
+	Printf("\x1b[2K  %s\r\n", latency)
+
+	return 3 + printTargets("", m.Destinations) + printTargets("proxy ", m.Proxies)
+}
+
+// printTargets renders per destination or proxy metrics sorted by name
+// and returns the lines printed
+func printTargets(prefix string, targets map[string]api.Target) int {
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		t := targets[name]
+		Printf("\x1b[2K    \x1b[90m%s%s\x1b[0m [Dials:%d] [Fails:%d] [In/Out:%s/%s] [Dial p50/p90/p99:%s]\r\n", prefix, name,
+			t.Dials, t.Failures, formatBytes(int(t.In)), formatBytes(int(t.Out)), fmtLatency(t.DialLatency))
+	}
+
+	return len(names)
+}
+
+// fmtLatency formats the percentiles of a latency histogram
+func fmtLatency(l api.Latency) string {
+	if l.Count == 0 {
+		return "-"
+	}
+
+	return fmtMillis(l.P50) + "/" + fmtMillis(l.P90) + "/" + fmtMillis(l.P99)
+}
+
+func fmtMillis(ms float64) string {
+	d := time.Duration(ms * float64(time.Millisecond))
+	if d < time.Second {
+		return strconv.FormatFloat(ms, 'f', 1, 64) + "ms"
+	}
+
+	return d.Round(time.Millisecond * 10).String()
 }
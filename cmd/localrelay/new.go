@@ -9,7 +9,6 @@ import (
 
 	"github.com/chzyer/readline"
 	"github.com/go-compile/localrelay/v2"
-	"github.com/naoina/toml"
 	"github.com/pkg/errors"
 )
 
@@ -107,7 +106,7 @@ func newRelay(opt *options, i int, cmd []string) error {
 				return err
 			}
 
-			if err := toml.NewEncoder(f).Encode(relay); err != nil {
+			if err := encodeRelayConfig(f, relay, formatOf(filename)); err != nil {
 				return err
 			}
 
@@ -151,7 +150,7 @@ func newRelay(opt *options, i int, cmd []string) error {
 		return err
 	}
 
-	if err := toml.NewEncoder(f).Encode(relay); err != nil {
+	if err := encodeRelayConfig(f, relay, formatOf(filename)); err != nil {
 		return err
 	}
 
@@ -172,8 +171,7 @@ func parseBool(input string) (bool, error) {
 }
 
 func createConfigDir() error {
-	home := configSystemDir()
-	dir := filepath.Join(home, configDirSuffix)
+	dir := relaysDir()
 
 	exists, err := pathExists(dir)
 	if err != nil {
@@ -182,7 +180,7 @@ func createConfigDir() error {
 
 	// already exists, don't recreate it
 	if !exists {
-		if err := os.Mkdir(dir, 0644); err != nil {
+		if err := os.MkdirAll(dir, 0644); err != nil {
 			return err
 		}
 	}
@@ -195,7 +193,7 @@ func createLogDir() error {
 		return nil
 	}
 
-	dir := "/var/log/localrelay/"
+	dir := logDir()
 
 	exists, err := pathExists(dir)
 	if err != nil {
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-compile/localrelay/pkg/api"
+	"github.com/go-compile/localrelay/v2"
+	"github.com/pkg/errors"
+)
+
+// ErrRelayStopTimeout is returned when a relay being restarted does not stop in time
+var ErrRelayStopTimeout = errors.New("relay did not stop in time")
+
+// relaySource is the file and config a relay was launched from
+type relaySource struct {
+	file   string
+	config Relay
+}
+
+var (
+	// relaySources maps relay names to where they were launched from so
+	// the configs can be reloaded. Guarded by activeRelaysM.
+	relaySources = make(map[string]relaySource)
+
+	// reloadM prevents a SIGHUP and an IPC reload from running at once
+	reloadM sync.Mutex
+)
+
+// trackRelays records the file the relays were read from
+func trackRelays(file string, relays ...Relay) {
+	activeRelaysM.Lock()
+	defer activeRelaysM.Unlock()
+
+	for _, r := range relays {
+		relaySources[r.Name] = relaySource{file: file, config: r}
+	}
+}
+
+func untrackRelay(name string) {
+	activeRelaysM.Lock()
+	delete(relaySources, name)
+	activeRelaysM.Unlock()
+}
+
+// trackedRelays returns a copy of the relay sources
+func trackedRelays() map[string]relaySource {
+	activeRelaysM.Lock()
+	defer activeRelaysM.Unlock()
+
+	sources := make(map[string]relaySource, len(relaySources))
+	for name, src := range relaySources {
+		sources[name] = src
+	}
+
+	return sources
+}
+
+// reloadFiles returns the files of the tracked relays and the config
+// files in the relays dir
+func reloadFiles(tracked map[string]relaySource) []string {
+	files := make(map[string]struct{}, len(tracked))
+	for _, src := range tracked {
+		files[src.file] = struct{}{}
+	}
+
+	if dir, err := os.ReadDir(relaysDir()); err == nil {
+		for _, entry := range dir {
+			if isConfigFile(entry.Name()) && !entry.IsDir() {
+				files[filepath.Join(relaysDir(), entry.Name())] = struct{}{}
+			}
+		}
+	}
+
+	list := make([]string, 0, len(files))
+	for file := range files {
+		list = append(list, file)
+	}
+
+	sort.Strings(list)
+	return list
+}
+
+// reloadRelays rereads the relay configs and applies the differences.
+// New relays with auto_restart set are started and removed relays are
+// drained and stopped. Changes to
+// destinations, proxies, load balancing, ACLs, limits and timeouts are
+// applied to the running relay without dropping connections, any other
+// change restarts the relay. Relays in a file which can not be read are
+// left as they are.
+func reloadRelays() api.Reload {
+	reloadM.Lock()
+	defer reloadM.Unlock()
+
+	var result api.Reload
+	tracked := trackedRelays()
+
+	desired := make(map[string]relaySource, len(tracked))
+	failed := make(map[string]bool)
+
+	for _, file := range reloadFiles(tracked) {
+		relays, err := readRelayConfigs(file)
+		if os.IsNotExist(errors.Cause(err)) {
+			// the file was removed along with its relays
+			continue
+		}
+
+		if err != nil {
+			failed[file] = true
+			result.Errors = append(result.Errors, err.Error())
+			continue
+		}
+
+		for _, r := range relays {
+			if src, found := desired[r.Name]; found {
+				result.Errors = append(result.Errors, errors.Wrapf(ErrDuplicateRelay, "%q in %q and %q", r.Name, src.file, file).Error())
+				continue
+			}
+
+			desired[r.Name] = relaySource{file: file, config: r}
+		}
+	}
+
+	for name, src := range tracked {
+		if _, found := desired[name]; found || failed[src.file] {
+			continue
+		}
+
+		untrackRelay(name)
+
+		if relay, running := getRelay(name); running {
+			if err := closeRelay(relay); err != nil {
+				result.Errors = append(result.Errors, errors.Wrapf(err, "relay %q", name).Error())
+				continue
+			}
+
+			result.Stopped = append(result.Stopped, name)
+		}
+	}
+
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		src := desired[name]
+		old, known := tracked[name]
+		relay, running := getRelay(name)
+
+		var err error
+		switch {
+		case !known && running:
+			// already running but launched from elsewhere
+			trackRelays(src.file, src.config)
+		case !known && !src.config.AutoRestart:
+			// left for the user to start, like when the daemon starts
+			continue
+		case !known:
+			if err = launchRelays([]Relay{src.config}, false); err == nil {
+				trackRelays(src.file, src.config)
+				result.Started = append(result.Started, name)
+			}
+		case !running, reflect.DeepEqual(old.config, src.config):
+			// stopped relays use the new config when next started
+			trackRelays(src.file, src.config)
+		case hotReloadable(old.config, src.config):
+			if err = applyRelayConfig(relay, src.config); err == nil {
+				trackRelays(src.file, src.config)
+				result.Updated = append(result.Updated, name)
+			}
+		default:
+			if err = restartRelay(relay, src.config); err == nil {
+				trackRelays(src.file, src.config)
+				result.Restarted = append(result.Restarted, name)
+			}
+		}
+
+		if err != nil {
+			result.Errors = append(result.Errors, errors.Wrapf(err, "relay %q", name).Error())
+		}
+	}
+
+	return result
+}
+
+// hotReloadable returns true if every change between the configs can be
+// applied to the running relay
+func hotReloadable(old, new Relay) bool {
+	old.Destinations, old.Proxies, old.Loadbalance = new.Destinations, new.Proxies, new.Loadbalance
+	old.ACL, old.Limits, old.Timeouts = new.ACL, new.Limits, new.Timeouts
+	old.AutoRestart = new.AutoRestart
+
+	return reflect.DeepEqual(old, new)
+}
+
+// applyRelayConfig updates a running relay. Established connections are
+// kept, new connections use the new config.
+func applyRelayConfig(relay *localrelay.Relay, r Relay) error {
+	if isService {
+		daemonConfig().Defaults.apply(&r)
+	}
+
+	proxies, err := r.proxyURLs()
+	if err != nil {
+		return err
+	}
+
+	rules, err := localrelay.ParseACL(r.ACL)
+	if err != nil {
+		return err
+	}
+
+	timeouts, err := r.Timeouts.parse()
+	if err != nil {
+		return err
+	}
+
+	// destinations are validated against the listeners so are set first,
+	// if they are rejected the relay is left unchanged
+	if err := relay.SetDestinations(r.Destinations...); err != nil {
+		return err
+	}
+
+	relay.SetProxy(proxies)
+	relay.SetLoadbalance(r.Loadbalance.Enabled)
+	relay.SetLimits(r.Limits)
+	relay.SetACL(rules)
+	relay.SetTimeouts(timeouts)
+
+	return nil
+}
+
+// closeRelay drains the relay for up to the drain timeout and waits for
+// it to stop. A relay which is still starting has no listener to close so
+// closing is retried. Failed relays have already stopped so are released.
+func closeRelay(relay *localrelay.Relay) error {
+	if relay.Running() {
+		shutdownRelay(relay, drainTimeout())
+	}
+
+	for start := time.Now(); isRunning(relay.Name); time.Sleep(time.Millisecond * 10) {
+		if relay.State() == localrelay.StateFailed {
+			releaseRelay(relay)
+			break
+		}
+
+		if time.Since(start) > time.Second*5 {
+			return ErrRelayStopTimeout
+		}
+
+		relay.Close()
+	}
+
+	return nil
+}
+
+// restartRelay drains the relay and launches it with the new config once
+// it has stopped
+func restartRelay(relay *localrelay.Relay, r Relay) error {
+	if err := closeRelay(relay); err != nil {
+		return err
+	}
+
+	return launchRelays([]Relay{r}, false)
+}
+
+// logReload reports the outcome of a reload in the daemon's log
+func logReload(result api.Reload) {
+	log.Printf("[Info] Reloaded relays: %d started, %d stopped, %d updated, %d restarted\n",
+		len(result.Started), len(result.Stopped), len(result.Updated), len(result.Restarted))
+
+	for _, err := range result.Errors {
+		log.Printf("[Error] Reloading relays: %s\n", err)
+	}
+}
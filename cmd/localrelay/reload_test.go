@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-compile/localrelay/v2"
+)
+
+func TestHotReloadable(t *testing.T) {
+	old := Relay{
+		Name:         "git",
+		Listener:     "tcp://127.0.0.1:9000",
+		Destinations: []localrelay.TargetLink{"tcp://10.0.0.1:22"},
+	}
+
+	hot := old
+	hot.Destinations = []localrelay.TargetLink{"tcp://10.0.0.2:22?proxy=tor", "tcp://10.0.0.3:22"}
+	hot.Proxies = map[string]Proxy{"tor": {Protocol: "socks5", Address: "127.0.0.1:9050"}}
+	hot.ACL = []string{"allow 10.0.0.0/8", "deny all"}
+	hot.Timeouts.Idle = "5m"
+	hot.Loadbalance.Enabled = true
+
+	if !hotReloadable(old, hot) {
+		t.Fatal("expected destination, proxy, ACL and timeout changes to be applied in place")
+	}
+
+	listener := hot
+	listener.Listener = "tcp://127.0.0.1:9001"
+
+	if hotReloadable(old, listener) {
+		t.Fatal("expected a listener change to restart the relay")
+	}
+
+	logging := hot
+	logging.LogFormat = "json"
+
+	if hotReloadable(old, logging) {
+		t.Fatal("expected a log format change to restart the relay")
+	}
+}
+
+func TestReloadRelays(t *testing.T) {
+	dir := t.TempDir()
+	stdout = io.Discard
+
+	old := daemonConfig()
+	setDaemonConfig(DaemonConfig{RelaysDir: dir})
+	t.Cleanup(func() { setDaemonConfig(old) })
+
+	port := func() string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer l.Close()
+		return l.Addr().String()
+	}
+
+	file := filepath.Join(dir, "reload.toml")
+	write := func(listener, destination string) {
+		err := os.WriteFile(file, []byte(`name = "reload-test"
+listener = "tcp://`+listener+`"
+destinations = ["tcp://`+destination+`"]
+auto_restart = true
+`), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := func(field string, got, expected []string) {
+		t.Helper()
+
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected %s %v got %v", field, expected, got)
+		}
+	}
+
+	// relays without auto_restart are left for the user to start
+	manual := filepath.Join(dir, "manual.toml")
+	err := os.WriteFile(manual, []byte(`name = "reload-manual"
+listener = "tcp://`+port()+`"
+destinations = ["tcp://127.0.0.1:1"]
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener := port()
+	write(listener, "127.0.0.1:1")
+
+	result := reloadRelays()
+	expect("started", result.Started, []string{"reload-test"})
+
+	if isRunning("reload-manual") {
+		t.Fatal("expected the relay without auto_restart not to be started")
+	}
+
+	relay, running := getRelay("reload-test")
+	if !running {
+		t.Fatal("relay was not started")
+	}
+
+	t.Cleanup(func() {
+		if relay, running := getRelay("reload-test"); running {
+			relay.Close()
+		}
+	})
+
+	write(listener, "127.0.0.1:2")
+
+	result = reloadRelays()
+	expect("updated", result.Updated, []string{"reload-test"})
+
+	if d := relay.Destinations(); len(d) != 1 || d[0] != "tcp://127.0.0.1:2" {
+		t.Fatalf("destinations were not updated %v", d)
+	}
+
+	write(port(), "127.0.0.1:2")
+
+	result = reloadRelays()
+	expect("restarted", result.Restarted, []string{"reload-test"})
+
+	if restarted, _ := getRelay("reload-test"); restarted == relay {
+		t.Fatal("relay was not restarted")
+	}
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+
+	result = reloadRelays()
+	expect("stopped", result.Stopped, []string{"reload-test"})
+
+	if isRunning("reload-test") {
+		t.Fatal("relay was not stopped")
+	}
+
+	if len(result.Errors) > 0 {
+		t.Fatal(result.Errors)
+	}
+}
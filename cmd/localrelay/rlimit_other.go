@@ -0,0 +1,12 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package main
+
+import "log"
+
+// setOpenFilesLimit is only supported on Linux and macOS
+func setOpenFilesLimit(n uint64) error {
+	log.Printf("[Warn] Setting the open files limit is not supported on this system\n")
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package main
+
+import "syscall"
+
+// setOpenFilesLimit raises the soft limit of open files, up to the hard limit
+func setOpenFilesLimit(n uint64) error {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return err
+	}
+
+	if n > limit.Max {
+		n = limit.Max
+	}
+
+	limit.Cur = n
+	return syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+}
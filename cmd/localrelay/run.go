@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"io"
 	"log"
 	"net/http"
@@ -13,7 +14,6 @@ import (
 
 	"github.com/go-compile/localrelay/v2"
 	"github.com/kardianos/service"
-	"github.com/naoina/toml"
 	"github.com/pkg/errors"
 )
 
@@ -41,8 +41,6 @@ func init() {
 }
 
 func runRelays(opt *options, i int, cmd []string) error {
-	home := configSystemDir()
-
 	relayPaths := make([]string, 0, len(cmd[i+1:]))
 
 	// Read all relay config files and decode them
@@ -52,7 +50,7 @@ func runRelays(opt *options, i int, cmd []string) error {
 		// if @ used as prefix grab the file from the user profile's
 		// config location
 		if strings.HasPrefix(file, "@") {
-			file = filepath.Join(home, configDirSuffix, file[1:])
+			file = filepath.Join(relaysDir(), file[1:])
 		}
 
 		file, err := filepath.Abs(file)
@@ -60,12 +58,12 @@ func runRelays(opt *options, i int, cmd []string) error {
 			return err
 		}
 
-		relay, err := readRelayConfig(file)
+		fileRelays, err := readRelayConfigs(file)
 		if err != nil {
 			return err
 		}
 
-		relays = append(relays, *relay)
+		relays = append(relays, fileRelays...)
 		// append path here so we validate the config first before sending to
 		// service.
 		relayPaths = append(relayPaths, file)
@@ -117,6 +115,15 @@ func launchRelays(relays []Relay, wait bool) error {
 			return ErrInvalidRelayName
 		}
 
+		if isService {
+			conf := daemonConfig()
+			conf.Defaults.apply(&r)
+
+			if max := conf.Resources.MaxRelays; max > 0 && len(runningRelays()) >= max {
+				return errors.Wrapf(ErrMaxRelays, "relay %q", r.Name)
+			}
+		}
+
 		Printf("[Info] [Relay:%d] Starting %q on %q\n", i+1, r.Name, r.Listener)
 
 		w := io.MultiWriter(newLogger(r.Name), os.Stdout)
@@ -147,44 +154,98 @@ func launchRelays(relays []Relay, wait bool) error {
 			return err
 		}
 
-		// ===== set proxies
-		proxMap := make(map[string]localrelay.ProxyURL)
-		for proxyName, proxyConf := range r.Proxies {
-			if strings.ToLower(proxyConf.Protocol) != "socks5" {
-				return errors.New("Socks5 is the only supported proxy type")
+		if len(r.Listeners) > 0 {
+			if err := relay.SetListeners(append([]localrelay.TargetLink{r.Listener}, r.Listeners...)...); err != nil {
+				return errors.Wrapf(err, "relay %q", r.Name)
 			}
+		}
+
+		format, err := localrelay.ParseLogFormat(r.LogFormat)
+		if err != nil {
+			return errors.Wrapf(err, "relay %q", r.Name)
+		}
+
+		level, err := localrelay.ParseLogLevel(r.LogLevel)
+		if err != nil {
+			return errors.Wrapf(err, "relay %q", r.Name)
+		}
+
+		relay.SetLogHandler(localrelay.NewLogHandler(w, localrelay.LogOptions{
+			Format: format,
+			Level:  level,
+		}))
 
-			proxyURL, err := url.Parse(proxyConf.Protocol + "://" + proxyConf.Address)
+		if r.AccessLog != "" {
+			accessLog, err := newAccessLog(r)
 			if err != nil {
-				return err
+				return errors.Wrapf(err, "relay %q", r.Name)
 			}
 
-			if len(proxyConf.Username) > 0 || len(proxyConf.Password) > 0 {
-				proxyURL.User = url.UserPassword(proxyConf.Username, proxyConf.Password)
-			}
+			relay.SetAccessLog(accessLog)
+		}
 
-			proxMap[proxyName] = localrelay.NewProxyURL(proxyURL)
+		// ===== set proxies
+		proxMap, err := r.proxyURLs()
+		if err != nil {
+			return err
 		}
 
 		if len(proxMap) > 0 {
 			relay.SetProxy(proxMap)
 		}
 
+		// the daemon records relay events in its own log and totals
+		if isService {
+			relay.SetHooks(relayHooks())
+		}
+
 		if r.Loadbalance.Enabled {
 			relay.SetLoadbalance(true)
 		}
 
+		if r.Limits.Enabled() {
+			relay.SetLimits(r.Limits)
+		}
+
+		rules, err := localrelay.ParseACL(r.ACL)
+		if err != nil {
+			return errors.Wrapf(err, "relay %q", r.Name)
+		}
+
+		relay.SetACL(rules)
+
+		timeouts, err := r.Timeouts.parse()
+		if err != nil {
+			return errors.Wrapf(err, "relay %q", r.Name)
+		}
+
+		relay.SetTimeouts(timeouts)
+
+		if r.Bans.Enabled {
+			jail, err := newJail(r.Bans)
+			if err != nil {
+				return errors.Wrapf(err, "relay %q", r.Name)
+			}
+
+			// bans are only persisted by the daemon
+			if isService {
+				jail.Restore(storedBans(r.Name))
+				jail.OnChange(func() {
+					if err := saveBans(); err != nil {
+						log.Printf("[Error] Saving bans: %s\n", err)
+					}
+				})
+			}
+
+			relay.SetJail(jail)
+		}
+
 		switch r.Listener.ProxyType() {
-		case localrelay.ProxyTCP, localrelay.ProxyUDP:
+		case localrelay.ProxyTCP, localrelay.ProxyUDP, localrelay.ProxyUnix:
 			addRelay(relay)
 			wg.Add(1)
 			go func(relay *localrelay.Relay) {
-				if err := relay.ListenServe(); err != nil {
-					log.Println("[Error] ", err)
-				}
-
-				removeRelay(relay.Name)
-				removeLogDescriptor(r.Name)
+				serveRelay(relay)
 				wg.Done()
 			}(relay)
 		case localrelay.ProxyHTTP, localrelay.ProxyHTTPS:
@@ -210,12 +271,7 @@ func launchRelays(relays []Relay, wait bool) error {
 			addRelay(relay)
 			wg.Add(1)
 			go func(relay *localrelay.Relay) {
-				if err := relay.ListenServe(); err != nil {
-					log.Println("[Error] ", err)
-				}
-
-				removeRelay(relay.Name)
-				removeLogDescriptor(r.Name)
+				serveRelay(relay)
 				wg.Done()
 			}(relay)
 		default:
@@ -231,6 +287,119 @@ func launchRelays(relays []Relay, wait bool) error {
 	return nil
 }
 
+// serveRelay serves the relay until it is closed. The daemon supervises
+// relays which fail, restarting them with a backoff. Failed relays are
+// kept so their error is shown by status and they can be restarted by
+// hand, they are released once stopped.
+func serveRelay(relay *localrelay.Relay) {
+	supervise := isService
+
+	for attempt := 1; ; attempt++ {
+		started := relay.Status().Started
+
+		err := relay.ListenServe()
+		if err == nil {
+			break
+		}
+
+		if errors.Is(err, localrelay.ErrAlreadyRunning) {
+			// restarted by hand while waiting to be restarted
+			return
+		}
+
+		log.Println("[Error] ", err)
+
+		if !supervise {
+			break
+		}
+
+		// a relay which listened before failing starts its attempts again
+		if relay.Status().Started != started {
+			attempt = 1
+		}
+
+		if !superviseRelay(relay, err, attempt) {
+			return
+		}
+	}
+
+	releaseRelay(relay)
+}
+
+// releaseRelay closes the relay's logs and capture and removes it from the
+// active relays
+func releaseRelay(relay *localrelay.Relay) {
+	removeLogDescriptor(relay.Name)
+	closeAccessLog(relay.Name)
+	relay.StopCapture()
+
+	// removed last so a relay of the same name can be launched once this
+	// one is no longer running
+	removeRelay(relay.Name)
+}
+
+// relaunchRelay reopens the listeners of a running relay, a failed relay
+// is served again. The error is returned if the relay fails to listen.
+func relaunchRelay(relay *localrelay.Relay) error {
+	status := relay.Status()
+	if status.State != localrelay.StateFailed {
+		return relay.Restart()
+	}
+
+	go serveRelay(relay)
+
+	// wait for the relay to listen or fail with a new error
+	for {
+		time.Sleep(time.Millisecond * 10)
+
+		switch s := relay.Status(); s.State {
+		case localrelay.StateStarting:
+		case localrelay.StateFailed:
+			if s.LastError != status.LastError {
+				return s.LastError
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// shutdownRelay stops the relay, giving its connections until the drain
+// timeout to close before they are closed
+func shutdownRelay(relay *localrelay.Relay, drain time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+
+	if err := relay.Shutdown(ctx); errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("[Warn] [Relay:%s] Connections were closed after draining for %s\n", relay.Name, drain)
+	} else if err != nil {
+		log.Printf("[Error] [Relay:%s] Shutting down: %s\n", relay.Name, err)
+	}
+}
+
+// proxyURLs parses the relay's proxies
+func (r Relay) proxyURLs() (map[string]localrelay.ProxyURL, error) {
+	proxMap := make(map[string]localrelay.ProxyURL)
+	for proxyName, proxyConf := range r.Proxies {
+		if strings.ToLower(proxyConf.Protocol) != "socks5" {
+			return nil, errors.Wrapf(ErrUnsupportedProxy, "%q", proxyName)
+		}
+
+		proxyURL, err := url.Parse(proxyConf.Protocol + "://" + proxyConf.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(proxyConf.Username) > 0 || len(proxyConf.Password) > 0 {
+			proxyURL.User = url.UserPassword(proxyConf.Username, proxyConf.Password)
+		}
+
+		proxMap[proxyName] = localrelay.NewProxyURL(proxyURL)
+	}
+
+	return proxMap, nil
+}
+
 func addRelay(r *localrelay.Relay) {
 	activeRelaysM.Lock()
 	activeRelays[r.Name] = r
@@ -273,6 +442,15 @@ func isRunning(relay string) bool {
 	return found
 }
 
+// getRelay returns the running relay with the provided name
+func getRelay(relay string) (*localrelay.Relay, bool) {
+	activeRelaysM.Lock()
+	defer activeRelaysM.Unlock()
+
+	r, found := activeRelays[relay]
+	return r, found
+}
+
 func runningRelays() []*localrelay.Relay {
 	activeRelaysM.Lock()
 
@@ -292,33 +470,3 @@ func closeDescriptor(path string) error {
 	closer := *logDescriptors[path]
 	return closer.Close()
 }
-
-// runningRelaysCopy makes a copy instead of returning the
-// pointers
-func runningRelaysCopy() []localrelay.Relay {
-	activeRelaysM.Lock()
-
-	relays := make([]localrelay.Relay, 0, len(activeRelays))
-	for _, r := range activeRelays {
-		relays = append(relays, *r)
-	}
-	activeRelaysM.Unlock()
-
-	return relays
-}
-
-func readRelayConfig(file string) (*Relay, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, errors.Wrapf(err, "file:%q", file)
-	}
-
-	defer f.Close()
-
-	var relay Relay
-	if err := toml.NewDecoder(f).Decode(&relay); err != nil {
-		return nil, errors.Wrapf(err, "file:%q", file)
-	}
-
-	return &relay, nil
-}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-compile/localrelay/v2"
+)
+
+func TestFailedRelayRestart(t *testing.T) {
+	isService = true
+	t.Cleanup(func() { isService = false })
+
+	// occupy the relay's address so it fails to listen
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := l.Addr().String()
+
+	relay, err := localrelay.New("restart-test", io.Discard, localrelay.TargetLink("tcp://"+addr), "tcp://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addRelay(relay)
+	t.Cleanup(func() { closeRelay(relay) })
+
+	go serveRelay(relay)
+
+	for start := time.Now(); relay.State() != localrelay.StateFailed; time.Sleep(time.Millisecond * 10) {
+		if time.Since(start) > time.Second*2 {
+			t.Fatalf("expected the relay to fail got %q", relay.State())
+		}
+	}
+
+	// the daemon keeps failed relays so their error can be seen
+	if !isRunning("restart-test") || relay.Status().LastError == nil {
+		t.Fatal("expected the failed relay to be kept with its error")
+	}
+
+	if err := relaunchRelay(relay); err == nil {
+		t.Fatal("expected the restart to fail while the address is in use")
+	}
+
+	l.Close()
+
+	if err := relaunchRelay(relay); err != nil {
+		t.Fatal(err)
+	}
+
+	if state := relay.State(); state != localrelay.StateRunning {
+		t.Fatalf("expected running got %q", state)
+	}
+
+	if err := closeRelay(relay); err != nil {
+		t.Fatal(err)
+	}
+
+	if isRunning("restart-test") {
+		t.Fatal("expected the stopped relay to be removed")
+	}
+}
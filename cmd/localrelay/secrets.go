@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// secretFilePrefix marks a config value which is read from a file, such as
+// a Docker secret (file:/run/secrets/tor_password) or a systemd credential
+// (file:${CREDENTIALS_DIRECTORY}/tor_password)
+const secretFilePrefix = "file:"
+
+var (
+	// ErrEnvNotSet is returned when a config references an environment
+	// variable which is not set and has no default
+	ErrEnvNotSet = errors.New("environment variable is not set")
+
+	// envReference matches $${escaped}, ${NAME}, ${NAME:-default} and ${NAME-default}
+	envReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?:(:?-)([^}]*))?\}`)
+	// envNotSet matches the reference reported by ErrEnvNotSet
+	envNotSet = regexp.MustCompile(`\$\{[A-Za-z_][A-Za-z0-9_]*\}`)
+
+	// proxyCredentials matches the user info of a proxy URL
+	proxyCredentials = regexp.MustCompile(`(://[^/@:]*):[^/@]*@`)
+)
+
+// interpolateEnv substitutes environment variables into the string fields
+// of a decoded config. Values are substituted after decoding so they are
+// never parsed as part of the TOML, YAML or JSON document.
+//
+//	${NAME}           the value of NAME, an error if NAME is not set
+//	${NAME:-default}  default if NAME is not set or empty
+//	${NAME-default}   default if NAME is not set
+//	$${NAME}          the literal text ${NAME}
+func interpolateEnv(v interface{}) error {
+	return interpolateValue(reflect.ValueOf(v), "")
+}
+
+// interpolateValue walks structs, pointers, slices and maps interpolating
+// every string, path names the field for errors
+func interpolateValue(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+
+		return interpolateValue(v.Elem(), path)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+
+			if err := interpolateValue(v.Field(i), joinPath(path, fieldName(t.Field(i)))); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := interpolateValue(v.Index(i), path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			// map values can not be set in place so are copied
+			value := reflect.New(iter.Value().Type()).Elem()
+			value.Set(iter.Value())
+
+			if err := interpolateValue(value, joinPath(path, fmt.Sprint(iter.Key()))); err != nil {
+				return err
+			}
+
+			v.SetMapIndex(iter.Key(), value)
+		}
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+
+		value, err := interpolateString(v.String())
+		if err != nil {
+			return errors.Wrap(err, path)
+		}
+
+		v.SetString(value)
+	}
+
+	return nil
+}
+
+// interpolateString substitutes the environment variables of a value
+func interpolateString(value string) (string, error) {
+	var err error
+	value = envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		m := envReference.FindStringSubmatch(ref)
+		if m[1] == "" {
+			// escaped
+			return "${"
+		}
+
+		env, set := os.LookupEnv(m[1])
+		switch {
+		case m[2] == ":-" && env == "":
+			return m[3]
+		case m[2] == "-" && !set:
+			return m[3]
+		case !set:
+			if err == nil {
+				err = errors.Wrapf(ErrEnvNotSet, "${%s}", m[1])
+			}
+		}
+
+		return env
+	})
+
+	return value, err
+}
+
+// fieldName returns the config key of a struct field
+func fieldName(f reflect.StructField) string {
+	if name, _, _ := strings.Cut(f.Tag.Get("yaml"), ","); name != "" {
+		return name
+	}
+
+	return f.Name
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
+
+// readSecret returns the contents of the file referenced by a "file:"
+// value without the trailing newline. Other values are returned as is.
+func readSecret(value string) (string, error) {
+	path, found := strings.CutPrefix(value, secretFilePrefix)
+	if !found {
+		return value, nil
+	}
+
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrap(err, "reading secret")
+	}
+
+	return strings.TrimRight(string(secret), "\r\n"), nil
+}
+
+// resolveSecrets replaces the relay's secret references with their values.
+// TLS paths may also use the "file:" prefix, the file is read by the relay.
+func (r *Relay) resolveSecrets() error {
+	r.Tls.Certificate = strings.TrimPrefix(r.Tls.Certificate, secretFilePrefix)
+	r.Tls.Private = strings.TrimPrefix(r.Tls.Private, secretFilePrefix)
+
+	for name, proxy := range r.Proxies {
+		for _, field := range []*string{&proxy.Username, &proxy.Password} {
+			secret, err := readSecret(*field)
+			if err != nil {
+				return errors.Wrapf(err, "proxy %q", name)
+			}
+
+			*field = secret
+		}
+
+		r.Proxies[name] = proxy
+	}
+
+	return nil
+}
+
+// redactProxy hides the password of a proxy URL's user info
+func redactProxy(address string) string {
+	return proxyCredentials.ReplaceAllString(address, "$1:xxxxx@")
+}
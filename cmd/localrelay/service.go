@@ -3,11 +3,15 @@ package main
 import (
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/go-compile/localrelay/internal/ipc"
+	"github.com/go-compile/localrelay/v2"
 	"github.com/kardianos/service"
 )
 
@@ -20,16 +24,23 @@ func (p daemon) Start(s service.Service) error {
 }
 
 func (p daemon) Stop(s service.Service) error {
+	wg := sync.WaitGroup{}
 	for _, r := range runningRelays() {
 		log.Printf("[Info] Closing relay: %s\n", r.Name)
-		if err := r.Close(); err != nil {
-			log.Printf("[Error] Closing relay: %s with error: %s\n", r.Name, err)
-		}
+
+		wg.Add(1)
+		go func(r *localrelay.Relay) {
+			shutdownRelay(r, drainTimeout())
+			wg.Done()
+		}(r)
 	}
 
+	wg.Wait()
 	log.Printf("[Info] All relays closed:\n")
 
 	closeLogDescriptors()
+	closeMetrics()
+	closeAdmin()
 
 	ipcListener.Close()
 
@@ -39,12 +50,33 @@ func (p daemon) Stop(s service.Service) error {
 
 func (p daemon) run() {
 	isService = true
-	// TODO: listen to signals for reload from systemctl
+
+	if err := applyResources(daemonConfig().Resources); err != nil {
+		log.Printf("[Error] Applying resource limits: %s\n", err)
+	}
+
+	// reload the daemon and relay configs when systemctl reload sends SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			reloadDaemonConfig()
+			logReload(reloadRelays())
+		}
+	}()
 
 	if err := launchAutoStartRelays(); err != nil {
 		log.Fatal(err)
 	}
 
+	if err := serveMetrics(); err != nil {
+		log.Printf("[Error] Failed to serve metrics: %s\n", err)
+	}
+
+	if err := serveAdmin(); err != nil {
+		log.Printf("[Error] Failed to serve admin API: %s\n", err)
+	}
+
 	l, err := ipc.NewListener()
 	if err != nil {
 		log.Fatal(err)
@@ -63,11 +95,8 @@ func launchAutoStartRelays() error {
 		return err
 	}
 
-	home := configSystemDir()
-	prefix := filepath.Join(home, configDirSuffix)
-
 	// read config dir in home folder
-	dir, err := os.ReadDir(prefix)
+	dir, err := os.ReadDir(relaysDir())
 	if err != nil {
 		return err
 	}
@@ -75,26 +104,29 @@ func launchAutoStartRelays() error {
 	relays := make([]Relay, 0, len(dir))
 
 	for _, entry := range dir {
-		// ignore all none toml files
-		if filepath.Ext(entry.Name()) != ".toml" || entry.IsDir() {
+		// ignore all none config files
+		if !isConfigFile(entry.Name()) || entry.IsDir() {
 			continue
 		}
 
-		file := filepath.Join(home, configDirSuffix, entry.Name())
+		file := filepath.Join(relaysDir(), entry.Name())
 
-		relay, err := readRelayConfig(file)
+		fileRelays, err := readRelayConfigs(file)
 		if err != nil {
 			return err
 		}
 
-		relays = append(relays, *relay)
+		relays = append(relays, fileRelays...)
+		trackRelays(file, fileRelays...)
 
-		if !relay.AutoRestart {
-			log.Printf("[Ignoring Relay] %q\n", relay.Name)
-			continue
-		}
+		for _, relay := range fileRelays {
+			if !relay.AutoRestart {
+				log.Printf("[Ignoring Relay] %q\n", relay.Name)
+				continue
+			}
 
-		log.Printf("[Launching relay] %q\n", relay.Name)
+			log.Printf("[Launching relay] %q\n", relay.Name)
+		}
 	}
 
 	if len(relays) == 0 {
@@ -114,7 +146,13 @@ func configSystemDir() string {
 	return "/etc"
 }
 
+// relaysDir is where the daemon reads relay configs from, it can be moved
+// by the daemon config
 func relaysDir() string {
+	if dir := daemonConfig().RelaysDir; dir != "" {
+		return dir
+	}
+
 	return filepath.Join(configSystemDir(), configDirSuffix)
 }
 
@@ -5,6 +5,7 @@ import (
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/containerd/console"
@@ -58,6 +59,8 @@ func relayStatus() error {
 	Printf("Active:      [%d]\r\n", active)
 	Printf("In/Out:      [%s/%s]\r\n", formatBytes(in), formatBytes(out))
 	Printf("Uptime:      [%s]\r\n", formatDuration(time.Since(time.Unix(s.Started, 0))))
+	Printf("Lifetime:    [Accepted:%d] [Streams:%d] [Dial Fails:%d] [Restarts:%d] [In/Out:%s/%s]\r\n", s.Totals.Accepted, s.Totals.Streams,
+		s.Totals.DialFails, s.Totals.Restarts, formatBytes(int(s.Totals.In)), formatBytes(int(s.Totals.Out)))
 
 	// sort alphabetically
 	sort.SliceStable(s.Relays, func(i, j int) bool {
@@ -65,11 +68,14 @@ func relayStatus() error {
 	})
 
 	for i := range s.Relays {
-		badges := ""
+		state := s.States[s.Relays[i].Name]
+		badges := fmtRelayState(state.State)
 
 		switch s.Relays[i].Listener.ProxyType() {
 		case localrelay.ProxyTCP:
 			badges += "\x1b[90m [TCP] \x1b[0m"
+		case localrelay.ProxyUnix:
+			badges += "\x1b[90m [UNIX] \x1b[0m"
 		case localrelay.ProxyHTTP:
 			badges += "\x1b[90m [HTTP] \x1b[0m"
 		case localrelay.ProxyHTTPS:
@@ -88,12 +94,35 @@ func relayStatus() error {
 			badges += "\x1b[93m [FAILOVER] \x1b[0m"
 		}
 
-		Printf("  \x1b[90m%.2d\x1b[0m: %s %s\r\n      %s -> %s\r\n", i+1, s.Relays[i].Name, badges, s.Relays[i].Listener, fmtDestination(s.Relays[i].Destination, 6))
+		Printf("  \x1b[90m%.2d\x1b[0m: %s %s\r\n      %s -> %s\r\n", i+1, s.Relays[i].Name, badges, fmtListeners(&s.Relays[i]), fmtDestination(s.Relays[i].Destination, 6))
+
+		if state.State == string(localrelay.StateRunning) && state.Started > 0 {
+			Printf("      \x1b[90mUp %s, restarts=%d\x1b[0m\r\n", formatDuration(time.Since(time.Unix(state.Started, 0))), state.Restarts)
+		}
+
+		if state.LastError != "" {
+			Printf("      \x1b[31mLast error: %s\x1b[0m\r\n", state.LastError)
+		}
 	}
 
 	return nil
 }
 
+// fmtRelayState returns a badge for the relay's lifecycle state
+func fmtRelayState(state string) string {
+	switch localrelay.RelayState(state) {
+	case "":
+		// daemons before relay states were reported
+		return ""
+	case localrelay.StateRunning:
+		return "\x1b[32m [RUNNING] \x1b[0m"
+	case localrelay.StateStarting, localrelay.StateDraining:
+		return "\x1b[33m [" + strings.ToUpper(state) + "] \x1b[0m"
+	default:
+		return "\x1b[31m [" + strings.ToUpper(state) + "] \x1b[0m"
+	}
+}
+
 func formatBytes(bytes int) string {
 	if unit := 1000; bytes < unit {
 		return strconv.Itoa(bytes) + "bytes"
@@ -127,6 +156,15 @@ func formatDuration(d time.Duration) string {
 	return strconv.FormatFloat(d.Hours()/24, 'f', 2, 64) + " days"
 }
 
+// fmtListeners returns the relay's listener or all of its listeners
+func fmtListeners(r *localrelay.Relay) string {
+	if len(r.Listeners) > 1 {
+		return fmtDestination(r.Listeners, 6)
+	}
+
+	return r.Listener.Print()
+}
+
 func fmtDestination(targets []localrelay.TargetLink, limit int) string {
 	if len(targets) > limit {
 		return "[" + joinTargets(targets[:limit], "\x1b[90m,\x1b[0m ") + " \x1b[90m... n=" + strconv.Itoa(len(targets)) + "\x1b[0m]"
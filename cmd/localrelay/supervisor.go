@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-compile/localrelay/v2"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultRestartAttempts is how many times a failed relay is restarted
+	// when the daemon config does not set max_attempts
+	defaultRestartAttempts = 5
+
+	// supervisePollInterval is how often a relay waiting to be restarted
+	// checks it has not been stopped or restarted by hand
+	supervisePollInterval = time.Millisecond * 100
+)
+
+// Supervisor configures how the daemon restarts relays which fail
+type Supervisor struct {
+	// Disabled leaves failed relays stopped
+	Disabled bool
+	// Backoff is the delay before the first restart, it doubles after
+	// each attempt up to MaxBackoff
+	Backoff    string
+	MaxBackoff string
+	// MaxAttempts is how many restarts are tried before giving up, 0 uses
+	// the default and a negative value retries forever. Bind errors are
+	// always retried as the address may not be up yet.
+	MaxAttempts int
+}
+
+// validate checks the supervisor's durations
+func (s Supervisor) validate() error {
+	for name, d := range map[string]string{
+		"backoff":     s.Backoff,
+		"max_backoff": s.MaxBackoff,
+	} {
+		if d == "" {
+			continue
+		}
+
+		if _, err := time.ParseDuration(d); err != nil {
+			return errors.Wrapf(err, "parsing supervisor %s", name)
+		}
+	}
+
+	return nil
+}
+
+// restartDelay returns how long to wait before restarting a relay which
+// failed with err, attempt counts from 1. False is returned once the
+// attempts are used up.
+func (s Supervisor) restartDelay(err error, attempt int) (time.Duration, bool) {
+	if s.Disabled {
+		return 0, false
+	}
+
+	max := s.MaxAttempts
+	if max == 0 {
+		max = defaultRestartAttempts
+	}
+
+	if max > 0 && attempt > max && !isBindError(err) {
+		return 0, false
+	}
+
+	backoff, err := time.ParseDuration(s.Backoff)
+	if err != nil || backoff <= 0 {
+		backoff = time.Second
+	}
+
+	maxBackoff, err := time.ParseDuration(s.MaxBackoff)
+	if err != nil || maxBackoff <= 0 {
+		maxBackoff = time.Minute
+	}
+
+	delay := backoff
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	return delay, true
+}
+
+// isBindError returns true if a listener could not bind its address, such
+// as when the interface is not up yet or the port is in use
+func isBindError(err error) bool {
+	var syscallErr *os.SyscallError
+	return errors.As(err, &syscallErr) && syscallErr.Syscall == "bind"
+}
+
+// superviseRelay waits to restart a relay which failed with err. False is
+// returned if the relay should not be restarted, because the attempts
+// are used up or it was stopped or restarted by hand while waiting.
+func superviseRelay(relay *localrelay.Relay, err error, attempt int) bool {
+	delay, retry := daemonConfig().Supervisor.restartDelay(err, attempt)
+	if !retry {
+		if !daemonConfig().Supervisor.Disabled {
+			log.Printf("[Error] [Relay:%s] Giving up restarting after %d attempts\n", relay.Name, attempt-1)
+		}
+
+		return false
+	}
+
+	if isBindError(err) {
+		log.Printf("[Warn] [Relay:%s] Listen address is unavailable, retrying in %s (attempt %d)\n", relay.Name, delay, attempt)
+	} else {
+		log.Printf("[Warn] [Relay:%s] Restarting in %s (attempt %d)\n", relay.Name, delay, attempt)
+	}
+
+	for deadline := time.Now().Add(delay); ; time.Sleep(supervisePollInterval) {
+		if r, found := getRelay(relay.Name); !found || r != relay || relay.State() != localrelay.StateFailed {
+			return false
+		}
+
+		if !time.Now().Before(deadline) {
+			break
+		}
+	}
+
+	atomic.AddUint64(&daemonTotals.restarts, 1)
+	return true
+}
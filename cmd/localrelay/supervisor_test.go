@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-compile/localrelay/v2"
+	"github.com/pkg/errors"
+)
+
+func TestRestartDelay(t *testing.T) {
+	s := Supervisor{Backoff: "1s", MaxBackoff: "5s", MaxAttempts: 3}
+	failure := errors.New("relay failed")
+
+	for attempt, expected := range []time.Duration{1: time.Second, 2: time.Second * 2, 3: time.Second * 4} {
+		if attempt == 0 {
+			continue
+		}
+
+		if delay, retry := s.restartDelay(failure, attempt); !retry || delay != expected {
+			t.Errorf("attempt %d: expected %s got %s %v", attempt, expected, delay, retry)
+		}
+	}
+
+	if _, retry := s.restartDelay(failure, 4); retry {
+		t.Error("expected to give up after the max attempts")
+	}
+
+	// bind errors are retried at the max backoff until the address is up
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	_, bindErr := net.Listen("tcp", l.Addr().String())
+	if !isBindError(bindErr) {
+		t.Fatalf("expected a bind error got %v", bindErr)
+	}
+
+	if delay, retry := s.restartDelay(bindErr, 100); !retry || delay != time.Second*5 {
+		t.Errorf("expected bind errors to retry at the max backoff got %s %v", delay, retry)
+	}
+
+	if _, retry := (Supervisor{Disabled: true}).restartDelay(bindErr, 1); retry {
+		t.Error("expected a disabled supervisor not to restart")
+	}
+
+	if _, retry := (Supervisor{}).restartDelay(failure, defaultRestartAttempts+1); retry {
+		t.Error("expected the default max attempts to apply")
+	}
+
+	if _, retry := (Supervisor{MaxAttempts: -1}).restartDelay(failure, 1000); !retry {
+		t.Error("expected a negative max attempts to retry forever")
+	}
+}
+
+func TestSuperviseRelay(t *testing.T) {
+	isService = true
+	t.Cleanup(func() { isService = false })
+
+	old := daemonConfig()
+	setDaemonConfig(DaemonConfig{Supervisor: Supervisor{Backoff: "10ms", MaxBackoff: "20ms"}})
+	t.Cleanup(func() { setDaemonConfig(old) })
+
+	// the address is taken until the relay has retried
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relay, err := localrelay.New("supervise-test", io.Discard, localrelay.TargetLink("tcp://"+l.Addr().String()), "tcp://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarts := atomic.LoadUint64(&daemonTotals.restarts)
+
+	addRelay(relay)
+	t.Cleanup(func() { closeRelay(relay) })
+
+	go serveRelay(relay)
+
+	waitUntil := func(condition func() bool) {
+		t.Helper()
+
+		for start := time.Now(); !condition(); time.Sleep(time.Millisecond * 10) {
+			if time.Since(start) > time.Second*5 {
+				t.Fatalf("condition was not met, relay is %q", relay.State())
+			}
+		}
+	}
+
+	waitUntil(func() bool { return atomic.LoadUint64(&daemonTotals.restarts) >= restarts+2 })
+
+	l.Close()
+	waitUntil(relay.Running)
+
+	if relay.Status().LastError == nil {
+		t.Fatal("expected the bind error to be kept as the last error")
+	}
+
+	if err := closeRelay(relay); err != nil {
+		t.Fatal(err)
+	}
+
+	if isRunning("supervise-test") {
+		t.Fatal("expected the closed relay to be removed")
+	}
+}
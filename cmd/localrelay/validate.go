@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-compile/localrelay/v2"
+	"github.com/pkg/errors"
+)
+
+// decodeLineError matches the line number reported by the TOML and YAML decoders
+var decodeLineError = regexp.MustCompile(`line (\d+): (.*)$`)
+
+// diagnostic is a problem found in a relay config
+type diagnostic struct {
+	file    string
+	line    int
+	warning bool
+	message string
+}
+
+func (d diagnostic) String() string {
+	level := "\x1b[31merror\x1b[0m"
+	if d.warning {
+		level = "\x1b[33mwarning\x1b[0m"
+	}
+
+	if d.line == 0 {
+		return d.file + ": " + level + ": " + d.message
+	}
+
+	return d.file + ":" + strconv.Itoa(d.line) + ": " + level + ": " + d.message
+}
+
+// boundListener is a listen address claimed by a relay
+type boundListener struct {
+	relay   string
+	file    string
+	network string
+	host    string
+	port    int
+}
+
+// linter validates relay configs, including conflicts between them
+type linter struct {
+	diagnostics []diagnostic
+
+	// names maps relay names to the file which defined them
+	names     map[string]string
+	listeners []boundListener
+}
+
+func newLinter() *linter {
+	return &linter{
+		names: make(map[string]string),
+	}
+}
+
+// validateCommand handles:
+//
+//	localrelay validate <relay_config>...
+//	localrelay validate @<relay>
+//	localrelay validate -all
+//
+// Exits non-zero if any errors were found.
+func validateCommand(opt *options, i int) {
+	files := opt.commands[i+1:]
+
+	if opt.all {
+		dir, err := os.ReadDir(relaysDir())
+		if err != nil {
+			Println(err)
+			os.Exit(1)
+		}
+
+		for _, entry := range dir {
+			if isConfigFile(entry.Name()) && !entry.IsDir() {
+				files = append(files, filepath.Join(relaysDir(), entry.Name()))
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		Println("Usage: localrelay validate <relay_config>... | @<relay> | -all")
+		os.Exit(1)
+	}
+
+	l := newLinter()
+	for _, file := range files {
+		if strings.HasPrefix(file, "@") {
+			file = filepath.Join(relaysDir(), file[1:])
+		}
+
+		l.lintFile(file)
+	}
+
+	errs := 0
+	for _, d := range l.diagnostics {
+		Println(d)
+
+		if !d.warning {
+			errs++
+		}
+	}
+
+	Printf("Checked %d configs: %d errors, %d warnings\n", len(files), errs, len(l.diagnostics)-errs)
+
+	if errs > 0 {
+		os.Exit(1)
+	}
+}
+
+// lintFile decodes and checks every relay in a config file
+func (l *linter) lintFile(file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		l.diagnostics = append(l.diagnostics, diagnostic{file: file, message: err.Error()})
+		return
+	}
+
+	relays, err := decodeRelayConfigs(data, formatOf(file))
+	if err != nil {
+		line, message := decodeErrorLine(err, data)
+		l.diagnostics = append(l.diagnostics, diagnostic{file: file, line: line, message: message})
+		return
+	}
+
+	for i := range relays {
+		src := newSource(data, relays[i].Name, len(relays) > 1)
+
+		diags := lintRelay(&relays[i], src)
+		sortDiagnostics(diags)
+
+		for _, d := range diags {
+			d.file = file
+			l.diagnostics = append(l.diagnostics, d)
+		}
+
+		l.lintConflicts(&relays[i], file, src)
+	}
+}
+
+// decodeErrorLine splits the line number from a decode error
+func decodeErrorLine(err error, data []byte) (int, string) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		return offsetLine(data, syntaxErr.Offset), err.Error()
+	case errors.As(err, &typeErr):
+		return offsetLine(data, typeErr.Offset), err.Error()
+	}
+
+	// unset environment variables are found by their reference
+	if errors.Is(err, ErrEnvNotSet) {
+		ref := strings.TrimSuffix(envNotSet.FindString(err.Error()), "}")
+		return newSource(data, "", false).line(ref), err.Error()
+	}
+
+	if m := decodeLineError.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return line, m[2]
+	}
+
+	// unknown JSON fields are reported without a position
+	if field, found := strings.CutPrefix(err.Error(), "json: unknown field "); found {
+		name, _ := strconv.Unquote(field)
+		return newSource(data, "", false).line(strconv.Quote(name)), err.Error()
+	}
+
+	return 0, err.Error()
+}
+
+// offsetLine returns the line of the byte offset
+func offsetLine(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// lintRelay checks everything the runtime would reject when launching the
+// relay, plus risky settings which are reported as warnings
+func lintRelay(r *Relay, src source) (diags []diagnostic) {
+	fail := func(key, message string) {
+		diags = append(diags, diagnostic{line: src.line(key), message: message})
+	}
+
+	warn := func(key, message string) {
+		diags = append(diags, diagnostic{line: src.line(key), warning: true, message: message})
+	}
+
+	if !validateName(r.Name) {
+		fail("name", "invalid relay name "+strconv.Quote(r.Name))
+	}
+
+	if r.Listener == "" {
+		fail("listener", "listener is not set")
+	}
+
+	if len(r.Destinations) == 0 {
+		fail("destinations", "at least one destination must be set")
+	}
+
+	// targetsValid is used to skip checks which would repeat target errors
+	targetsValid := true
+
+	listeners := append([]localrelay.TargetLink{r.Listener}, r.Listeners...)
+	for _, t := range listeners {
+		if t == "" {
+			continue
+		}
+
+		if err := t.Validate(); err != nil {
+			fail(string(t), err.Error())
+			targetsValid = false
+		}
+
+		if t.ProxyType() != r.Listener.ProxyType() {
+			fail(string(t), "listener "+strconv.Quote(string(t))+" does not use the same protocol as "+strconv.Quote(string(r.Listener)))
+		}
+	}
+
+	for _, t := range r.Destinations {
+		target, err := localrelay.ParseTargetLink(string(t))
+		if err != nil {
+			fail(string(t), err.Error())
+			targetsValid = false
+			continue
+		}
+
+		for _, name := range target.Proxies {
+			if _, found := r.Proxies[name]; !found {
+				fail(string(t), "proxy "+strconv.Quote(name)+" is referenced but not defined")
+			}
+		}
+	}
+
+	if targetsValid && r.Listener != "" && len(r.Destinations) > 0 {
+		if _, err := localrelay.New(r.Name, io.Discard, r.Listener, r.Destinations...); err != nil {
+			fail("destinations", err.Error())
+		}
+	}
+
+	for name, proxy := range r.Proxies {
+		if strings.ToLower(proxy.Protocol) != "socks5" {
+			fail(name, "proxy "+strconv.Quote(name)+" uses "+strconv.Quote(proxy.Protocol)+", socks5 is the only supported proxy type")
+		}
+
+		for _, value := range []string{proxy.Username, proxy.Password} {
+			path, found := strings.CutPrefix(value, secretFilePrefix)
+			if !found {
+				continue
+			}
+
+			if _, err := readSecret(value); err != nil {
+				fail(value, "proxy "+strconv.Quote(name)+": "+err.Error())
+			} else if worldReadable(path) {
+				warn(value, "secret "+strconv.Quote(path)+" is world readable")
+			}
+		}
+	}
+
+	// file: references in TLS paths are read by the relay
+	r.Tls.Certificate = strings.TrimPrefix(r.Tls.Certificate, secretFilePrefix)
+	r.Tls.Private = strings.TrimPrefix(r.Tls.Private, secretFilePrefix)
+
+	if r.Listener.ProxyType() == localrelay.ProxyHTTPS {
+		for key, file := range map[string]string{"certificate": r.Tls.Certificate, "private": r.Tls.Private} {
+			if file == "" {
+				fail("tls", "https relays require a tls "+key+" file")
+				continue
+			}
+
+			if _, err := os.Stat(file); err != nil {
+				fail(key, "tls "+key+": "+err.Error())
+				continue
+			}
+
+			if key == "private" && worldReadable(file) {
+				warn(key, "tls private key "+strconv.Quote(file)+" is world readable")
+			}
+		}
+	}
+
+	if _, err := localrelay.ParseLogFormat(r.LogFormat); err != nil {
+		fail("log_format", err.Error())
+	}
+
+	if _, err := localrelay.ParseLogLevel(r.LogLevel); err != nil {
+		fail("log_level", err.Error())
+	}
+
+	if r.AccessLog != "" {
+		if _, err := localrelay.ParseAccessLogFormat(r.AccessLog); err != nil {
+			fail("access_log", err.Error())
+		}
+	}
+
+	for _, limit := range []localrelay.Limit{r.Limits.Relay, r.Limits.Conn, r.Limits.IP} {
+		if limit.Upload < 0 || limit.Download < 0 {
+			fail("limits", "bandwidth limits can not be negative")
+			break
+		}
+	}
+
+	if r.Limits.ConnRate < 0 {
+		fail("limits", "connection rate can not be negative")
+	}
+
+	if _, err := localrelay.ParseACL(r.ACL); err != nil {
+		fail("acl", err.Error())
+	}
+
+	if _, err := r.Timeouts.parse(); err != nil {
+		fail("timeouts", err.Error())
+	}
+
+	if r.Bans.Enabled {
+		if _, err := newJail(r.Bans); err != nil {
+			fail("bans", err.Error())
+		}
+	}
+
+	if len(r.ACL) == 0 {
+		for _, t := range listeners {
+			if isPublicListener(t) {
+				warn(string(t), "listener "+strconv.Quote(string(t))+" is publicly reachable and has no ACL")
+			}
+		}
+	}
+
+	return diags
+}
+
+// lintConflicts reports duplicate relay names and listeners which clash
+// with the listeners of previously checked relays
+func (l *linter) lintConflicts(r *Relay, file string, src source) {
+	if other, found := l.names[r.Name]; found {
+		l.diagnostics = append(l.diagnostics, diagnostic{file: file, line: src.line("name"),
+			message: "relay name " + strconv.Quote(r.Name) + " is also used by " + other})
+	} else {
+		l.names[r.Name] = file
+	}
+
+	for _, t := range append([]localrelay.TargetLink{r.Listener}, r.Listeners...) {
+		target, err := localrelay.ParseTargetLink(string(t))
+		if err != nil {
+			continue
+		}
+
+		for port := target.FirstPort; port <= target.LastPort; port++ {
+			bound := boundListener{relay: r.Name, file: file, network: string(target.Protocol), host: target.Host, port: port}
+			if target.Protocol == localrelay.ProxyUnix {
+				bound.host = target.Path
+			} else if target.Protocol != localrelay.ProxyUDP {
+				// http(s) and tcp share the same ports
+				bound.network = "tcp"
+			}
+
+			if port == 0 && target.Protocol != localrelay.ProxyUnix {
+				// port 0 is assigned any free port
+				continue
+			}
+
+			if clash, found := l.clash(bound); found {
+				l.diagnostics = append(l.diagnostics, diagnostic{file: file, line: src.line(string(t)),
+					message: "listener " + strconv.Quote(string(t)) + " clashes with relay " + strconv.Quote(clash.relay) + " in " + clash.file})
+				break
+			}
+
+			l.listeners = append(l.listeners, bound)
+		}
+	}
+}
+
+// clash returns a listener which is bound to the same address
+func (l *linter) clash(b boundListener) (boundListener, bool) {
+	for _, other := range l.listeners {
+		if other.network != b.network || other.port != b.port {
+			continue
+		}
+
+		if other.host == b.host || isWildcardHost(other.host) || isWildcardHost(b.host) {
+			return other, true
+		}
+	}
+
+	return boundListener{}, false
+}
+
+// worldReadable returns true if any user may read the file
+func worldReadable(file string) bool {
+	info, err := os.Stat(file)
+	return err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0004 != 0
+}
+
+func isWildcardHost(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
+
+// isPublicListener returns true if the listener accepts non loopback clients
+func isPublicListener(t localrelay.TargetLink) bool {
+	target, err := localrelay.ParseTargetLink(string(t))
+	if err != nil || target.Protocol == localrelay.ProxyUnix {
+		return false
+	}
+
+	if isWildcardHost(target.Host) {
+		return true
+	}
+
+	if target.Host == "localhost" {
+		return false
+	}
+
+	ip := net.ParseIP(target.Host)
+	return ip == nil || !ip.IsLoopback()
+}
+
+// source finds the lines of keys and values within a relay's config. In
+// files holding multiple relays the search begins at the relay's name.
+type source struct {
+	lines []string
+	start int
+}
+
+func newSource(data []byte, name string, multiple bool) source {
+	src := source{lines: strings.Split(normaliseKey(string(data)), "\n")}
+
+	if multiple {
+		for i, line := range src.lines {
+			if strings.Contains(line, "name") && strings.Contains(line, normaliseKey(name)) {
+				src.start = i
+				break
+			}
+		}
+	}
+
+	return src
+}
+
+// line returns the first line which contains the needle, searching for
+// the key "needle =" or "needle:" first. Like the TOML decoder case and
+// underscores are ignored. 0 is returned if the needle is not found.
+func (src source) line(needle string) int {
+	needle = normaliseKey(needle)
+
+	for _, start := range []int{src.start, 0} {
+		for _, pattern := range []string{needle + " =", needle + "=", needle + ":", `"` + needle + `"`, needle} {
+			for i := start; i < len(src.lines); i++ {
+				if strings.Contains(src.lines[i], pattern) {
+					return i + 1
+				}
+			}
+		}
+	}
+
+	return 0
+}
+
+func normaliseKey(s string) string {
+	return strings.ToLower(strings.ReplaceAll(s, "_", ""))
+}
+
+// sortDiagnostics orders diagnostics by file and line
+func sortDiagnostics(diags []diagnostic) {
+	sort.SliceStable(diags, func(i, j int) bool {
+		if diags[i].file != diags[j].file {
+			return diags[i].file < diags[j].file
+		}
+
+		return diags[i].line < diags[j].line
+	})
+}
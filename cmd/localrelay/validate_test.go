@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, dir, name, config string) string {
+	t.Helper()
+
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := writeConfig(t, dir, "valid.toml", `name = "valid"
+listener = "tcp://127.0.0.1:9000"
+destinations = ["tcp://10.0.0.1:443?proxy=tor"]
+
+[proxies.tor]
+protocol = "socks5"
+address = "127.0.0.1:9050"
+`)
+
+	invalid := writeConfig(t, dir, "invalid.toml", `name = "invalid"
+listener = "tcp://0.0.0.0:9000"
+destinations = [
+	"tcp://10.0.0.1:443?proxy=missing",
+	"tcp://::1:443",
+]
+log_level = "loud"
+`)
+
+	broken := writeConfig(t, dir, "broken.toml", `name = "broken"
+listener = "tcp://127.0.0.1:9001"
+unknown = true
+`)
+
+	l := newLinter()
+	for _, file := range []string{valid, invalid, broken} {
+		l.lintFile(file)
+	}
+
+	expected := []struct {
+		file    string
+		line    int
+		warning bool
+		message string
+	}{
+		{invalid, 2, false, "clashes with relay \"valid\""},
+		{invalid, 2, true, "publicly reachable"},
+		{invalid, 4, false, "proxy \"missing\" is referenced but not defined"},
+		{invalid, 5, false, "IPv6 addresses must be in brackets"},
+		{invalid, 7, false, "loud"},
+		{broken, 3, false, "unknown"},
+	}
+
+	for _, e := range expected {
+		found := false
+		for _, d := range l.diagnostics {
+			if d.file == e.file && d.line == e.line && d.warning == e.warning && strings.Contains(d.message, e.message) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			t.Errorf("%s:%d: expected diagnostic %q, got:", e.file, e.line, e.message)
+			for _, d := range l.diagnostics {
+				t.Log(d)
+			}
+		}
+	}
+
+	for _, d := range l.diagnostics {
+		if d.file == valid {
+			t.Errorf("unexpected diagnostic for valid config: %s", d)
+		}
+	}
+}
@@ -18,3 +18,6 @@ func Connect() (*http.Client, net.Conn, error) {
 
 	return httpClient, conn, nil
 }
+
+// SetPathPrefix has no effect on Windows as named pipes are used
+func SetPathPrefix(prefix string) {}
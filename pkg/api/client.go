@@ -8,14 +8,19 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/go-compile/localrelay/internal/ipc"
+	"github.com/go-compile/localrelay/v2"
 )
 
 var (
-	ErrNotOk    = errors.New("status code not ok")
-	ErrFailure  = errors.New("localrelay failed executing the requested action")
-	ErrNotFound = errors.New("relay not found")
+	ErrNotOk     = errors.New("status code not ok")
+	ErrFailure   = errors.New("localrelay failed executing the requested action")
+	ErrNotFound  = errors.New("relay not found")
+	ErrNotBanned = errors.New("ip is not banned")
+	// ErrConnNotFound is returned when no connection has the requested ID
+	ErrConnNotFound = errors.New("connection not found")
 )
 
 type Client struct {
@@ -81,8 +86,48 @@ func (c *Client) GetConnections() ([]Connection, error) {
 	return pool, nil
 }
 
+// GetConnection returns the active connection with the provided ID
+func (c *Client) GetConnection(id uint64) (*Connection, error) {
+	resp, err := c.hc.Get("http://lr/connection/" + strconv.FormatUint(id, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+	case 404:
+		return nil, ErrConnNotFound
+	default:
+		return nil, ErrNotOk
+	}
+
+	var conn Connection
+	if err := json.NewDecoder(resp.Body).Decode(&conn); err != nil {
+		return nil, err
+	}
+
+	return &conn, nil
+}
+
+// DropConn closes the active connection with the provided ID
+func (c *Client) DropConn(id uint64) error {
+	resp, err := c.hc.Post("http://lr/drop/conn/"+strconv.FormatUint(id, 10), "application/json", nil)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return nil
+	case 404:
+		return ErrConnNotFound
+	default:
+		return ErrNotOk
+	}
+}
+
 func (c *Client) DropRelay(relay string) error {
-	resp, err := c.hc.Get("http://lr/drop/relay/" + url.PathEscape(relay))
+	resp, err := c.hc.Post("http://lr/drop/relay/"+url.PathEscape(relay), "application/json", nil)
 	if err != nil {
 		return err
 	}
@@ -95,7 +140,7 @@ func (c *Client) DropRelay(relay string) error {
 }
 
 func (c *Client) DropIP(ip string) error {
-	resp, err := c.hc.Get("http://lr/drop/ip/" + url.PathEscape(ip))
+	resp, err := c.hc.Post("http://lr/drop/ip/"+url.PathEscape(ip), "application/json", nil)
 	if err != nil {
 		return err
 	}
@@ -108,7 +153,7 @@ func (c *Client) DropIP(ip string) error {
 }
 
 func (c *Client) DropAll() error {
-	resp, err := c.hc.Get("http://lr/drop")
+	resp, err := c.hc.Post("http://lr/drop", "application/json", nil)
 	if err != nil {
 		return err
 	}
@@ -120,8 +165,279 @@ func (c *Client) DropAll() error {
 	return nil
 }
 
+// SetLimits updates the bandwidth limits of a running relay
+func (c *Client) SetLimits(relay string, limits localrelay.Limits) error {
+	body, err := json.Marshal(limits)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.hc.Post("http://lr/limits/"+url.PathEscape(relay), "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return nil
+	case 404:
+		return ErrNotFound
+	default:
+		return ErrNotOk
+	}
+}
+
+// GetACL returns the access control rules of a running relay
+func (c *Client) GetACL(relay string) ([]string, error) {
+	resp, err := c.hc.Get("http://lr/acl/" + url.PathEscape(relay))
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+	case 404:
+		return nil, ErrNotFound
+	default:
+		return nil, ErrNotOk
+	}
+
+	var rules []string
+	if err := json.NewDecoder(resp.Body).Decode(&rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// SetACL replaces the access control rules of a running relay
+func (c *Client) SetACL(relay string, rules []string) error {
+	body, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.hc.Post("http://lr/acl/"+url.PathEscape(relay), "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return nil
+	case 404:
+		return ErrNotFound
+	}
+
+	var response msgResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return ErrNotOk
+	}
+
+	return errors.New(response.Message)
+}
+
+// GetDestinations lists the destinations of a running relay
+func (c *Client) GetDestinations(relay string) ([]Destination, error) {
+	resp, err := c.hc.Get("http://lr/destinations/" + url.PathEscape(relay))
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+	case 404:
+		return nil, ErrNotFound
+	default:
+		return nil, ErrNotOk
+	}
+
+	var destinations []Destination
+	if err := json.NewDecoder(resp.Body).Decode(&destinations); err != nil {
+		return nil, err
+	}
+
+	return destinations, nil
+}
+
+// ChangeDestination adds, removes, reweights, enables, disables or drains
+// a destination of a running relay
+func (c *Client) ChangeDestination(relay string, change DestinationChange) error {
+	return c.post("http://lr/destinations/"+url.PathEscape(relay), change)
+}
+
+// ChangeProxy sets or removes a proxy of a running relay
+func (c *Client) ChangeProxy(relay string, change ProxyChange) error {
+	return c.post("http://lr/proxies/"+url.PathEscape(relay), change)
+}
+
+// post sends v as json and returns the daemon's message as an error if
+// the request failed
+func (c *Client) post(u string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.hc.Post(u, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return nil
+	case 404:
+		return ErrNotFound
+	}
+
+	var response msgResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return ErrNotOk
+	}
+
+	return errors.New(response.Message)
+}
+
+// StartCapture records the traffic of a running relay, optionally limited
+// to the provided client IPs. The path of the capture file is returned.
+func (c *Client) StartCapture(relay string, ips []string) (string, error) {
+	if ips == nil {
+		ips = []string{}
+	}
+
+	body, err := json.Marshal(ips)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.hc.Post("http://lr/capture/"+url.PathEscape(relay), "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return "", err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		var capture Capture
+		if err := json.NewDecoder(resp.Body).Decode(&capture); err != nil {
+			return "", err
+		}
+
+		return capture.Path, nil
+	case 404:
+		return "", ErrNotFound
+	}
+
+	var response msgResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", ErrNotOk
+	}
+
+	return "", errors.New(response.Message)
+}
+
+// StopCapture stops the traffic capture of a running relay
+func (c *Client) StopCapture(relay string) error {
+	resp, err := c.hc.Post("http://lr/capture/stop/"+url.PathEscape(relay), "application/json", nil)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return nil
+	case 404:
+		return ErrNotFound
+	}
+
+	var response msgResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return ErrNotOk
+	}
+
+	return errors.New(response.Message)
+}
+
+// GetBans lists the banned clients of every running relay
+func (c *Client) GetBans() ([]Ban, error) {
+	resp, err := c.hc.Get("http://lr/bans")
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, ErrNotOk
+	}
+
+	var bans []Ban
+	if err := json.NewDecoder(resp.Body).Decode(&bans); err != nil {
+		return nil, err
+	}
+
+	return bans, nil
+}
+
+// Unban removes the ip's bans from every relay
+func (c *Client) Unban(ip string) error {
+	resp, err := c.hc.Post("http://lr/unban/"+url.PathEscape(ip), "application/json", nil)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return nil
+	case 404:
+		return ErrNotBanned
+	default:
+		return ErrFailure
+	}
+}
+
+// Reload rereads the relay configs and applies the changes
+func (c *Client) Reload() (*Reload, error) {
+	resp, err := c.hc.Post("http://lr/reload", "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, ErrNotOk
+	}
+
+	var reload Reload
+	if err := json.NewDecoder(resp.Body).Decode(&reload); err != nil {
+		return nil, err
+	}
+
+	return &reload, nil
+}
+
+// RestartRelay reopens the listeners of a running relay, established
+// connections are kept. A failed relay is started again.
+func (c *Client) RestartRelay(relay string) error {
+	resp, err := c.hc.Post("http://lr/restart/"+url.PathEscape(relay), "application/json", nil)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return nil
+	case 404:
+		return ErrNotFound
+	}
+
+	var response msgResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return ErrNotOk
+	}
+
+	return errors.New(response.Message)
+}
+
 func (c *Client) StopRelay(relay string) error {
-	resp, err := c.hc.Get("http://lr/stop/" + url.PathEscape(relay))
+	resp, err := c.hc.Post("http://lr/stop/"+url.PathEscape(relay), "application/json", nil)
 	if err != nil {
 		return err
 	}
@@ -138,6 +454,28 @@ func (c *Client) StopRelay(relay string) error {
 	}
 }
 
+// ShutdownRelay stops the relay once its connections have closed. After
+// the drain timeout the remaining connections are closed.
+func (c *Client) ShutdownRelay(relay string, drain time.Duration) error {
+	// the daemon responds once the relay has stopped
+	hc := *c.hc
+	hc.Timeout += drain
+
+	resp, err := hc.Post("http://lr/stop/"+url.PathEscape(relay)+"?drain="+url.QueryEscape(drain.String()), "application/json", nil)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return nil
+	case 404:
+		return ErrNotFound
+	default:
+		return ErrFailure
+	}
+}
+
 func (c *Client) StartRelay(relays ...string) (responses []string, err error) {
 	for _, relay := range relays {
 		// make post request to run relay. Use strconv instead of json encoding for performance
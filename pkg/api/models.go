@@ -8,16 +8,79 @@ type Status struct {
 	Version string
 	// Metrics contains relay name as the index
 	Metrics map[string]Metrics
+	// States contains relay name as the index
+	States map[string]RelayState
 	// Started is a unix timestamp of when the daemon was created
 	Started int64
+	// Totals include relays which have since stopped
+	Totals Totals
+}
+
+// RelayState is the lifecycle of a relay
+type RelayState struct {
+	// State is starting, running, draining, stopped or failed
+	State string
+	// LastError is the error the relay last failed with
+	LastError string
+	// Started is a unix timestamp of when the relay last started listening
+	Started int64
+	// Restarts is how many times the relay has started listening again
+	Restarts int
+}
+
+// Totals count TCP and UDP traffic since the daemon started
+type Totals struct {
+	Accepted, Streams, DialFails uint64
+	// In and Out are only added once a stream has finished
+	In, Out int64
+	// Restarts counts the failed relays restarted by the daemon
+	Restarts uint64
 }
 
 type Metrics struct {
 	In, Out, Active, DialAvg  int
 	TotalConns, TotalRequests uint64
+
+	// Limits are the bandwidth limits in bytes per second
+	Limits localrelay.Limits
+	// Throttled is the amount of times traffic was delayed by a limit
+	Throttled uint64
+	// Throttling is the amount of connections currently being delayed
+	Throttling int
+	// Denied is the amount of connections rejected by the ACL
+	Denied uint64
+	// Closed counts closed connections by reason e.g. eof, idle, lifetime
+	Closed map[string]uint64
+
+	// DialLatency only includes successful dials
+	DialLatency  Latency
+	ConnDuration Latency
+	// TTFB is the time to first byte of HTTP responses
+	TTFB Latency
+
+	// Destinations and Proxies are keyed by destination and proxy name
+	Destinations map[string]Target
+	Proxies      map[string]Target
+}
+
+// Latency holds the percentiles of a histogram in milliseconds
+type Latency struct {
+	Count         uint64
+	P50, P90, P99 float64
+}
+
+// Target holds the statistics of a destination or proxy
+type Target struct {
+	// Dials counts successful dials and Failures the failed dials
+	Dials, Failures uint64
+	In, Out         int64
+	DialLatency     Latency
 }
 
 type Connection struct {
+	// ID uniquely identifies the connection while the daemon is running
+	ID uint64
+
 	LocalAddr  string
 	RemoteAddr string
 	Network    string
@@ -26,7 +89,70 @@ type Connection struct {
 	RelayHost string
 
 	ForwardedAddr string
+	Destination   string
+	// Proxy is the name of the proxy used, empty when dialled directly
+	Proxy string
+	// State is either dialing, streaming or closing
+	State string
+
+	// BytesIn is received from the destination, BytesOut is sent to it
+	BytesIn  int64
+	BytesOut int64
+
+	// Opened and LastActive are unix timestamps
+	Opened     int64
+	LastActive int64
+}
+
+type Ban struct {
+	Relay  string
+	IP     string
+	Reason string
+
+	// Created and Expires are unix timestamps
+	Created int64
+	Expires int64
+}
+
+// Capture is a traffic capture started by the daemon
+type Capture struct {
+	// Path is the file the capture is written to
+	Path string
+}
+
+// Reload is the outcome of reloading the relay configs. Each list holds
+// relay names, Errors holds the files or relays which could not be reloaded.
+type Reload struct {
+	Started   []string
+	Stopped   []string
+	Updated   []string
+	Restarted []string
+	Errors    []string
+}
+
+// Destination is a destination of a running relay
+type Destination struct {
+	Destination string
+	// State is either active, disabled or draining
+	State string
+	// Conns is the amount of established connections to the destination
+	Conns int
+}
+
+// DestinationChange modifies the destinations of a running relay
+type DestinationChange struct {
+	// Action is one of add, rm, enable, disable, drain or weight
+	Action      string
+	Destination string
+	// Weight is the load balancer weight set by the weight action
+	Weight uint
+}
 
-	// Opened is a unix timestamp
-	Opened int64
+// ProxyChange adds, replaces or removes a proxy of a running relay
+type ProxyChange struct {
+	// Action is either set or rm
+	Action string
+	Name   string
+	// Address is the proxy URL set by the set action e.g. socks5://127.0.0.1:9050
+	Address string
 }
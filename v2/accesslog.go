@@ -0,0 +1,276 @@
+package localrelay
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AccessLogFormat selects how access log entries are written
+type AccessLogFormat string
+
+const (
+	// AccessLogCombined writes HTTP requests in the Combined Log Format
+	// and connections as a single space separated line
+	AccessLogCombined AccessLogFormat = "combined"
+	// AccessLogJSON writes a JSON object per entry
+	AccessLogJSON AccessLogFormat = "json"
+)
+
+// ErrInvalidAccessLogFormat is returned when a format is not combined or json
+var ErrInvalidAccessLogFormat = errors.New("invalid access log format, expected combined or json")
+
+// clfTime is the timestamp layout used by the Combined Log Format
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLog writes a summary of every finished connection or HTTP request.
+// It is safe to share between relays.
+type AccessLog struct {
+	m      sync.Mutex
+	w      io.Writer
+	format AccessLogFormat
+}
+
+// ConnAccess is the access log entry of a finished connection
+type ConnAccess struct {
+	Time        time.Time     `json:"time"`
+	Relay       string        `json:"relay"`
+	Client      string        `json:"client"`
+	Destination string        `json:"destination"`
+	Proxy       string        `json:"proxy"`
+	BytesUp     int64         `json:"bytes_up"`
+	BytesDown   int64         `json:"bytes_down"`
+	Duration    time.Duration `json:"-"`
+	Reason      CloseReason   `json:"reason"`
+}
+
+// RequestAccess is the access log entry of a HTTP request
+type RequestAccess struct {
+	Time        time.Time     `json:"time"`
+	Relay       string        `json:"relay"`
+	Client      string        `json:"client"`
+	User        string        `json:"user"`
+	Method      string        `json:"method"`
+	URI         string        `json:"uri"`
+	Protocol    string        `json:"protocol"`
+	Status      int           `json:"status"`
+	Bytes       int64         `json:"bytes"`
+	Referer     string        `json:"referer"`
+	UserAgent   string        `json:"user_agent"`
+	Destination string        `json:"destination"`
+	Duration    time.Duration `json:"-"`
+}
+
+// NewAccessLog creates an access log writing to w
+func NewAccessLog(w io.Writer, format AccessLogFormat) *AccessLog {
+	return &AccessLog{
+		w:      w,
+		format: format,
+	}
+}
+
+// ParseAccessLogFormat parses "combined" or "json"
+func ParseAccessLogFormat(format string) (AccessLogFormat, error) {
+	switch AccessLogFormat(strings.ToLower(format)) {
+	case AccessLogCombined:
+		return AccessLogCombined, nil
+	case AccessLogJSON:
+		return AccessLogJSON, nil
+	default:
+		return "", errors.Wrapf(ErrInvalidAccessLogFormat, "%q", format)
+	}
+}
+
+// SetAccessLog sets where finished connections and requests are logged,
+// nil disables access logging
+func (r *Relay) SetAccessLog(a *AccessLog) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.accessLog = a
+}
+
+// AccessLog returns the relay's access log or nil if disabled
+func (r *Relay) AccessLog() *AccessLog {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.accessLog
+}
+
+// Conn writes the entry of a finished connection
+func (a *AccessLog) Conn(e ConnAccess) error {
+	if a.format == AccessLogJSON {
+		return a.writeJSON(struct {
+			ConnAccess
+			DurationMs int64 `json:"duration_ms"`
+		}{e, e.Duration.Milliseconds()})
+	}
+
+	return a.write(strings.Join([]string{
+		"[" + e.Time.Format(clfTime) + "]",
+		e.Relay,
+		orDash(e.Client),
+		orDash(e.Destination),
+		orDash(e.Proxy),
+		strconv.FormatInt(e.BytesUp, 10),
+		strconv.FormatInt(e.BytesDown, 10),
+		strconv.FormatInt(e.Duration.Milliseconds(), 10),
+		orDash(string(e.Reason)),
+	}, " ") + "\n")
+}
+
+// Request writes the entry of a HTTP request
+func (a *AccessLog) Request(e RequestAccess) error {
+	if a.format == AccessLogJSON {
+		return a.writeJSON(struct {
+			RequestAccess
+			DurationMs int64 `json:"duration_ms"`
+		}{e, e.Duration.Milliseconds()})
+	}
+
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+
+	// host ident authuser [date] "request" status bytes "referer" "user-agent"
+	return a.write(orDash(e.Client) + " - " + orDash(e.User) + " [" + e.Time.Format(clfTime) + "] " +
+		strconv.Quote(e.Method+" "+e.URI+" "+e.Protocol) + " " + strconv.Itoa(e.Status) + " " + bytes + " " +
+		strconv.Quote(orDash(e.Referer)) + " " + strconv.Quote(orDash(e.UserAgent)) + "\n")
+}
+
+func (a *AccessLog) writeJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return a.write(string(b) + "\n")
+}
+
+func (a *AccessLog) write(line string) error {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	_, err := io.WriteString(a.w, line)
+	return err
+}
+
+// logConn writes the access log entry of a closed connection
+func (r *Relay) logConn(tc *trackedConn) {
+	a := r.AccessLog()
+	if a == nil {
+		return
+	}
+
+	pc := tc.snapshot()
+	err := a.Conn(ConnAccess{
+		Time:        pc.Opened,
+		Relay:       r.Name,
+		Client:      addrString(pc.Conn.RemoteAddr()),
+		Destination: string(pc.Destination),
+		Proxy:       pc.Proxy,
+		BytesUp:     pc.BytesOut,
+		BytesDown:   pc.BytesIn,
+		Duration:    time.Since(pc.Opened),
+		Reason:      pc.Reason,
+	})
+
+	if err != nil {
+		r.Logger().Error("writing access log failed", "error", err)
+	}
+}
+
+// logRequest writes the access log entry of a HTTP request
+func (r *Relay) logRequest(req *http.Request, w *responseRecorder, start time.Time) {
+	a := r.AccessLog()
+	if a == nil {
+		return
+	}
+
+	user, _, _ := req.BasicAuth()
+
+	client, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		client = req.RemoteAddr
+	}
+
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	destination := ""
+	if destinations := r.Destinations(); len(destinations) > 0 {
+		destination = string(destinations[0])
+	}
+
+	err = a.Request(RequestAccess{
+		Time:        start,
+		Relay:       r.Name,
+		Client:      client,
+		User:        user,
+		Method:      req.Method,
+		URI:         req.RequestURI,
+		Protocol:    req.Proto,
+		Status:      status,
+		Bytes:       w.bytes,
+		Referer:     req.Referer(),
+		UserAgent:   req.UserAgent(),
+		Destination: destination,
+		Duration:    time.Since(start),
+	})
+
+	if err != nil {
+		r.Logger().Error("writing access log failed", "error", err)
+	}
+}
+
+// responseRecorder records the status and size of a HTTP response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+
+	return n, err
+}
+
+// Flush allows streamed responses to be flushed through the recorder
+func (w *responseRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
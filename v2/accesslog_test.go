@@ -0,0 +1,85 @@
+package localrelay
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogCombined(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("hello"))
+	}))
+	defer backend.Close()
+
+	relay, err := New("test-relay", io.Discard, "http://127.0.0.1:0", TargetLink(backend.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &syncBuffer{}
+	relay.SetAccessLog(NewAccessLog(out, AccessLogCombined))
+
+	req := httptest.NewRequest("GET", "/path?q=1", nil)
+	req.RemoteAddr = "10.0.0.1:4321"
+	req.Header.Set("User-Agent", "test-agent")
+	req.SetBasicAuth("alice", "secret")
+
+	HandleHTTP(relay)(httptest.NewRecorder(), req)
+
+	line := out.String()
+	pattern := `^10\.0\.0\.1 - alice \[[^\]]+\] "GET /path\?q=1 HTTP/1\.1" 418 5 "-" "test-agent"\n$`
+	if !regexp.MustCompile(pattern).MatchString(line) {
+		t.Fatalf("unexpected combined log line %q", line)
+	}
+}
+
+func TestAccessLogConnJSON(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relay, err := New("test-relay", io.Discard, TargetLink("tcp://"+l.Addr().String()), TargetLink("tcp://"+startEchoServer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &syncBuffer{}
+	relay.SetAccessLog(NewAccessLog(out, AccessLogJSON))
+
+	go relay.Serve(l)
+	t.Cleanup(func() { relay.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Write([]byte("ping"))
+	io.ReadFull(conn, make([]byte, 4))
+	conn.Close()
+
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "\n")
+	})
+
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(out.String()), &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry["relay"] != "test-relay" || entry["bytes_up"] != float64(4) || entry["bytes_down"] != float64(4) || entry["reason"] != "eof" {
+		t.Fatalf("unexpected entry %v", entry)
+	}
+
+	if _, ok := entry["duration_ms"]; !ok {
+		t.Fatalf("missing duration in %v", entry)
+	}
+}
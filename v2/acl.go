@@ -0,0 +1,179 @@
+package localrelay
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ACLAction decides what happens to a client matching an ACLRule
+type ACLAction string
+
+const (
+	// ACLAllow permits the client to connect
+	ACLAllow ACLAction = "allow"
+	// ACLDeny closes the client's connection before any dial is made
+	ACLDeny ACLAction = "deny"
+)
+
+var (
+	// ErrInvalidACLRule is returned when a rule can not be parsed
+	ErrInvalidACLRule = errors.New("invalid acl rule")
+)
+
+// ACLRule matches clients by IP or CIDR.
+// A nil Network matches every client.
+type ACLRule struct {
+	Action  ACLAction
+	Network *net.IPNet
+}
+
+// acl holds an ordered list of rules, the first matching rule wins.
+// If no rule matches the client is allowed.
+type acl struct {
+	m     sync.RWMutex
+	rules []ACLRule
+}
+
+// ParseACLRule parses a rule in the format "<allow|deny> <ip|cidr|all>".
+//
+// Examples:
+//
+//	allow 192.168.0.0/16
+//	allow 2001:db8::/32
+//	deny 10.0.0.8
+//	deny all
+func ParseACLRule(rule string) (ACLRule, error) {
+	fields := strings.Fields(rule)
+	if len(fields) != 2 {
+		return ACLRule{}, errors.Wrapf(ErrInvalidACLRule, "%q", rule)
+	}
+
+	action := ACLAction(strings.ToLower(fields[0]))
+	if action != ACLAllow && action != ACLDeny {
+		return ACLRule{}, errors.Wrapf(ErrInvalidACLRule, "unknown action %q", fields[0])
+	}
+
+	target := strings.ToLower(fields[1])
+	if target == "all" || target == "any" {
+		return ACLRule{Action: action}, nil
+	}
+
+	// single addresses are converted into a /32 or /128 network
+	if !strings.Contains(target, "/") {
+		ip := net.ParseIP(target)
+		if ip == nil {
+			return ACLRule{}, errors.Wrapf(ErrInvalidACLRule, "invalid ip %q", fields[1])
+		}
+
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+			bits = 32
+		}
+
+		return ACLRule{Action: action, Network: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}}, nil
+	}
+
+	_, network, err := net.ParseCIDR(target)
+	if err != nil {
+		return ACLRule{}, errors.Wrapf(ErrInvalidACLRule, "invalid cidr %q", fields[1])
+	}
+
+	return ACLRule{Action: action, Network: network}, nil
+}
+
+// ParseACL parses a list of rules, keeping their order
+func ParseACL(rules []string) ([]ACLRule, error) {
+	parsed := make([]ACLRule, 0, len(rules))
+	for _, rule := range rules {
+		r, err := ParseACLRule(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed = append(parsed, r)
+	}
+
+	return parsed, nil
+}
+
+// String returns the rule in the format accepted by ParseACLRule
+func (r ACLRule) String() string {
+	if r.Network == nil {
+		return string(r.Action) + " all"
+	}
+
+	return string(r.Action) + " " + r.Network.String()
+}
+
+// Match returns true if the rule applies to the ip
+func (r ACLRule) Match(ip net.IP) bool {
+	return r.Network == nil || r.Network.Contains(ip)
+}
+
+func (a *acl) set(rules []ACLRule) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	a.rules = append([]ACLRule(nil), rules...)
+}
+
+func (a *acl) get() []ACLRule {
+	a.m.RLock()
+	defer a.m.RUnlock()
+
+	return append([]ACLRule(nil), a.rules...)
+}
+
+// allowed checks the address against the rules in order
+func (a *acl) allowed(addr net.Addr) bool {
+	a.m.RLock()
+	defer a.m.RUnlock()
+
+	if len(a.rules) == 0 {
+		return true
+	}
+
+	host := addrHost(addr)
+
+	// remove the IPv6 zone
+	if i := strings.IndexByte(host, '%'); i != -1 {
+		host = host[:i]
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		// non IP clients (e.g. unix sockets) are not filtered
+		return true
+	}
+
+	for _, rule := range a.rules {
+		if rule.Match(ip) {
+			return rule.Action == ACLAllow
+		}
+	}
+
+	return true
+}
+
+// aclListener filters connections before they reach the http server
+type aclListener struct {
+	net.Listener
+	r *Relay
+}
+
+func (l *aclListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if l.r.permit(conn) {
+			return conn, nil
+		}
+	}
+}
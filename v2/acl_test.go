@@ -0,0 +1,50 @@
+package localrelay
+
+import "testing"
+
+func TestACLOrder(t *testing.T) {
+	rules, err := ParseACL([]string{
+		"deny 192.168.1.66",
+		"allow 192.168.0.0/16",
+		"allow 2001:db8::/32",
+		"deny all",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := acl{}
+	a.set(rules)
+
+	cases := map[string]bool{
+		"192.168.1.66:5000":        false,
+		"192.168.1.67:5000":        true,
+		"10.0.0.1:5000":            false,
+		"[2001:db8::1]:5000":       true,
+		"[2001:db9::1]:5000":       false,
+		"[fe80::1%eth0]:5000":      false,
+		"[::ffff:192.168.1.1]:500": true,
+	}
+
+	for addr, expected := range cases {
+		if allowed := a.allowed(testAddr(addr)); allowed != expected {
+			t.Errorf("%s: expected allowed=%t got %t", addr, expected, allowed)
+		}
+	}
+}
+
+func TestACLEmpty(t *testing.T) {
+	a := acl{}
+
+	if !a.allowed(testAddr("10.0.0.1:5000")) {
+		t.Fatal("empty acl must allow all clients")
+	}
+}
+
+func TestParseACLRuleInvalid(t *testing.T) {
+	for _, rule := range []string{"", "allow", "permit 10.0.0.0/8", "deny 10.0.0.0/33", "allow example.com"} {
+		if _, err := ParseACLRule(rule); err == nil {
+			t.Errorf("expected %q to be rejected", rule)
+		}
+	}
+}
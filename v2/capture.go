@@ -0,0 +1,287 @@
+package localrelay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FrameType identifies the contents of a capture frame
+type FrameType uint8
+
+const (
+	// FrameOpen starts a connection, its data is a JSON encoded CaptureConn
+	FrameOpen FrameType = iota + 1
+	// FrameUpload holds bytes sent by the client to the destination
+	FrameUpload
+	// FrameDownload holds bytes sent by the destination to the client
+	FrameDownload
+	// FrameClose ends a connection, its data is the close reason
+	FrameClose
+)
+
+// captureMagic starts every capture file, the last byte is the version
+var captureMagic = []byte("LRCAP\x01")
+
+const (
+	// frameHeaderSize is the type, conn ID, unix nano timestamp and data length
+	frameHeaderSize = 1 + 8 + 8 + 4
+	// maxFrameSize is the most data a frame may hold. Relayed traffic is
+	// captured a copy buffer at a time so real frames are far smaller,
+	// larger lengths are rejected before the data is allocated.
+	maxFrameSize = spliceChunkSize
+)
+
+var (
+	// ErrCaptureRunning is returned when a relay is already capturing
+	ErrCaptureRunning = errors.New("relay is already capturing")
+	// ErrNoCapture is returned when stopping a relay which is not capturing
+	ErrNoCapture = errors.New("relay is not capturing")
+	// ErrInvalidCapture is returned when reading a file which is not a capture
+	ErrInvalidCapture = errors.New("invalid or unsupported capture file")
+)
+
+// Frame is a single record of a capture
+type Frame struct {
+	Type   FrameType
+	ConnID uint64
+	Time   time.Time
+	Data   []byte
+}
+
+// CaptureConn describes a captured connection
+type CaptureConn struct {
+	Relay       string     `json:"relay"`
+	Client      string     `json:"client"`
+	Destination TargetLink `json:"destination"`
+	Proxy       string     `json:"proxy,omitempty"`
+}
+
+// CaptureWriter writes frames to a capture. It is safe for concurrent use.
+type CaptureWriter struct {
+	m      sync.Mutex
+	w      io.Writer
+	closed bool
+}
+
+// NewCaptureWriter writes the capture header to w
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	if _, err := w.Write(captureMagic); err != nil {
+		return nil, err
+	}
+
+	return &CaptureWriter{w: w}, nil
+}
+
+// WriteFrame appends a frame to the capture. Frames written after the
+// writer has been closed are discarded.
+func (c *CaptureWriter) WriteFrame(f Frame) error {
+	if len(f.Data) > maxFrameSize {
+		return errors.Wrapf(ErrInvalidCapture, "frame of %d bytes exceeds %d", len(f.Data), maxFrameSize)
+	}
+
+	// header and data are written together so frames are never interleaved
+	buf := make([]byte, frameHeaderSize+len(f.Data))
+	buf[0] = byte(f.Type)
+	binary.BigEndian.PutUint64(buf[1:], f.ConnID)
+	binary.BigEndian.PutUint64(buf[9:], uint64(f.Time.UnixNano()))
+	binary.BigEndian.PutUint32(buf[17:], uint32(len(f.Data)))
+	copy(buf[frameHeaderSize:], f.Data)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	_, err := c.w.Write(buf)
+	return err
+}
+
+// Close stops the writer, closing the underlying writer if it is a io.Closer
+func (c *CaptureWriter) Close() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+
+	if closer, ok := c.w.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// CaptureReader reads the frames of a capture
+type CaptureReader struct {
+	r *bufio.Reader
+}
+
+// NewCaptureReader validates the capture header of r
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != string(captureMagic) {
+		return nil, ErrInvalidCapture
+	}
+
+	return &CaptureReader{r: br}, nil
+}
+
+// Next returns the next frame or io.EOF once the capture has been read
+func (c *CaptureReader) Next() (Frame, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return Frame{}, errors.Wrap(ErrInvalidCapture, "truncated frame")
+		}
+
+		return Frame{}, err
+	}
+
+	size := binary.BigEndian.Uint32(header[17:])
+	if size > maxFrameSize {
+		return Frame{}, errors.Wrapf(ErrInvalidCapture, "frame of %d bytes exceeds %d", size, maxFrameSize)
+	}
+
+	f := Frame{
+		Type:   FrameType(header[0]),
+		ConnID: binary.BigEndian.Uint64(header[1:]),
+		Time:   time.Unix(0, int64(binary.BigEndian.Uint64(header[9:]))),
+		Data:   make([]byte, size),
+	}
+
+	if _, err := io.ReadFull(c.r, f.Data); err != nil {
+		return Frame{}, errors.Wrap(ErrInvalidCapture, "truncated frame")
+	}
+
+	return f, nil
+}
+
+// capture is an active capture of a relay
+type capture struct {
+	w *CaptureWriter
+	// ips limits the capture to these clients, empty captures everyone
+	ips map[string]struct{}
+}
+
+// StartCapture records the traffic of new connections to w until
+// StopCapture is called. When ips are provided only connections from
+// those clients are captured.
+//
+// Only TCP relays are captured. Captured connections can not be spliced
+// so throughput will be reduced while capturing.
+func (r *Relay) StartCapture(w io.Writer, ips ...string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.capture != nil {
+		return ErrCaptureRunning
+	}
+
+	cw, err := NewCaptureWriter(w)
+	if err != nil {
+		return err
+	}
+
+	c := &capture{
+		w:   cw,
+		ips: make(map[string]struct{}, len(ips)),
+	}
+
+	for _, ip := range ips {
+		c.ips[ip] = struct{}{}
+	}
+
+	r.capture = c
+	return nil
+}
+
+// StopCapture stops capturing and closes the writer given to StartCapture
+func (r *Relay) StopCapture() error {
+	r.m.Lock()
+	c := r.capture
+	r.capture = nil
+	r.m.Unlock()
+
+	if c == nil {
+		return ErrNoCapture
+	}
+
+	return c.w.Close()
+}
+
+// Capturing returns true if the relay is capturing traffic
+func (r *Relay) Capturing() bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.capture != nil
+}
+
+// startCapture returns the writer the connection should be captured to,
+// nil if it is not being captured. The open frame is written on success.
+func (r *Relay) startCapture(tc *trackedConn) *CaptureWriter {
+	r.m.Lock()
+	c := r.capture
+	r.m.Unlock()
+
+	if c == nil {
+		return nil
+	}
+
+	client := addrHost(tc.conn.RemoteAddr())
+	if _, found := c.ips[client]; len(c.ips) > 0 && !found {
+		return nil
+	}
+
+	pc := tc.snapshot()
+	data, err := json.Marshal(CaptureConn{
+		Relay:       r.Name,
+		Client:      addrString(pc.Conn.RemoteAddr()),
+		Destination: pc.Destination,
+		Proxy:       pc.Proxy,
+	})
+	if err != nil {
+		return nil
+	}
+
+	if err := c.w.WriteFrame(Frame{Type: FrameOpen, ConnID: tc.id, Time: time.Now(), Data: data}); err != nil {
+		tc.log.Error("writing capture failed", "error", err)
+		return nil
+	}
+
+	return c.w
+}
+
+// captured writes relayed bytes to the connection's capture
+func (c *trackedConn) captured(b []byte, upload bool) {
+	t := FrameDownload
+	if upload {
+		t = FrameUpload
+	}
+
+	if err := c.capture.WriteFrame(Frame{Type: t, ConnID: c.id, Time: time.Now(), Data: b}); err != nil {
+		c.log.Error("writing capture failed", "error", err)
+	}
+}
+
+// endCapture writes the close frame of a captured connection
+func (c *trackedConn) endCapture() {
+	if c.capture == nil {
+		return
+	}
+
+	c.capture.WriteFrame(Frame{Type: FrameClose, ConnID: c.id, Time: time.Now(), Data: []byte(c.closeReason())})
+}
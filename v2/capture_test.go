@@ -0,0 +1,245 @@
+package localrelay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	w, err := NewCaptureWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	frames := []Frame{
+		{Type: FrameOpen, ConnID: 7, Time: now, Data: []byte(`{}`)},
+		{Type: FrameUpload, ConnID: 7, Time: now.Add(time.Millisecond), Data: []byte("ping")},
+		{Type: FrameClose, ConnID: 7, Time: now.Add(time.Second), Data: []byte{}},
+	}
+
+	for _, f := range frames {
+		if err := w.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w.Close()
+
+	// discarded once closed
+	w.WriteFrame(Frame{Type: FrameUpload})
+
+	r, err := NewCaptureReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range frames {
+		f, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f.Type != expected.Type || f.ConnID != expected.ConnID || !f.Time.Equal(expected.Time) || !bytes.Equal(f.Data, expected.Data) {
+			t.Fatalf("expected frame %+v got %+v", expected, f)
+		}
+	}
+
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("expected EOF got %v", err)
+	}
+}
+
+func TestCaptureInvalid(t *testing.T) {
+	if _, err := NewCaptureReader(strings.NewReader("not a capture")); !errors.Is(err, ErrInvalidCapture) {
+		t.Fatalf("expected ErrInvalidCapture got %v", err)
+	}
+
+	r, err := NewCaptureReader(bytes.NewReader(append(captureMagic, byte(FrameUpload), 0, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Next(); !errors.Is(err, ErrInvalidCapture) {
+		t.Fatalf("expected ErrInvalidCapture got %v", err)
+	}
+}
+
+func TestCaptureFrameSize(t *testing.T) {
+	header := func(size uint32) []byte {
+		h := make([]byte, frameHeaderSize)
+		h[0] = byte(FrameUpload)
+		binary.BigEndian.PutUint32(h[17:], size)
+
+		return append(append([]byte{}, captureMagic...), h...)
+	}
+
+	// a length of 4 GiB must be rejected before the data is allocated
+	r, err := NewCaptureReader(bytes.NewReader(header(math.MaxUint32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Next(); !errors.Is(err, ErrInvalidCapture) {
+		t.Fatalf("expected ErrInvalidCapture got %v", err)
+	}
+
+	// the data is shorter than the frame's length
+	r, err = NewCaptureReader(bytes.NewReader(append(header(10), "ping"...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Next(); !errors.Is(err, ErrInvalidCapture) {
+		t.Fatalf("expected ErrInvalidCapture got %v", err)
+	}
+
+	w, err := NewCaptureWriter(io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.WriteFrame(Frame{Type: FrameUpload, Data: make([]byte, maxFrameSize+1)}); !errors.Is(err, ErrInvalidCapture) {
+		t.Fatalf("expected oversized frames to be refused got %v", err)
+	}
+}
+
+func TestRelayCapture(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relay, err := New("test-relay", io.Discard, TargetLink("tcp://"+l.Addr().String()), TargetLink("tcp://"+startEchoServer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &syncBuffer{}
+	if err := relay.StartCapture(out, "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := relay.StartCapture(out); err != ErrCaptureRunning {
+		t.Fatalf("expected ErrCaptureRunning got %v", err)
+	}
+
+	go relay.Serve(l)
+	t.Cleanup(func() { relay.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Write([]byte("ping"))
+	io.ReadFull(conn, make([]byte, 4))
+	conn.Close()
+
+	waitFor(t, func() bool {
+		return relay.Metrics.Snapshot().Active == 0 && len(relay.GetConns()) == 0
+	})
+
+	if err := relay.StopCapture(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := relay.StopCapture(); err != ErrNoCapture {
+		t.Fatalf("expected ErrNoCapture got %v", err)
+	}
+
+	r, err := NewCaptureReader(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		types            []FrameType
+		upload, download []byte
+	)
+
+	for {
+		f, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		types = append(types, f.Type)
+
+		switch f.Type {
+		case FrameOpen:
+			var meta CaptureConn
+			if err := json.Unmarshal(f.Data, &meta); err != nil {
+				t.Fatal(err)
+			}
+
+			if meta.Relay != "test-relay" || !strings.HasPrefix(meta.Client, "127.0.0.1:") {
+				t.Fatalf("unexpected open frame %+v", meta)
+			}
+		case FrameUpload:
+			upload = append(upload, f.Data...)
+		case FrameDownload:
+			download = append(download, f.Data...)
+		}
+	}
+
+	if types[0] != FrameOpen || types[len(types)-1] != FrameClose {
+		t.Fatalf("unexpected frames %v", types)
+	}
+
+	if string(upload) != "ping" || string(download) != "ping" {
+		t.Fatalf("expected ping in both directions got %q and %q", upload, download)
+	}
+}
+
+func TestRelayCaptureIPFilter(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relay, err := New("test-relay", io.Discard, TargetLink("tcp://"+l.Addr().String()), TargetLink("tcp://"+startEchoServer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &syncBuffer{}
+	if err := relay.StartCapture(out, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	go relay.Serve(l)
+	t.Cleanup(func() { relay.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Write([]byte("ping"))
+	io.ReadFull(conn, make([]byte, 4))
+	conn.Close()
+
+	waitFor(t, func() bool {
+		return len(relay.GetConns()) == 0
+	})
+
+	if out.String() != string(captureMagic) {
+		t.Fatalf("expected no frames got %q", out.String())
+	}
+}
@@ -0,0 +1,293 @@
+package localrelay
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnState is the stage of a relayed connection
+type ConnState string
+
+const (
+	// ConnDialing is used while a destination is being dialled
+	ConnDialing ConnState = "dialing"
+	// ConnStreaming is used once traffic is being relayed
+	ConnStreaming ConnState = "streaming"
+	// ConnClosing is used once either side has closed
+	ConnClosing ConnState = "closing"
+)
+
+// connIDs is shared by all relays so IDs are unique within the process
+var connIDs uint64
+
+// PooledConn is a snapshot of a relayed connection
+type PooledConn struct {
+	// ID uniquely identifies the connection
+	ID   uint64
+	Conn net.Conn
+	// RemoteAddr is the address of the connected destination
+	RemoteAddr string
+	// Destination is the chosen destination
+	Destination TargetLink
+	// Proxy is the name of the proxy used, empty when dialled directly
+	Proxy  string
+	Opened time.Time
+	State  ConnState
+
+	// BytesIn is received from the destination, BytesOut is sent to it
+	BytesIn, BytesOut int64
+	LastActive        time.Time
+	// Reason is set once the connection has closed
+	Reason CloseReason
+
+	tc *trackedConn
+}
+
+// Drop closes the connection and records it as dropped
+func (c *PooledConn) Drop() error {
+	return c.tc.drop()
+}
+
+// trackedConn is the live record of a connection held by the relay
+type trackedConn struct {
+	// atomically updated, kept first for 64-bit alignment on 32-bit platforms
+	lastActive int64
+	bytesIn    int64
+	bytesOut   int64
+
+	id     uint64
+	conn   net.Conn
+	opened time.Time
+
+	// limiter and ipLimiter apply the per connection and per IP bandwidth limits
+	limiter   *limiter
+	ipLimiter *limiter
+
+	// reason holds the CloseReason once known
+	reason atomic.Value
+
+	// stats are the destination and proxy metrics, set once dialled
+	stats []*targetStats
+
+	// log includes the connection ID and client address in every record
+	log *slog.Logger
+
+	// capture receives the relayed traffic when the connection is captured
+	capture *CaptureWriter
+
+	m           sync.Mutex
+	remoteAddr  string
+	destination TargetLink
+	proxy       string
+	state       ConnState
+
+	// target is the relay destination being used before the port offset
+	// of a listener range is applied
+	target TargetLink
+}
+
+// storeConn registers a newly accepted connection.
+// To remove this conn from the pool, provide it to popConn()
+func (r *Relay) storeConn(conn net.Conn) *trackedConn {
+	connLimiter, ipLimiter := r.throttle.acquire(conn.RemoteAddr())
+
+	id := atomic.AddUint64(&connIDs, 1)
+
+	now := time.Now()
+	tc := &trackedConn{
+		id:     id,
+		conn:   conn,
+		opened: now,
+		state:  ConnDialing,
+		log:    r.Logger().With("conn_id", id, "client", addrString(conn.RemoteAddr())),
+
+		limiter:    connLimiter,
+		ipLimiter:  ipLimiter,
+		lastActive: now.UnixNano(),
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.conns[tc.id] = tc
+	return tc
+}
+
+// popConn removes the connection from the pool
+func (r *Relay) popConn(tc *trackedConn) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if _, found := r.conns[tc.id]; !found {
+		return
+	}
+
+	r.throttle.release(tc.conn.RemoteAddr())
+	delete(r.conns, tc.id)
+
+	if len(r.conns) == 0 && r.idle != nil {
+		close(r.idle)
+		r.idle = nil
+	}
+}
+
+// GetConns returns a snapshot of all the active connections to this relay
+func (r *Relay) GetConns() []*PooledConn {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	conns := make([]*PooledConn, 0, len(r.conns))
+	for _, tc := range r.conns {
+		conns = append(conns, tc.snapshot())
+	}
+
+	return conns
+}
+
+// GetConn returns a snapshot of the connection with the provided ID
+func (r *Relay) GetConn(id uint64) (*PooledConn, bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	tc, found := r.conns[id]
+	if !found {
+		return nil, false
+	}
+
+	return tc.snapshot(), true
+}
+
+// DropConn closes the connection with the provided ID.
+// Returns false if the connection does not exist.
+func (r *Relay) DropConn(id uint64) bool {
+	r.m.Lock()
+	tc, found := r.conns[id]
+	r.m.Unlock()
+
+	if !found {
+		return false
+	}
+
+	tc.drop()
+	return true
+}
+
+// dropIP closes all active connections from the ip
+func (r *Relay) dropIP(ip string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, tc := range r.conns {
+		if addrHost(tc.conn.RemoteAddr()) == ip {
+			go tc.drop()
+		}
+	}
+}
+
+func (c *trackedConn) snapshot() *PooledConn {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return &PooledConn{
+		ID:          c.id,
+		Conn:        c.conn,
+		RemoteAddr:  c.remoteAddr,
+		Destination: c.destination,
+		Proxy:       c.proxy,
+		Opened:      c.opened,
+		State:       c.state,
+
+		BytesIn:    atomic.LoadInt64(&c.bytesIn),
+		BytesOut:   atomic.LoadInt64(&c.bytesOut),
+		LastActive: c.lastActiveTime(),
+		Reason:     c.closeReason(),
+
+		tc: c,
+	}
+}
+
+// connected records the destination once dialled. Must be called before
+// any traffic is relayed.
+func (c *trackedConn) connected(remote net.Addr, destination TargetLink, proxy string, stats []*targetStats) {
+	c.stats = stats
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.remoteAddr = remote.String()
+	c.destination = destination
+	c.proxy = proxy
+	c.state = ConnStreaming
+}
+
+// setTarget records the relay destination the connection is dialling
+func (c *trackedConn) setTarget(target TargetLink) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.target = target
+}
+
+func (c *trackedConn) getTarget() TargetLink {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.target
+}
+
+func (c *trackedConn) setState(state ConnState) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.state = state
+}
+
+// transferred records relayed traffic, resetting the idle timeout
+func (c *trackedConn) transferred(n int, upload bool) {
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+
+	for _, t := range c.stats {
+		t.bandwidth(n, upload)
+	}
+
+	if upload {
+		atomic.AddInt64(&c.bytesOut, int64(n))
+		return
+	}
+
+	atomic.AddInt64(&c.bytesIn, int64(n))
+}
+
+func (c *trackedConn) lastActiveTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastActive))
+}
+
+// setReason records why the connection closed, the first reason is kept
+func (c *trackedConn) setReason(reason CloseReason) {
+	c.reason.CompareAndSwap(nil, reason)
+}
+
+// closeReason returns why the connection was closed or an empty string
+// if it is still open
+func (c *trackedConn) closeReason() CloseReason {
+	reason, _ := c.reason.Load().(CloseReason)
+	return reason
+}
+
+// closedLog logs the outcome of the connection once it has closed
+func (c *trackedConn) closedLog() {
+	c.log.Info("connection closed",
+		"bytes_in", atomic.LoadInt64(&c.bytesIn),
+		"bytes_out", atomic.LoadInt64(&c.bytesOut),
+		"duration", time.Since(c.opened),
+		"reason", c.closeReason())
+}
+
+// drop closes the connection and records it as dropped
+func (c *trackedConn) drop() error {
+	c.setReason(CloseDropped)
+	return c.conn.Close()
+}
@@ -0,0 +1,70 @@
+package localrelay
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestConnRegistry(t *testing.T) {
+	relay, err := New("test-relay", io.Discard, "tcp://127.0.0.1:23832", "tcp://127.0.0.1:23838")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	a := relay.storeConn(client)
+	b := relay.storeConn(&net.TCPConn{})
+
+	if a.id == b.id {
+		t.Fatal("connection IDs must be unique")
+	}
+
+	a.connected(testAddr("10.0.0.2:80"), "tcp://10.0.0.2:80", "tor", nil)
+	a.transferred(10, true)
+	a.transferred(4, false)
+
+	pc, found := relay.GetConn(a.id)
+	if !found {
+		t.Fatal("connection not found")
+	}
+
+	if pc.RemoteAddr != "10.0.0.2:80" || pc.Proxy != "tor" || pc.State != ConnStreaming {
+		t.Fatalf("unexpected snapshot %+v", pc)
+	}
+
+	if pc.BytesOut != 10 || pc.BytesIn != 4 {
+		t.Fatalf("expected 10 bytes out and 4 in, got %d and %d", pc.BytesOut, pc.BytesIn)
+	}
+
+	// snapshots must not change once taken
+	a.transferred(10, true)
+	if pc.BytesOut != 10 {
+		t.Fatal("snapshot was modified")
+	}
+
+	if len(relay.GetConns()) != 2 {
+		t.Fatal("expected 2 connections")
+	}
+
+	if !relay.DropConn(a.id) {
+		t.Fatal("connection was not dropped")
+	}
+
+	if a.closeReason() != CloseDropped {
+		t.Fatalf("expected reason %q got %q", CloseDropped, a.closeReason())
+	}
+
+	relay.popConn(a)
+	relay.popConn(a)
+
+	if relay.DropConn(a.id) {
+		t.Fatal("removed connection was dropped")
+	}
+
+	if len(relay.GetConns()) != 1 {
+		t.Fatal("expected 1 connection")
+	}
+}
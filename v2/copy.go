@@ -0,0 +1,147 @@
+package localrelay
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// copyBufferSize is the size of the pooled buffers used to relay traffic
+	copyBufferSize = 32 * 1024
+	// spliceChunkSize is the most data spliced before metrics are updated
+	spliceChunkSize = 256 * 1024
+)
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	},
+}
+
+// closeWriter is implemented by TCP, unix and TLS connections
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// copier relays traffic from src to dst until either side fails or src
+// reaches EOF. On EOF only the write side of dst is closed, allowing the
+// other direction to keep flowing. On error both connections are closed.
+// upload is true when src is the client.
+//
+// When splice is true and no bandwidth limit is active the kernel copies
+// the data directly between the sockets.
+//
+// NOTE: static function for maximum performance
+func copier(dst, src net.Conn, upload, splice bool, r *Relay, tc *trackedConn) error {
+	var buf []byte
+
+	for {
+		if splice && !r.throttle.enabled() {
+			n, err := spliceChunk(dst, src)
+			if n > 0 {
+				tc.transferred(int(n), upload)
+				record(r, int(n), upload)
+			}
+
+			if err != nil {
+				return finish(err, src, dst)
+			}
+
+			continue
+		}
+
+		if buf == nil {
+			bufp := bufferPool.Get().(*[]byte)
+			defer bufferPool.Put(bufp)
+
+			buf = *bufp
+		}
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			tc.transferred(n, upload)
+			record(r, n, upload)
+
+			if tc.capture != nil {
+				tc.captured(buf[:n], upload)
+			}
+
+			if r.throttle.wait(n, upload, tc.limiter, tc.ipLimiter) {
+				r.Metrics.throttle()
+			}
+
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return closeConns(werr, src, dst)
+			}
+		}
+
+		if err != nil {
+			return finish(err, src, dst)
+		}
+	}
+}
+
+// spliceChunk copies up to spliceChunkSize bytes using dst's ReadFrom,
+// returning io.EOF once src has been fully read
+func spliceChunk(dst, src net.Conn) (int64, error) {
+	lr := &io.LimitedReader{R: src, N: spliceChunkSize}
+
+	n, err := dst.(io.ReaderFrom).ReadFrom(lr)
+	if err != nil {
+		return n, err
+	}
+
+	// ReadFrom stops early without an error when src reaches EOF
+	if lr.N > 0 {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// canSplice returns true if the kernel can copy directly between the conns
+func canSplice(a, b net.Conn) bool {
+	if !spliceSupported {
+		return false
+	}
+
+	_, aTCP := a.(*net.TCPConn)
+	_, bTCP := b.(*net.TCPConn)
+
+	return aTCP && bTCP
+}
+
+// record adds the relayed bytes to the relay's metrics
+func record(r *Relay, n int, upload bool) {
+	if upload {
+		r.Metrics.bandwidth(n, 0)
+		return
+	}
+
+	r.Metrics.bandwidth(0, n)
+}
+
+// finish ends one direction of the stream. If src reached EOF the EOF is
+// propagated to dst by closing its write side, if this is not possible
+// both connections are closed.
+func finish(err error, src, dst net.Conn) error {
+	if errors.Is(err, io.EOF) {
+		if cw, ok := dst.(closeWriter); ok && cw.CloseWrite() == nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return closeConns(err, src, dst)
+}
+
+// closeConns closes both connections and returns the cause
+func closeConns(err error, a, b net.Conn) error {
+	a.Close()
+	b.Close()
+
+	return errors.WithStack(err)
+}
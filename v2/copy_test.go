@@ -0,0 +1,203 @@
+package localrelay
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var halfClosePayload = make([]byte, 256*1024)
+
+func TestHalfCloseTCP(t *testing.T) {
+	for name, timeouts := range map[string]Timeouts{
+		"default":  {},
+		"buffered": {Idle: time.Minute},
+	} {
+		t.Run(name, func(t *testing.T) {
+			relay, addr := startTestRelay(t, TargetLink("tcp://"+startCountServer(t)))
+			relay.SetTimeouts(timeouts)
+
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer conn.Close()
+
+			assertHalfClose(t, conn)
+		})
+	}
+}
+
+func TestHalfCloseUnix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not supported")
+	}
+
+	dir := t.TempDir()
+	client, relayClient := connPair(t, "unix", filepath.Join(dir, "client.sock"))
+	relayRemote, server := connPair(t, "unix", filepath.Join(dir, "server.sock"))
+
+	testHalfCloseStream(t, client, relayClient, relayRemote, server)
+}
+
+func TestHalfCloseTLS(t *testing.T) {
+	config := testTLSConfig(t)
+
+	client, relayClient := tlsPair(t, config)
+	relayRemote, server := tlsPair(t, config)
+
+	testHalfCloseStream(t, client, relayClient, relayRemote, server)
+}
+
+// testHalfCloseStream streams between the relay side conns and checks the
+// server's response arrives after the client has closed its write side
+func testHalfCloseStream(t *testing.T, client, relayClient, relayRemote, server net.Conn) {
+	relay, err := New("test-relay", io.Discard, "tcp://127.0.0.1:0", "tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go serveCount(server)
+	go streamConns(relay, relay.storeConn(relayClient), relayRemote)
+
+	assertHalfClose(t, client)
+}
+
+// assertHalfClose writes the payload, closes the write side and expects
+// the byte count to be returned
+func assertHalfClose(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	go func() {
+		conn.Write(halfClosePayload)
+		conn.(closeWriter).CloseWrite()
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(resp) != strconv.Itoa(len(halfClosePayload)) {
+		t.Fatalf("expected response %d got %q", len(halfClosePayload), resp)
+	}
+}
+
+// startCountServer replies with the amount of bytes received once the
+// client has closed its write side
+func startCountServer(t testing.TB) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveCount(conn)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func serveCount(conn net.Conn) {
+	defer conn.Close()
+
+	n, err := io.Copy(io.Discard, conn)
+	if err != nil {
+		return
+	}
+
+	conn.Write([]byte(strconv.Itoa(int(n))))
+}
+
+// connPair returns both ends of a connection
+func connPair(t *testing.T, network, addr string) (net.Conn, net.Conn) {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+
+	conn, err := net.Dial(network, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, <-accepted
+}
+
+// tlsPair returns both ends of a TLS connection after the handshake
+func tlsPair(t *testing.T, config *tls.Config) (net.Conn, net.Conn) {
+	a, b := connPair(t, "tcp", "127.0.0.1:0")
+
+	client := tls.Client(a, &tls.Config{InsecureSkipVerify: true})
+	server := tls.Server(b, config)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Handshake()
+	}()
+
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+
+	return client, server
+}
+
+func testTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localrelay"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+}
@@ -0,0 +1,442 @@
+package localrelay
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DestinationState controls if a destination is given new connections
+type DestinationState string
+
+const (
+	// DestinationActive destinations are dialled for new connections
+	DestinationActive DestinationState = "active"
+	// DestinationDisabled destinations get no new connections and their
+	// established connections are dropped
+	DestinationDisabled DestinationState = "disabled"
+	// DestinationDraining destinations get no new connections but their
+	// established connections are kept
+	DestinationDraining DestinationState = "draining"
+)
+
+var (
+	// ErrDestinationNotFound is returned when a relay does not have the destination
+	ErrDestinationNotFound = errors.New("destination not found")
+	// ErrDestinationExists is returned when adding a destination the relay already has
+	ErrDestinationExists = errors.New("destination already exists")
+	// ErrLastDestination is returned when a change would leave no active destinations
+	ErrLastDestination = errors.New("at least one destination must remain active")
+	// ErrProxyInUse is returned when removing a proxy a destination dials through
+	ErrProxyInUse = errors.New("proxy is used by a destination")
+	// ErrInvalidWeight is returned when a load balancer weight is zero
+	ErrInvalidWeight = errors.New("load balancer weight must be at least 1")
+)
+
+// DestinationStatus is a snapshot of a destination
+type DestinationStatus struct {
+	Destination TargetLink
+	State       DestinationState
+	// Conns is the amount of established connections to the destination
+	Conns int
+}
+
+// validateDestinations checks the destinations can be used together
+func validateDestinations(destinations []TargetLink) error {
+	if len(destinations) == 0 {
+		return ErrNoDestination
+	}
+
+	// if a http(s) proxy enforce one destination only policy
+	if t := destinations[0].ProxyType(); t == ProxyHTTP || t == ProxyHTTPS {
+		if len(destinations) > 1 {
+			return ErrManyDestinations
+		}
+	}
+
+	for _, d := range destinations {
+		first, last, err := d.PortRange()
+		if err != nil {
+			return err
+		}
+
+		// http(s) destinations are requested by URL so can not be a range
+		if t := d.ProxyType(); first != last && (t == ProxyHTTP || t == ProxyHTTPS) {
+			return errors.Wrapf(ErrInvalidPortRange, "%q", d)
+		}
+	}
+
+	return nil
+}
+
+// validateRanges ensures the destination ranges cover every listener range
+func (r *Relay) validateRanges(destinations []TargetLink) error {
+	listeners := r.Listeners
+	if len(listeners) == 0 {
+		listeners = []TargetLink{r.Listener}
+	}
+
+	for _, l := range listeners {
+		first, last, err := l.PortRange()
+		if err != nil {
+			return err
+		}
+
+		if first == last {
+			continue
+		}
+
+		if err := checkDestinationRanges(destinations, last-first+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetDestinations replaces the relay's destinations. Destinations can be
+// changed while the relay is running, established connections are kept
+// and new connections use the new destinations. Destinations which are
+// kept keep their state.
+func (r *Relay) SetDestinations(destinations ...TargetLink) error {
+	if err := validateDestinations(destinations); err != nil {
+		return err
+	}
+
+	if err := r.validateRanges(destinations); err != nil {
+		return err
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	states := make(map[TargetLink]DestinationState, len(r.destinationStates))
+	for _, d := range destinations {
+		if state, found := r.destinationStates[d]; found {
+			states[d] = state
+		}
+	}
+
+	r.Destination = destinations
+	r.destinationStates = states
+	r.setTags()
+
+	return nil
+}
+
+// Destinations returns the relay's destinations including those which
+// are disabled or draining
+func (r *Relay) Destinations() []TargetLink {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return append([]TargetLink(nil), r.Destination...)
+}
+
+// activeDestinations returns the destinations new connections may use
+func (r *Relay) activeDestinations() []TargetLink {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	destinations := make([]TargetLink, 0, len(r.Destination))
+	for _, d := range r.Destination {
+		if r.destinationState(d) == DestinationActive {
+			destinations = append(destinations, d)
+		}
+	}
+
+	return destinations
+}
+
+// destinationState returns the state of the destination, r.m must be held
+func (r *Relay) destinationState(d TargetLink) DestinationState {
+	if state, found := r.destinationStates[d]; found {
+		return state
+	}
+
+	return DestinationActive
+}
+
+// DestinationStatuses returns the state and established connections of
+// every destination
+func (r *Relay) DestinationStatuses() []DestinationStatus {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	conns := make(map[TargetLink]int, len(r.Destination))
+	for _, tc := range r.conns {
+		conns[tc.getTarget()]++
+	}
+
+	statuses := make([]DestinationStatus, len(r.Destination))
+	for i, d := range r.Destination {
+		statuses[i] = DestinationStatus{
+			Destination: d,
+			State:       r.destinationState(d),
+			Conns:       conns[d],
+		}
+	}
+
+	return statuses
+}
+
+// findDestination returns the index of the destination, r.m must be held.
+// A destination can be referenced without its query options if only one
+// destination has that address.
+func (r *Relay) findDestination(d TargetLink) (int, error) {
+	for i, destination := range r.Destination {
+		if destination == d {
+			return i, nil
+		}
+	}
+
+	found := -1
+	for i, destination := range r.Destination {
+		if base, _, _ := strings.Cut(string(destination), "?"); base != string(d) {
+			continue
+		}
+
+		if found != -1 {
+			return 0, errors.Wrapf(ErrDestinationNotFound, "%q matches more than one destination, include the options", d)
+		}
+
+		found = i
+	}
+
+	if found == -1 {
+		return 0, errors.Wrapf(ErrDestinationNotFound, "%q", d)
+	}
+
+	return found, nil
+}
+
+// lastActive returns true if the destination at index i is the only
+// active destination, r.m must be held
+func (r *Relay) lastActive(i int) bool {
+	if r.destinationState(r.Destination[i]) != DestinationActive {
+		return false
+	}
+
+	for j, d := range r.Destination {
+		if j != i && r.destinationState(d) == DestinationActive {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AddDestination adds a destination to a running relay
+func (r *Relay) AddDestination(d TargetLink) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, destination := range r.Destination {
+		if destination == d {
+			return errors.Wrapf(ErrDestinationExists, "%q", d)
+		}
+	}
+
+	destinations := append(append([]TargetLink(nil), r.Destination...), d)
+	if err := validateDestinations(destinations); err != nil {
+		return err
+	}
+
+	if err := r.validateRanges([]TargetLink{d}); err != nil {
+		return err
+	}
+
+	r.Destination = destinations
+	r.setTags()
+
+	return nil
+}
+
+// RemoveDestination removes a destination. Established connections to
+// it are kept until they close.
+func (r *Relay) RemoveDestination(d TargetLink) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	i, err := r.findDestination(d)
+	if err != nil {
+		return err
+	}
+
+	if r.lastActive(i) {
+		return ErrLastDestination
+	}
+
+	delete(r.destinationStates, r.Destination[i])
+
+	destinations := append([]TargetLink(nil), r.Destination[:i]...)
+	r.Destination = append(destinations, r.Destination[i+1:]...)
+	r.setTags()
+
+	return nil
+}
+
+// SetDestinationState enables, disables or drains a destination. Disabling
+// a destination drops its established connections.
+func (r *Relay) SetDestinationState(d TargetLink, state DestinationState) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	i, err := r.findDestination(d)
+	if err != nil {
+		return err
+	}
+
+	switch state {
+	case DestinationActive, DestinationDisabled, DestinationDraining:
+	default:
+		return errors.Wrapf(ErrInvalidOption, "destination state %q", state)
+	}
+
+	if state != DestinationActive && r.lastActive(i) {
+		return ErrLastDestination
+	}
+
+	if r.destinationStates == nil {
+		r.destinationStates = make(map[TargetLink]DestinationState)
+	}
+
+	d = r.Destination[i]
+	if state == DestinationActive {
+		delete(r.destinationStates, d)
+	} else {
+		r.destinationStates[d] = state
+	}
+
+	if state == DestinationDisabled {
+		for _, tc := range r.conns {
+			if tc.getTarget() == d {
+				go tc.drop()
+			}
+		}
+	}
+
+	return nil
+}
+
+// SetDestinationWeight sets the load balancer weight of a destination.
+// The destination's lb_weight option is replaced, established connections
+// to it are kept.
+func (r *Relay) SetDestinationWeight(d TargetLink, weight uint) error {
+	if weight == 0 {
+		return ErrInvalidWeight
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	i, err := r.findDestination(d)
+	if err != nil {
+		return err
+	}
+
+	old := r.Destination[i]
+	weighted := old.withOption("lb_weight", strconv.FormatUint(uint64(weight), 10))
+
+	if err := weighted.Validate(); err != nil {
+		return err
+	}
+
+	destinations := append([]TargetLink(nil), r.Destination...)
+	destinations[i] = weighted
+	r.Destination = destinations
+
+	if state, found := r.destinationStates[old]; found {
+		delete(r.destinationStates, old)
+		r.destinationStates[weighted] = state
+	}
+
+	// established connections follow the destination to its new link
+	for _, tc := range r.conns {
+		if tc.getTarget() == old {
+			tc.setTarget(weighted)
+		}
+	}
+
+	r.setTags()
+
+	return nil
+}
+
+// withOption returns the link with the query option set, other options
+// are kept in their order
+func (t TargetLink) withOption(key, value string) TargetLink {
+	base, query, _ := strings.Cut(string(t), "?")
+
+	options := make([]string, 0, strings.Count(query, "&")+2)
+	for _, option := range strings.Split(query, "&") {
+		if option != "" && !strings.HasPrefix(option, key+"=") {
+			options = append(options, option)
+		}
+	}
+
+	options = append(options, key+"="+value)
+	return TargetLink(base + "?" + strings.Join(options, "&"))
+}
+
+// SetProxyURL adds or replaces a single proxy. Established connections
+// keep using the proxy they were dialled through.
+func (r *Relay) SetProxyURL(name string, proxy ProxyURL) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	proxies := make(map[string]ProxyURL, len(r.proxies)+1)
+	for n, p := range r.proxies {
+		proxies[n] = p
+	}
+
+	proxies[name] = proxy
+	r.proxies = proxies
+	r.ProxyEnabled = true
+}
+
+// RemoveProxy removes a proxy which no destination dials through
+func (r *Relay) RemoveProxy(name string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if _, found := r.proxies[name]; !found {
+		return errors.Wrapf(ErrProxyDefine, "%q", name)
+	}
+
+	for _, d := range r.Destination {
+		if contains(d.parsed().Proxies, name) {
+			return errors.Wrapf(ErrProxyInUse, "%q by %q", name, d)
+		}
+	}
+
+	proxies := make(map[string]ProxyURL, len(r.proxies))
+	for n, p := range r.proxies {
+		if n != name {
+			proxies[n] = p
+		}
+	}
+
+	r.proxies = proxies
+	r.ProxyEnabled = len(proxies) > 0
+
+	return nil
+}
+
+// Proxies returns a copy of the relay's proxies
+func (r *Relay) Proxies() map[string]ProxyURL {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	proxies := make(map[string]ProxyURL, len(r.proxies))
+	for n, p := range r.proxies {
+		proxies[n] = p
+	}
+
+	return proxies
+}
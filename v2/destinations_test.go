@@ -0,0 +1,346 @@
+package localrelay
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestSetDestinationsWhileRunning(t *testing.T) {
+	listen := freePortRange(t, 1)
+	addr := "127.0.0.1:" + strconv.Itoa(listen)
+
+	relay, err := New("test-relay", io.Discard, TargetLink("tcp://"+addr), TargetLink("tcp://"+startEchoServer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go relay.ListenServe()
+	t.Cleanup(func() { relay.Close() })
+
+	var established net.Conn
+	waitFor(t, func() bool {
+		established, err = net.Dial("tcp", addr)
+		return err == nil
+	})
+
+	defer established.Close()
+
+	// once a reply is read the connection has been dialled to the echo server
+	established.SetDeadline(time.Now().Add(time.Second * 2))
+	buf := make([]byte, 4)
+	established.Write([]byte("ping"))
+	if _, err := io.ReadFull(established, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// the new destination replies with a marker and hangs up
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			conn.Write([]byte("new"))
+			conn.Close()
+		}
+	}()
+
+	if err := relay.SetDestinations(TargetLink("tcp://" + l.Addr().String())); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetDeadline(time.Now().Add(time.Second * 2))
+	b, _ := io.ReadAll(conn)
+	conn.Close()
+
+	if string(b) != "new" {
+		t.Fatalf("expected new connections to use the new destination got %q", b)
+	}
+
+	// the established connection still reaches the echo server
+	established.Write([]byte("ping"))
+	if _, err := io.ReadFull(established, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("established connection was interrupted: %q %v", buf, err)
+	}
+}
+
+func TestSetDestinationsErrors(t *testing.T) {
+	relay, err := New("test-relay", io.Discard, "tcp://127.0.0.1:9000-9002", "tcp://127.0.0.1:8000-8002")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := relay.SetDestinations(); !errors.Is(err, ErrNoDestination) {
+		t.Fatalf("expected ErrNoDestination got %v", err)
+	}
+
+	if err := relay.SetDestinations("tcp://127.0.0.1:8000-8001"); !errors.Is(err, ErrRangeTooSmall) {
+		t.Fatalf("expected ErrRangeTooSmall got %v", err)
+	}
+
+	if err := relay.SetDestinations("tcp://127.0.0.1:7000-7002", "tcp://127.0.0.1:6000-6002"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !relay.Failover() || relay.Loadbalancer() {
+		t.Fatal("expected a failover relay")
+	}
+
+	relay.SetLoadbalance(true)
+	if relay.Failover() || !relay.Loadbalancer() {
+		t.Fatal("expected a load balancer")
+	}
+
+	relay.SetLoadbalance(false)
+	if !relay.Failover() || relay.Loadbalancer() {
+		t.Fatal("expected disabling load balancing to restore failover")
+	}
+}
+
+// startMarkerServer replies to each connection with the marker then hangs up
+func startMarkerServer(t *testing.T, marker string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			conn.Write([]byte(marker))
+			conn.Close()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestDestinationStates(t *testing.T) {
+	addr := "127.0.0.1:" + strconv.Itoa(freePortRange(t, 1))
+	echo := TargetLink("tcp://" + startEchoServer(t))
+	marker := TargetLink("tcp://" + startMarkerServer(t, "b"))
+
+	relay, err := New("test-relay", io.Discard, TargetLink("tcp://"+addr), echo, marker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go relay.ListenServe()
+	t.Cleanup(func() { relay.Close() })
+
+	var established net.Conn
+	waitFor(t, func() bool {
+		established, err = net.Dial("tcp", addr)
+		return err == nil
+	})
+
+	defer established.Close()
+
+	established.SetDeadline(time.Now().Add(time.Second * 2))
+	buf := make([]byte, 4)
+	established.Write([]byte("ping"))
+	if _, err := io.ReadFull(established, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := relay.SetDestinationState(echo, DestinationDraining); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := relay.SetDestinationState(marker, DestinationDisabled); !errors.Is(err, ErrLastDestination) {
+		t.Fatalf("expected ErrLastDestination got %v", err)
+	}
+
+	statuses := relay.DestinationStatuses()
+	if statuses[0].State != DestinationDraining || statuses[0].Conns != 1 || statuses[1].State != DestinationActive {
+		t.Fatalf("unexpected statuses %+v", statuses)
+	}
+
+	// new connections skip the draining destination
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetDeadline(time.Now().Add(time.Second * 2))
+	b, _ := io.ReadAll(conn)
+	conn.Close()
+
+	if string(b) != "b" {
+		t.Fatalf("expected the active destination got %q", b)
+	}
+
+	established.Write([]byte("ping"))
+	if _, err := io.ReadFull(established, buf); err != nil {
+		t.Fatalf("draining dropped the established connection: %v", err)
+	}
+
+	// disabling drops the established connection
+	if err := relay.SetDestinationState(echo, DestinationDisabled); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.ReadFull(established, buf); err == nil {
+		t.Fatal("expected disabling to drop the established connection")
+	}
+}
+
+func TestDestinationMutation(t *testing.T) {
+	relay, err := New("test-relay", io.Discard, "tcp://127.0.0.1:9000", "tcp://10.0.0.1:443?proxy=tor")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := relay.AddDestination("tcp://10.0.0.2:443"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := relay.AddDestination("tcp://10.0.0.2:443"); !errors.Is(err, ErrDestinationExists) {
+		t.Fatalf("expected ErrDestinationExists got %v", err)
+	}
+
+	if err := relay.AddDestination("tcp://10.0.0.3:443?unknown=1"); !errors.Is(err, ErrUnknownOption) {
+		t.Fatalf("expected ErrUnknownOption got %v", err)
+	}
+
+	// destinations can be referenced without their options
+	if err := relay.SetDestinationWeight("tcp://10.0.0.1:443", 20); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := relay.Destinations()[0]; d != "tcp://10.0.0.1:443?proxy=tor&lb_weight=20" || d.LbWeight() != 20 {
+		t.Fatalf("unexpected weighted destination %q", d)
+	}
+
+	if err := relay.SetDestinationWeight("tcp://10.0.0.1:443", 0); !errors.Is(err, ErrInvalidWeight) {
+		t.Fatalf("expected ErrInvalidWeight got %v", err)
+	}
+
+	relay.SetProxyURL("tor", NewProxyURL(&url.URL{Scheme: "socks5", Host: "127.0.0.1:9050"}))
+	if err := relay.RemoveProxy("tor"); !errors.Is(err, ErrProxyInUse) {
+		t.Fatalf("expected ErrProxyInUse got %v", err)
+	}
+
+	if err := relay.RemoveDestination("tcp://10.0.0.1:443"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := relay.RemoveProxy("tor"); err != nil || relay.ProxyEnabled {
+		t.Fatalf("expected the proxy to be removed: %v", err)
+	}
+
+	if err := relay.RemoveDestination("tcp://10.0.0.1:443"); !errors.Is(err, ErrDestinationNotFound) {
+		t.Fatalf("expected ErrDestinationNotFound got %v", err)
+	}
+
+	if err := relay.RemoveDestination("tcp://10.0.0.2:443"); !errors.Is(err, ErrLastDestination) {
+		t.Fatalf("expected ErrLastDestination got %v", err)
+	}
+}
+
+func TestSnapshotWhileMutating(t *testing.T) {
+	relay, err := New("test-relay", io.Discard, "tcp://127.0.0.1:9000", "tcp://10.0.0.1:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 100; i++ {
+			relay.AddDestination("tcp://10.0.0.2:443")
+			relay.SetDestinationWeight("tcp://10.0.0.2:443", uint(i+1))
+			relay.RemoveDestination("tcp://10.0.0.2:443")
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		snapshot := relay.Snapshot()
+		if len(snapshot.Destination) == 0 || snapshot.Destination[0] != "tcp://10.0.0.1:443" {
+			t.Fatalf("unexpected snapshot destinations %v", snapshot.Destination)
+		}
+
+		snapshot.Failover()
+	}
+
+	<-done
+}
+
+func TestReweightThenDisable(t *testing.T) {
+	addr := "127.0.0.1:" + strconv.Itoa(freePortRange(t, 1))
+	echo := TargetLink("tcp://" + startEchoServer(t))
+	marker := TargetLink("tcp://" + startMarkerServer(t, "b"))
+
+	relay, err := New("test-relay", io.Discard, TargetLink("tcp://"+addr), echo, marker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go relay.ListenServe()
+	t.Cleanup(func() { relay.Close() })
+
+	established := dialRelay(t, addr)
+	defer established.Close()
+
+	if err := relay.SetDestinationWeight(echo, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	if statuses := relay.DestinationStatuses(); statuses[0].Conns != 1 {
+		t.Fatalf("expected the reweighted destination to keep its connection %+v", statuses)
+	}
+
+	// the weighted link is matched by the address without its options
+	if err := relay.SetDestinationState(echo, DestinationDisabled); err != nil {
+		t.Fatal(err)
+	}
+
+	established.SetDeadline(time.Now().Add(time.Second))
+
+	var netErr net.Error
+	if _, err := io.ReadFull(established, make([]byte, 4)); err == nil || (errors.As(err, &netErr) && netErr.Timeout()) {
+		t.Fatalf("expected disabling to drop the established connection got %v", err)
+	}
+}
+
+func TestTargetCacheBounded(t *testing.T) {
+	// destinations reweighted over IPC each parse a new link
+	for i := 0; i < maxCachedTargets*2; i++ {
+		ParseTargetLink("tcp://10.0.0.1:443?lb_weight=" + strconv.Itoa(i+1))
+	}
+
+	if n := targetCache.len(); n > maxCachedTargets {
+		t.Fatalf("expected at most %d cached targets got %d", maxCachedTargets, n)
+	}
+
+	// recently used links are kept
+	if _, found := targetCache.load("tcp://10.0.0.1:443?lb_weight=" + strconv.Itoa(maxCachedTargets*2)); !found {
+		t.Fatal("expected the most recent target to be cached")
+	}
+}
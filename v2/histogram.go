@@ -0,0 +1,134 @@
+package localrelay
+
+import (
+	"sync"
+	"time"
+)
+
+// histogramBounds are the upper bounds of each histogram bucket.
+// Observations above the last bound are placed in an overflow bucket.
+var histogramBounds = [...]time.Duration{
+	time.Millisecond,
+	time.Millisecond * 5,
+	time.Millisecond * 10,
+	time.Millisecond * 25,
+	time.Millisecond * 50,
+	time.Millisecond * 100,
+	time.Millisecond * 250,
+	time.Millisecond * 500,
+	time.Second,
+	time.Second * 2,
+	time.Second * 5,
+	time.Second * 10,
+	time.Second * 30,
+	time.Minute,
+	time.Minute * 5,
+	time.Minute * 15,
+	time.Hour,
+}
+
+// Histogram records the distribution of durations such as dial latency.
+// The zero value is ready to use.
+type Histogram struct {
+	m      sync.Mutex
+	counts [len(histogramBounds) + 1]uint64
+	count  uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// HistogramBucket is the amount of observations less than or equal to
+// UpperBound. An UpperBound of zero is used for the overflow bucket.
+type HistogramBucket struct {
+	UpperBound time.Duration
+	// Count is cumulative and includes all smaller buckets
+	Count uint64
+}
+
+// HistogramSnapshot is a copy of a histogram's state
+type HistogramSnapshot struct {
+	Count uint64
+	Sum   time.Duration
+	Max   time.Duration
+
+	// Buckets are sorted by UpperBound with the overflow bucket last
+	Buckets []HistogramBucket
+
+	P50, P90, P99 time.Duration
+}
+
+// Observe records a duration
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	for i < len(histogramBounds) && d > histogramBounds[i] {
+		i++
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.counts[i]++
+	h.count++
+	h.sum += d
+
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Snapshot returns a copy of the histogram including its percentiles
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	s := HistogramSnapshot{
+		Count:   h.count,
+		Sum:     h.sum,
+		Max:     h.max,
+		Buckets: make([]HistogramBucket, len(h.counts)),
+	}
+
+	total := uint64(0)
+	for i, n := range h.counts {
+		total += n
+
+		s.Buckets[i].Count = total
+		if i < len(histogramBounds) {
+			s.Buckets[i].UpperBound = histogramBounds[i]
+		}
+	}
+
+	s.P50 = s.Quantile(0.5)
+	s.P90 = s.Quantile(0.9)
+	s.P99 = s.Quantile(0.99)
+
+	return s
+}
+
+// Quantile estimates the duration below which q (0-1) of the observations
+// fall by interpolating within the matching bucket
+func (s HistogramSnapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+
+	rank := q * float64(s.Count)
+
+	lower, prev := time.Duration(0), uint64(0)
+	for _, b := range s.Buckets {
+		upper := b.UpperBound
+		if upper == 0 || upper > s.Max {
+			// the largest observation bounds the final buckets
+			upper = s.Max
+		}
+
+		if float64(b.Count) >= rank && b.Count > prev {
+			fraction := (rank - float64(prev)) / float64(b.Count-prev)
+			return lower + time.Duration(fraction*float64(upper-lower))
+		}
+
+		lower, prev = upper, b.Count
+	}
+
+	return s.Max
+}
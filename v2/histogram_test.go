@@ -0,0 +1,54 @@
+package localrelay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramEmpty(t *testing.T) {
+	var h Histogram
+
+	s := h.Snapshot()
+	if s.Count != 0 || s.P50 != 0 || s.P99 != 0 {
+		t.Fatalf("empty histogram should report zeros, got %+v", s)
+	}
+}
+
+func TestHistogramQuantiles(t *testing.T) {
+	var h Histogram
+
+	// 90 fast observations and 10 slow
+	for i := 0; i < 90; i++ {
+		h.Observe(time.Millisecond * 3)
+	}
+
+	for i := 0; i < 10; i++ {
+		h.Observe(time.Second * 3)
+	}
+
+	s := h.Snapshot()
+	if s.Count != 100 {
+		t.Fatalf("expected 100 observations got %d", s.Count)
+	}
+
+	if s.P50 <= time.Millisecond || s.P50 > time.Millisecond*5 {
+		t.Fatalf("p50 should be within the 1-5ms bucket, got %s", s.P50)
+	}
+
+	if s.P99 <= time.Second*2 || s.P99 > time.Second*3 {
+		t.Fatalf("p99 should be within 2-3s, got %s", s.P99)
+	}
+
+	if last := s.Buckets[len(s.Buckets)-1]; last.UpperBound != 0 || last.Count != 100 {
+		t.Fatalf("overflow bucket should be cumulative, got %+v", last)
+	}
+}
+
+func TestHistogramOverflow(t *testing.T) {
+	var h Histogram
+	h.Observe(time.Hour * 3)
+
+	if s := h.Snapshot(); s.P99 > time.Hour*3 || s.P99 <= time.Hour {
+		t.Fatalf("overflow quantile should be bounded by the max, got %s", s.P99)
+	}
+}
@@ -0,0 +1,163 @@
+package localrelay
+
+import (
+	"net"
+	"time"
+)
+
+// Hooks are callbacks invoked as a relay runs, allowing library users to
+// observe connections without parsing logs. Hooks are called synchronously
+// on the connection's goroutine so must not block. Nil hooks are ignored.
+//
+// Connection hooks are only called for TCP and UDP relays.
+type Hooks struct {
+	// OnStart is called once the relay is listening
+	OnStart func(r *Relay)
+	// OnStop is called once the relay has stopped, err is nil when the
+	// relay was closed
+	OnStop func(r *Relay, err error)
+
+	// OnAccept is called for every connection permitted by the ACL
+	OnAccept func(r *Relay, e ConnEvent)
+	// OnDestination is called when a destination has been selected
+	OnDestination func(r *Relay, e DestinationEvent)
+
+	// OnDialAttempt is called before dialling a destination
+	OnDialAttempt func(r *Relay, e DialEvent)
+	// OnDialSuccess is called once a destination has been dialled
+	OnDialSuccess func(r *Relay, e DialEvent)
+	// OnDialFail is called when a destination could not be dialled
+	OnDialFail func(r *Relay, e DialEvent)
+
+	// OnStreamEnd is called once both directions of a stream have closed
+	OnStreamEnd func(r *Relay, e StreamEvent)
+}
+
+// ConnEvent describes an accepted connection
+type ConnEvent struct {
+	ConnID     uint64
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+}
+
+// DestinationEvent describes the destination selected for a connection
+type DestinationEvent struct {
+	ConnID      uint64
+	Destination TargetLink
+	// Attempt starts at 1 and increases each time a destination fails
+	Attempt int
+}
+
+// DialEvent describes an attempt to dial a destination
+type DialEvent struct {
+	ConnID      uint64
+	Destination TargetLink
+	// Proxy is the name of the proxy used, empty when dialled directly
+	Proxy string
+
+	// Latency and Err are only set once the dial has finished
+	Latency time.Duration
+	Err     error
+}
+
+// StreamEvent describes a finished stream
+type StreamEvent struct {
+	ConnID      uint64
+	RemoteAddr  net.Addr
+	Destination TargetLink
+	Proxy       string
+
+	// BytesIn is received from the destination, BytesOut is sent to it
+	BytesIn, BytesOut int64
+	// Duration is how long the connection was open
+	Duration time.Duration
+	Reason   CloseReason
+	Err      error
+}
+
+// SetHooks replaces the relay's lifecycle hooks
+func (r *Relay) SetHooks(h Hooks) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.hooks = h
+}
+
+// Hooks returns the relay's lifecycle hooks
+func (r *Relay) Hooks() Hooks {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.hooks
+}
+
+func (h Hooks) start(r *Relay) {
+	if h.OnStart != nil {
+		h.OnStart(r)
+	}
+}
+
+func (h Hooks) stop(r *Relay, err error) {
+	if h.OnStop != nil {
+		h.OnStop(r, err)
+	}
+}
+
+func (h Hooks) accept(r *Relay, tc *trackedConn) {
+	if h.OnAccept != nil {
+		h.OnAccept(r, ConnEvent{
+			ConnID:     tc.id,
+			RemoteAddr: tc.conn.RemoteAddr(),
+			LocalAddr:  tc.conn.LocalAddr(),
+		})
+	}
+}
+
+func (h Hooks) destination(r *Relay, e DestinationEvent) {
+	if h.OnDestination != nil {
+		h.OnDestination(r, e)
+	}
+}
+
+func (h Hooks) dialAttempt(r *Relay, e DialEvent) {
+	if h.OnDialAttempt != nil {
+		h.OnDialAttempt(r, e)
+	}
+}
+
+// dialed calls OnDialSuccess or OnDialFail depending on err
+func (h Hooks) dialed(r *Relay, e DialEvent, start time.Time, err error) {
+	e.Latency = time.Since(start)
+	e.Err = err
+
+	if err != nil {
+		if h.OnDialFail != nil {
+			h.OnDialFail(r, e)
+		}
+
+		return
+	}
+
+	if h.OnDialSuccess != nil {
+		h.OnDialSuccess(r, e)
+	}
+}
+
+func (h Hooks) streamEnd(r *Relay, tc *trackedConn, err error) {
+	if h.OnStreamEnd == nil {
+		return
+	}
+
+	pc := tc.snapshot()
+	h.OnStreamEnd(r, StreamEvent{
+		ConnID:      pc.ID,
+		RemoteAddr:  pc.Conn.RemoteAddr(),
+		Destination: pc.Destination,
+		Proxy:       pc.Proxy,
+		BytesIn:     pc.BytesIn,
+		BytesOut:    pc.BytesOut,
+		Duration:    time.Since(pc.Opened),
+		Reason:      pc.Reason,
+		Err:         err,
+	})
+}
@@ -0,0 +1,107 @@
+package localrelay
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first destination refuses connections causing a failover
+	refused, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refused.Close()
+
+	relay, err := New("test-relay", io.Discard, TargetLink("tcp://"+l.Addr().String()),
+		TargetLink("tcp://"+refused.Addr().String()), TargetLink("tcp://"+startEchoServer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		m      sync.Mutex
+		events []string
+		stream StreamEvent
+	)
+
+	record := func(event string) {
+		m.Lock()
+		defer m.Unlock()
+
+		events = append(events, event)
+	}
+
+	stopped := make(chan error, 1)
+	relay.SetHooks(Hooks{
+		OnStart:       func(r *Relay) { record("start") },
+		OnStop:        func(r *Relay, err error) { stopped <- err },
+		OnAccept:      func(r *Relay, e ConnEvent) { record("accept") },
+		OnDestination: func(r *Relay, e DestinationEvent) { record("destination") },
+		OnDialAttempt: func(r *Relay, e DialEvent) { record("attempt") },
+		OnDialSuccess: func(r *Relay, e DialEvent) { record("success") },
+		OnDialFail:    func(r *Relay, e DialEvent) { record("fail") },
+		OnStreamEnd: func(r *Relay, e StreamEvent) {
+			m.Lock()
+			stream = e
+			m.Unlock()
+
+			record("end")
+		},
+	})
+
+	go relay.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Close()
+
+	waitFor(t, func() bool {
+		m.Lock()
+		defer m.Unlock()
+
+		return len(events) > 0 && events[len(events)-1] == "end"
+	})
+
+	relay.Close()
+	if err := <-stopped; err != nil {
+		t.Fatalf("expected a clean stop, got %s", err)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	expected := []string{"start", "accept", "destination", "attempt", "fail", "destination", "attempt", "success", "end"}
+	if len(events) != len(expected) {
+		t.Fatalf("expected events %v got %v", expected, events)
+	}
+
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatalf("expected events %v got %v", expected, events)
+		}
+	}
+
+	if stream.BytesIn != 4 || stream.BytesOut != 4 || stream.Reason != CloseEOF {
+		t.Fatalf("unexpected stream event %+v", stream)
+	}
+}
@@ -0,0 +1,255 @@
+package localrelay
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Offense is a type of abusive behaviour which can be reported to a Jail
+type Offense string
+
+const (
+	// OffenseRejected is reported when a client is denied by the ACL
+	OffenseRejected Offense = "rejected"
+	// OffenseAuth is reported when a HTTP(S) relay's destination responds
+	// 401 Unauthorized to a client
+	OffenseAuth Offense = "auth"
+	// OffenseRateLimit is reported when a client's connection is rejected
+	// for exceeding the relay's connection rate limit
+	OffenseRateLimit Offense = "ratelimit"
+)
+
+// JailConfig sets when and for how long clients are banned
+type JailConfig struct {
+	// Thresholds is the amount of offenses allowed within Window before
+	// the client is banned. Offenses without a threshold never cause a ban.
+	Thresholds map[Offense]int
+	// Window is the period offenses are counted over
+	Window time.Duration
+	// Duration is how long a client stays banned
+	Duration time.Duration
+}
+
+// Ban is a temporarily banned client
+type Ban struct {
+	IP      string
+	Reason  Offense
+	Created time.Time
+	Expires time.Time
+}
+
+// Jail temporarily bans clients which repeatedly offend
+type Jail struct {
+	m      sync.Mutex
+	config JailConfig
+
+	// strikes holds the time of each recent offense per client
+	strikes   map[string]map[Offense][]time.Time
+	lastSweep time.Time
+
+	bans     map[string]Ban
+	onChange func()
+}
+
+// NewJail creates a jail with the provided thresholds
+func NewJail(config JailConfig) *Jail {
+	return &Jail{
+		config:    config,
+		strikes:   make(map[string]map[Offense][]time.Time),
+		lastSweep: time.Now(),
+		bans:      make(map[string]Ban),
+	}
+}
+
+// OnChange sets a function called whenever a ban is added or removed.
+// It is called without the jail's lock held.
+func (j *Jail) OnChange(fn func()) {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	j.onChange = fn
+}
+
+// Report records an offense for the ip and bans it once the threshold
+// has been reached. Returns true if the ip was banned by this report.
+func (j *Jail) Report(ip string, offense Offense) bool {
+	j.m.Lock()
+
+	threshold, ok := j.config.Thresholds[offense]
+	if !ok || threshold <= 0 {
+		j.m.Unlock()
+		return false
+	}
+
+	now := time.Now()
+	j.sweep(now)
+
+	if _, banned := j.bans[ip]; banned {
+		j.m.Unlock()
+		return false
+	}
+
+	offenses, ok := j.strikes[ip]
+	if !ok {
+		offenses = make(map[Offense][]time.Time)
+		j.strikes[ip] = offenses
+	}
+
+	strikes := append(recentStrikes(offenses[offense], now.Add(-j.config.Window)), now)
+	offenses[offense] = strikes
+
+	if len(strikes) < threshold {
+		j.m.Unlock()
+		return false
+	}
+
+	delete(j.strikes, ip)
+	j.bans[ip] = Ban{
+		IP:      ip,
+		Reason:  offense,
+		Created: now,
+		Expires: now.Add(j.config.Duration),
+	}
+
+	j.m.Unlock()
+	j.changed()
+
+	return true
+}
+
+// Ban bans the ip for the duration
+func (j *Jail) Ban(ip string, reason Offense, duration time.Duration) {
+	now := time.Now()
+
+	j.m.Lock()
+	j.bans[ip] = Ban{
+		IP:      ip,
+		Reason:  reason,
+		Created: now,
+		Expires: now.Add(duration),
+	}
+	j.m.Unlock()
+
+	j.changed()
+}
+
+// Unban removes a ban, returns false if the ip was not banned
+func (j *Jail) Unban(ip string) bool {
+	j.m.Lock()
+	_, found := j.bans[ip]
+	delete(j.bans, ip)
+	delete(j.strikes, ip)
+	j.m.Unlock()
+
+	if found {
+		j.changed()
+	}
+
+	return found
+}
+
+// Banned returns true if the ip has an active ban
+func (j *Jail) Banned(ip string) bool {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	ban, found := j.bans[ip]
+	if !found {
+		return false
+	}
+
+	if time.Now().After(ban.Expires) {
+		delete(j.bans, ip)
+		return false
+	}
+
+	return true
+}
+
+// Bans returns all active bans sorted by expiry
+func (j *Jail) Bans() []Ban {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	now := time.Now()
+	bans := make([]Ban, 0, len(j.bans))
+	for _, ban := range j.bans {
+		if now.After(ban.Expires) {
+			continue
+		}
+
+		bans = append(bans, ban)
+	}
+
+	sort.Slice(bans, func(i, k int) bool {
+		return bans[i].Expires.Before(bans[k].Expires)
+	})
+
+	return bans
+}
+
+// Restore adds previously saved bans, expired bans are ignored
+func (j *Jail) Restore(bans []Ban) {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	now := time.Now()
+	for _, ban := range bans {
+		if now.After(ban.Expires) {
+			continue
+		}
+
+		j.bans[ban.IP] = ban
+	}
+}
+
+func (j *Jail) changed() {
+	j.m.Lock()
+	fn := j.onChange
+	j.m.Unlock()
+
+	if fn != nil {
+		fn()
+	}
+}
+
+// sweep removes expired bans and strikes, at most once per window
+func (j *Jail) sweep(now time.Time) {
+	if now.Sub(j.lastSweep) < j.config.Window {
+		return
+	}
+
+	j.lastSweep = now
+
+	for ip, ban := range j.bans {
+		if now.After(ban.Expires) {
+			delete(j.bans, ip)
+		}
+	}
+
+	for ip, offenses := range j.strikes {
+		for offense, strikes := range offenses {
+			if strikes = recentStrikes(strikes, now.Add(-j.config.Window)); len(strikes) == 0 {
+				delete(offenses, offense)
+			} else {
+				offenses[offense] = strikes
+			}
+		}
+
+		if len(offenses) == 0 {
+			delete(j.strikes, ip)
+		}
+	}
+}
+
+// recentStrikes removes all strikes before the cutoff
+func recentStrikes(strikes []time.Time, cutoff time.Time) []time.Time {
+	for i, t := range strikes {
+		if t.After(cutoff) {
+			return strikes[i:]
+		}
+	}
+
+	return strikes[:0]
+}
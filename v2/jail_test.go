@@ -0,0 +1,150 @@
+package localrelay
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestJailThreshold(t *testing.T) {
+	j := NewJail(JailConfig{
+		Thresholds: map[Offense]int{OffenseRejected: 3},
+		Window:     time.Minute,
+		Duration:   time.Hour,
+	})
+
+	changes := 0
+	j.OnChange(func() { changes++ })
+
+	for i := 0; i < 2; i++ {
+		if j.Report("10.0.0.1", OffenseRejected) {
+			t.Fatal("banned before reaching the threshold")
+		}
+	}
+
+	if j.Report("10.0.0.1", OffenseAuth) {
+		t.Fatal("offense without a threshold must not ban")
+	}
+
+	if !j.Report("10.0.0.1", OffenseRejected) {
+		t.Fatal("expected ban once threshold was reached")
+	}
+
+	if !j.Banned("10.0.0.1") || j.Banned("10.0.0.2") {
+		t.Fatal("unexpected ban state")
+	}
+
+	if !j.Unban("10.0.0.1") || j.Banned("10.0.0.1") {
+		t.Fatal("unban failed")
+	}
+
+	if changes != 2 {
+		t.Fatalf("expected 2 change notifications, got %d", changes)
+	}
+}
+
+func TestJailRestoreExpired(t *testing.T) {
+	j := NewJail(JailConfig{})
+
+	j.Restore([]Ban{
+		{IP: "10.0.0.1", Expires: time.Now().Add(-time.Minute)},
+		{IP: "10.0.0.2", Expires: time.Now().Add(time.Minute)},
+	})
+
+	if bans := j.Bans(); len(bans) != 1 || bans[0].IP != "10.0.0.2" {
+		t.Fatalf("unexpected bans: %v", bans)
+	}
+}
+
+func TestReportRateLimit(t *testing.T) {
+	addr := "127.0.0.1:" + strconv.Itoa(freePortRange(t, 1))
+
+	relay, err := New("test-relay", io.Discard, TargetLink("tcp://"+addr), TargetLink("tcp://"+startEchoServer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jail := NewJail(JailConfig{Thresholds: map[Offense]int{OffenseRateLimit: 1}, Window: time.Minute, Duration: time.Hour})
+	relay.SetJail(jail)
+	relay.SetLimits(Limits{Conn: Limit{Upload: 1000}})
+
+	go relay.ListenServe()
+	t.Cleanup(func() { relay.Close() })
+
+	// being delayed by a bandwidth limit is not an offense
+	conn := dialRelay(t, addr)
+	go io.Copy(io.Discard, conn)
+	conn.Write(make([]byte, 2000))
+
+	waitFor(t, func() bool { return relay.Metrics.Throttled() > 0 })
+	conn.Close()
+
+	if jail.Banned("127.0.0.1") {
+		t.Fatal("bandwidth throttling must not ban the client")
+	}
+
+	// opening connections faster than the rate is
+	relay.SetLimits(Limits{ConnRate: 2})
+
+	for i := 0; i < 3; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+		}
+	}
+
+	waitFor(t, func() bool { return jail.Banned("127.0.0.1") })
+}
+
+func TestReportAuth(t *testing.T) {
+	destination := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(destination.Close)
+
+	addr := "127.0.0.1:" + strconv.Itoa(freePortRange(t, 1))
+
+	relay, err := New("test-relay", io.Discard, TargetLink("http://"+addr), TargetLink(destination.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := relay.SetHTTP(&http.Server{Handler: HandleHTTP(relay)}); err != nil {
+		t.Fatal(err)
+	}
+
+	jail := NewJail(JailConfig{Thresholds: map[Offense]int{OffenseAuth: 2}, Window: time.Minute, Duration: time.Hour})
+	relay.SetJail(jail)
+
+	go relay.ListenServe()
+	t.Cleanup(func() { relay.Close() })
+
+	waitFor(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+
+		return err == nil
+	})
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get("http://" + addr + "/login")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401 got %d", resp.StatusCode)
+		}
+	}
+
+	if !jail.Banned("127.0.0.1") {
+		t.Fatal("expected repeated failed logins to ban the client")
+	}
+}
@@ -0,0 +1,212 @@
+package localrelay
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidPortRange is returned when a port or port range can not be parsed
+	ErrInvalidPortRange = errors.New("invalid port range")
+	// ErrListenerType is returned when a relay's listeners use different protocols
+	ErrListenerType = errors.New("all listeners must use the same protocol")
+	// ErrRangeTooSmall is returned when a destination port range has fewer
+	// ports than a listener range it is mapped from
+	ErrRangeTooSmall = errors.New("destination port range is smaller than the listener range")
+	// ErrNoListener is returned when setting an empty list of listeners
+	ErrNoListener = errors.New("at least one listener must be set")
+)
+
+// SetListeners replaces the addresses the relay listens on, allowing a
+// relay to be reachable on IPv4 and IPv6 or on multiple ports. The first
+// listener becomes r.Listener. Every listener shares the relay's metrics,
+// ACL and connections.
+//
+// A listener may use a port range such as tcp://0.0.0.0:9000-9010. A
+// connection accepted on the Nth port of a range is sent to the Nth port
+// of destinations which also use a range, or to the port of destinations
+// which do not.
+//
+// Must be called before the relay is started.
+func (r *Relay) SetListeners(listeners ...TargetLink) error {
+	if len(listeners) == 0 {
+		return ErrNoListener
+	}
+
+	for _, l := range listeners {
+		if l.ProxyType() != listeners[0].ProxyType() {
+			return errors.Wrapf(ErrListenerType, "%q", l)
+		}
+
+		if _, _, err := l.PortRange(); err != nil {
+			return err
+		}
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.Listener = listeners[0]
+	r.Listeners = listeners
+	return nil
+}
+
+// PortRange returns the first and last port of the target. For a single
+// port first and last are equal, both are 0 if the target has no port.
+func (t *TargetLink) PortRange() (first, last int, err error) {
+	target, err := ParseTargetLink(string(*t))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return target.FirstPort, target.LastPort, nil
+}
+
+// splitPortRange parses the port or port range of the target
+func (t *TargetLink) splitPortRange() (first, last int, err error) {
+	_, ports, _ := t.splitPorts()
+	if ports == "" {
+		return 0, 0, nil
+	}
+
+	start, end, isRange := strings.Cut(ports, "-")
+
+	first, err = strconv.Atoi(start)
+	if err != nil || first < 0 || first > 65535 {
+		return 0, 0, errors.Wrapf(ErrInvalidPortRange, "%q", ports)
+	}
+
+	if !isRange {
+		return first, first, nil
+	}
+
+	// port 0 picks any free port so can not start a range
+	last, err = strconv.Atoi(end)
+	if err != nil || first == 0 || last < first || last > 65535 {
+		return 0, 0, errors.Wrapf(ErrInvalidPortRange, "%q", ports)
+	}
+
+	return first, last, nil
+}
+
+// splitPorts splits the target into the text before and after its port
+// or port range. ports is empty when the target has no port.
+func (t *TargetLink) splitPorts() (prefix, ports, suffix string) {
+	link := string(*t)
+	if t.ProxyType() == ProxyUnix {
+		return link, "", ""
+	}
+
+	hostStart := 0
+	if i := strings.Index(link, "://"); i != -1 {
+		hostStart = i + 3
+	}
+
+	hostEnd := len(link)
+	if i := strings.IndexAny(link[hostStart:], "/?"); i != -1 {
+		hostEnd = hostStart + i
+	}
+
+	// the last colon separates the port, IPv6 addresses are in brackets
+	host := link[hostStart:hostEnd]
+	i := strings.LastIndexByte(host, ':')
+	if i == -1 || strings.IndexByte(host[i:], ']') != -1 {
+		return link, "", ""
+	}
+
+	return link[:hostStart+i], host[i+1:], link[hostEnd:]
+}
+
+// withPort returns the target with its port or port range replaced
+func (t *TargetLink) withPort(port int) TargetLink {
+	prefix, _, suffix := t.splitPorts()
+	return TargetLink(prefix + ":" + strconv.Itoa(port) + suffix)
+}
+
+// atOffset returns the destination a connection accepted on the offset
+// port of a listener range is sent to
+func (t *TargetLink) atOffset(offset int) TargetLink {
+	first, last, err := t.PortRange()
+	if err != nil || first == last {
+		return *t
+	}
+
+	return t.withPort(first + offset)
+}
+
+// listenAddr is a single address of an expanded listener
+type listenAddr struct {
+	link TargetLink
+	// offset is the position of the port within the listener's range
+	offset int
+}
+
+// listenAddrs expands the relay's listeners and their port ranges into the
+// individual addresses to listen on
+func (r *Relay) listenAddrs() ([]listenAddr, error) {
+	listeners := r.Listeners
+	if len(listeners) == 0 {
+		listeners = []TargetLink{r.Listener}
+	}
+
+	var addrs []listenAddr
+	for _, l := range listeners {
+		first, last, err := l.PortRange()
+		if err != nil {
+			return nil, err
+		}
+
+		if first == last {
+			addrs = append(addrs, listenAddr{link: l})
+			continue
+		}
+
+		if err := r.checkDestinationRanges(last - first + 1); err != nil {
+			return nil, err
+		}
+
+		for port := first; port <= last; port++ {
+			addrs = append(addrs, listenAddr{link: l.withPort(port), offset: port - first})
+		}
+	}
+
+	return addrs, nil
+}
+
+// checkDestinationRanges ensures every relay destination range has at least size ports
+func (r *Relay) checkDestinationRanges(size int) error {
+	return checkDestinationRanges(r.Destinations(), size)
+}
+
+// checkDestinationRanges ensures every destination range has at least size ports
+func checkDestinationRanges(destinations []TargetLink, size int) error {
+	for _, d := range destinations {
+		first, last, err := d.PortRange()
+		if err != nil {
+			return err
+		}
+
+		if first != last && last-first+1 < size {
+			return errors.Wrapf(ErrRangeTooSmall, "%q", d)
+		}
+	}
+
+	return nil
+}
+
+// listenerGroup closes all the listeners of a relay together
+type listenerGroup []net.Listener
+
+func (g listenerGroup) Close() error {
+	var err error
+	for _, l := range g {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,217 @@
+package localrelay
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestPortRange(t *testing.T) {
+	tests := []struct {
+		link        TargetLink
+		first, last int
+		err         bool
+	}{
+		{"tcp://0.0.0.0:9000", 9000, 9000, false},
+		{"tcp://0.0.0.0:9000-9010", 9000, 9010, false},
+		{"tcp://[::]:9000-9010?proxy=tor", 9000, 9010, false},
+		{"http://[::1]", 0, 0, false},
+		{"tcp://127.0.0.1:0", 0, 0, false},
+		{"https://example.com/path", 0, 0, false},
+		{"unix:///run/app.sock", 0, 0, false},
+		{"tcp://0.0.0.0:9010-9000", 0, 0, true},
+		{"tcp://0.0.0.0:0-10", 0, 0, true},
+		{"tcp://0.0.0.0:9000-70000", 0, 0, true},
+		{"tcp://0.0.0.0:http", 0, 0, true},
+	}
+
+	for _, test := range tests {
+		first, last, err := test.link.PortRange()
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.link, err)
+			continue
+		}
+
+		if err != nil && !errors.Is(err, ErrInvalidPortRange) {
+			t.Errorf("%s: expected ErrInvalidPortRange got %v", test.link, err)
+		}
+
+		if first != test.first || last != test.last {
+			t.Errorf("%s: expected %d-%d got %d-%d", test.link, test.first, test.last, first, last)
+		}
+	}
+}
+
+func TestTargetAtOffset(t *testing.T) {
+	tests := []struct {
+		link     TargetLink
+		offset   int
+		expected TargetLink
+	}{
+		{"tcp://10.0.0.1:8000-8010", 0, "tcp://10.0.0.1:8000"},
+		{"tcp://10.0.0.1:8000-8010?proxy=tor", 3, "tcp://10.0.0.1:8003?proxy=tor"},
+		{"tcp://[fd00::1]:8000-8010", 10, "tcp://[fd00::1]:8010"},
+		{"tcp://10.0.0.1:443", 5, "tcp://10.0.0.1:443"},
+	}
+
+	for _, test := range tests {
+		if d := test.link.atOffset(test.offset); d != test.expected {
+			t.Errorf("%s at %d: expected %s got %s", test.link, test.offset, test.expected, d)
+		}
+	}
+}
+
+// freePortRange returns the first of n consecutive ports which are free
+func freePortRange(t *testing.T, n int) int {
+	t.Helper()
+
+search:
+	for attempt := 0; attempt < 20; attempt++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first := l.Addr().(*net.TCPAddr).Port
+		listeners := []net.Listener{l}
+
+		for port := first + 1; port < first+n; port++ {
+			l, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
+			if err != nil {
+				listenerGroup(listeners).Close()
+				continue search
+			}
+
+			listeners = append(listeners, l)
+		}
+
+		listenerGroup(listeners).Close()
+		return first
+	}
+
+	t.Fatal("no free port range found")
+	return 0
+}
+
+func TestMultipleListeners(t *testing.T) {
+	first := freePortRange(t, 3)
+	single := freePortRange(t, 1)
+
+	relay, err := New("test-relay", io.Discard, "tcp://127.0.0.1:0", TargetLink("tcp://"+startEchoServer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = relay.SetListeners(
+		TargetLink("tcp://127.0.0.1:"+strconv.Itoa(first)+"-"+strconv.Itoa(first+2)),
+		TargetLink("tcp://127.0.0.1:"+strconv.Itoa(single)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- relay.ListenServe() }()
+
+	waitFor(t, relay.Running)
+
+	for _, port := range []int{first, first + 1, first + 2, single} {
+		waitFor(t, func() bool {
+			conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+			if err == nil {
+				conn.Close()
+			}
+
+			return err == nil
+		})
+
+		pingRelay(t, "tcp", "127.0.0.1:"+strconv.Itoa(port))
+	}
+
+	waitFor(t, func() bool {
+		return relay.Metrics.Snapshot().TotalConns >= 8
+	})
+
+	relay.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListenerRangeMapping(t *testing.T) {
+	listen := freePortRange(t, 2)
+
+	// each port of the destination range replies with its own marker
+	backend := freePortRange(t, 2)
+	for i, marker := range []string{"a", "b"} {
+		l, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(backend+i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() { l.Close() })
+
+		go func(marker string) {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+
+				conn.Write([]byte(marker))
+				conn.Close()
+			}
+		}(marker)
+	}
+
+	relay, err := New("test-relay", io.Discard,
+		TargetLink("tcp://127.0.0.1:"+strconv.Itoa(listen)+"-"+strconv.Itoa(listen+1)),
+		TargetLink("tcp://127.0.0.1:"+strconv.Itoa(backend)+"-"+strconv.Itoa(backend+1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go relay.ListenServe()
+	t.Cleanup(func() { relay.Close() })
+
+	for i, expected := range []string{"a", "b"} {
+		var conn net.Conn
+		waitFor(t, func() bool {
+			conn, err = net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(listen+i))
+			return err == nil
+		})
+
+		b, _ := io.ReadAll(conn)
+		conn.Close()
+
+		if string(b) != expected {
+			t.Fatalf("port offset %d: expected %q got %q", i, expected, b)
+		}
+	}
+}
+
+func TestListenerRangeErrors(t *testing.T) {
+	relay, err := New("test-relay", io.Discard, "tcp://127.0.0.1:9000-9005", "tcp://127.0.0.1:8000-8002")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := relay.ListenServe(); !errors.Is(err, ErrRangeTooSmall) {
+		t.Fatalf("expected ErrRangeTooSmall got %v", err)
+	}
+
+	if err := relay.SetListeners("tcp://127.0.0.1:9000", "udp://127.0.0.1:9000"); !errors.Is(err, ErrListenerType) {
+		t.Fatalf("expected ErrListenerType got %v", err)
+	}
+
+	if err := relay.SetListeners(); err != ErrNoListener {
+		t.Fatalf("expected ErrNoListener got %v", err)
+	}
+
+	if _, err := New("test-relay", io.Discard, "http://127.0.0.1:80", "http://127.0.0.1:8000-8002"); !errors.Is(err, ErrInvalidPortRange) {
+		t.Fatalf("expected ErrInvalidPortRange got %v", err)
+	}
+}
@@ -2,22 +2,90 @@ package localrelay
 
 import (
 	"io"
-	"log"
+	"log/slog"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LogFormat selects how log records are encoded
+type LogFormat string
+
+const (
+	// LogText writes records as key=value pairs
+	LogText LogFormat = "text"
+	// LogJSON writes a JSON object per record
+	LogJSON LogFormat = "json"
+)
+
+var (
+	// ErrInvalidLogFormat is returned when a log format is not text or json
+	ErrInvalidLogFormat = errors.New("invalid log format, expected text or json")
+	// ErrInvalidLogLevel is returned when a log level is not recognised
+	ErrInvalidLogLevel = errors.New("invalid log level, expected debug, info, warn or error")
 )
 
-// Logger is used for logging debug information such as
-// connections being created, dropped etc
-type Logger struct {
-	Info    *log.Logger
-	Warning *log.Logger
-	Error   *log.Logger
+// LogOptions configures the handler created by NewLogHandler
+type LogOptions struct {
+	// Format defaults to LogText
+	Format LogFormat
+	// Level is the minimum level written, defaults to info
+	Level slog.Level
+}
+
+// NewLogHandler creates a text or JSON slog handler writing to w
+func NewLogHandler(w io.Writer, opts LogOptions) slog.Handler {
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level}
+
+	if opts.Format == LogJSON {
+		return slog.NewJSONHandler(w, handlerOpts)
+	}
+
+	return slog.NewTextHandler(w, handlerOpts)
 }
 
-// NewLogger creates a new logging system
-func NewLogger(w io.Writer, name string) *Logger {
-	return &Logger{
-		Info:    log.New(w, "[INFO] ["+name+"] ", log.Lshortfile|log.Lmicroseconds),
-		Warning: log.New(w, "[WARNING] ["+name+"] ", log.Lshortfile|log.Lmicroseconds),
-		Error:   log.New(w, "[ERROR] ["+name+"] ", log.Lshortfile|log.Lmicroseconds),
+// ParseLogFormat parses "text" or "json", an empty string is text
+func ParseLogFormat(format string) (LogFormat, error) {
+	switch LogFormat(strings.ToLower(format)) {
+	case "", LogText:
+		return LogText, nil
+	case LogJSON:
+		return LogJSON, nil
+	default:
+		return "", errors.Wrapf(ErrInvalidLogFormat, "%q", format)
 	}
 }
+
+// ParseLogLevel parses debug, info, warn or error, an empty string is info
+func ParseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, errors.Wrapf(ErrInvalidLogLevel, "%q", level)
+	}
+}
+
+// SetLogHandler replaces the handler the relay logs to. The relay's name
+// is added to every record as the "relay" attribute.
+// Must be called before the relay is started.
+func (r *Relay) SetLogHandler(h slog.Handler) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.logger = slog.New(h).With("relay", r.Name)
+}
+
+// Logger returns the relay's logger
+func (r *Relay) Logger() *slog.Logger {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.logger
+}
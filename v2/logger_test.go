@@ -0,0 +1,107 @@
+package localrelay
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	for input, expected := range map[string]slog.Level{
+		"":      slog.LevelInfo,
+		"DEBUG": slog.LevelDebug,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	} {
+		level, err := ParseLogLevel(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if level != expected {
+			t.Fatalf("%q: expected %s got %s", input, expected, level)
+		}
+	}
+
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Fatal("expected an error for an unknown level")
+	}
+
+	if _, err := ParseLogFormat("xml"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	m   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.buf.String()
+}
+
+func TestJSONLogFields(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relay, err := New("test-relay", io.Discard, TargetLink("tcp://"+l.Addr().String()), TargetLink("tcp://"+startEchoServer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &syncBuffer{}
+	relay.SetLogHandler(NewLogHandler(out, LogOptions{Format: LogJSON}))
+
+	go relay.Serve(l)
+	t.Cleanup(func() { relay.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Write([]byte("ping"))
+	io.ReadFull(conn, make([]byte, 4))
+	conn.Close()
+
+	var closed map[string]any
+	waitFor(t, func() bool {
+		for _, line := range bytes.Split([]byte(out.String()), []byte("\n")) {
+			var record map[string]any
+			if json.Unmarshal(line, &record) == nil && record["msg"] == "connection closed" {
+				closed = record
+				return true
+			}
+		}
+
+		return false
+	})
+
+	for _, field := range []string{"relay", "conn_id", "client", "bytes_in", "bytes_out", "duration", "reason"} {
+		if _, ok := closed[field]; !ok {
+			t.Errorf("missing field %q in %v", field, closed)
+		}
+	}
+
+	if closed["relay"] != "test-relay" || closed["bytes_in"] != float64(4) {
+		t.Fatalf("unexpected record %v", closed)
+	}
+}
@@ -2,39 +2,109 @@ package localrelay
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Metrics stores information such as bandwidth usage
 // conn stats etc
 type Metrics struct {
-	up, down              int
+	// up, down and throttled are updated atomically on the copy path.
+	// Kept first for 64-bit alignment on 32-bit platforms.
+	up, down  int64
+	throttled uint64
+
 	dialFail, dialSuccess uint64
 	activeConns           int
 	totalConns            uint64
 	totalRequests         uint64
+	denied                uint64
+
+	// closeReasons counts why connections were closed
+	closeReasons map[CloseReason]uint64
 
 	// dialTimes holds recent durations of how long it takes a
 	// relay to dial a remote
 	dialTimes []int64
 
+	// dialLatency holds successful dial times, connDuration how long
+	// connections stayed open and ttfb the time until a HTTP response
+	dialLatency, connDuration, ttfb Histogram
+
+	// destinations and proxies are keyed by destination and proxy name
+	destinations map[string]*targetStats
+	proxies      map[string]*targetStats
+
 	m sync.RWMutex
 }
 
+// MetricsSnapshot is a copy of a relay's metrics
+type MetricsSnapshot struct {
+	Upload, Download int64
+	Active           int
+	TotalConns       uint64
+	Requests         uint64
+
+	DialSuccess, DialFail uint64
+	Throttled             uint64
+	Denied                uint64
+	CloseReasons          map[CloseReason]uint64
+
+	DialLatency  HistogramSnapshot
+	ConnDuration HistogramSnapshot
+	// TTFB is the time until the first byte of a HTTP response
+	TTFB HistogramSnapshot
+
+	// Destinations and Proxies are keyed by destination and proxy name
+	Destinations map[string]TargetMetrics
+	Proxies      map[string]TargetMetrics
+}
+
+// TargetMetrics holds the statistics of a single destination or proxy
+type TargetMetrics struct {
+	// Dials counts successful dials and Failures the failed dials
+	Dials, Failures  uint64
+	Upload, Download int64
+	DialLatency      HistogramSnapshot
+}
+
+// targetStats counts the traffic of a destination or proxy
+type targetStats struct {
+	// up and down are updated atomically on the copy path.
+	// Kept first for 64-bit alignment on 32-bit platforms.
+	up, down int64
+
+	dials, failures uint64
+	latency         Histogram
+}
+
+func (t *targetStats) bandwidth(n int, upload bool) {
+	if upload {
+		atomic.AddInt64(&t.up, int64(n))
+		return
+	}
+
+	atomic.AddInt64(&t.down, int64(n))
+}
+
+func (t *targetStats) snapshot() TargetMetrics {
+	return TargetMetrics{
+		Dials:       atomic.LoadUint64(&t.dials),
+		Failures:    atomic.LoadUint64(&t.failures),
+		Upload:      atomic.LoadInt64(&t.up),
+		Download:    atomic.LoadInt64(&t.down),
+		DialLatency: t.latency.Snapshot(),
+	}
+}
+
 // Upload returns the amount of bytes uploaded through the relay
 func (m *Metrics) Upload() int {
-	m.m.RLock()
-	defer m.m.RUnlock()
-
-	return m.up
+	return int(atomic.LoadInt64(&m.up))
 }
 
 // Download returns the amount of bytes downloaded through the relay
 func (m *Metrics) Download() int {
-	m.m.RLock()
-	defer m.m.RUnlock()
-
-	return m.down
+	return int(atomic.LoadInt64(&m.down))
 }
 
 // Connections returns the amount of active and total connections
@@ -61,6 +131,91 @@ func (m *Metrics) Dialer() (success, failed uint64) {
 	return m.dialSuccess, m.dialFail
 }
 
+// Throttled returns the amount of times traffic was delayed
+// by a bandwidth limit
+func (m *Metrics) Throttled() uint64 {
+	return atomic.LoadUint64(&m.throttled)
+}
+
+// Denied returns the amount of connections rejected by the ACL
+func (m *Metrics) Denied() uint64 {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	return m.denied
+}
+
+// CloseReasons returns how many connections were closed for each reason
+func (m *Metrics) CloseReasons() map[CloseReason]uint64 {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	reasons := make(map[CloseReason]uint64, len(m.closeReasons))
+	for reason, n := range m.closeReasons {
+		reasons[reason] = n
+	}
+
+	return reasons
+}
+
+// DialLatency returns the distribution of successful dial times
+func (m *Metrics) DialLatency() HistogramSnapshot {
+	return m.dialLatency.Snapshot()
+}
+
+// ConnDuration returns the distribution of how long connections were open
+func (m *Metrics) ConnDuration() HistogramSnapshot {
+	return m.connDuration.Snapshot()
+}
+
+// TTFB returns the distribution of time to first byte for HTTP responses
+func (m *Metrics) TTFB() HistogramSnapshot {
+	return m.ttfb.Snapshot()
+}
+
+// Snapshot returns a copy of all metrics including per destination
+// and per proxy statistics
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.m.RLock()
+
+	s := MetricsSnapshot{
+		Active:       m.activeConns,
+		TotalConns:   m.totalConns,
+		Requests:     m.totalRequests,
+		DialSuccess:  m.dialSuccess,
+		DialFail:     m.dialFail,
+		Denied:       m.denied,
+		CloseReasons: make(map[CloseReason]uint64, len(m.closeReasons)),
+
+		Destinations: make(map[string]TargetMetrics, len(m.destinations)),
+		Proxies:      make(map[string]TargetMetrics, len(m.proxies)),
+	}
+
+	for reason, n := range m.closeReasons {
+		s.CloseReasons[reason] = n
+	}
+
+	for name, t := range m.destinations {
+		s.Destinations[name] = t.snapshot()
+	}
+
+	for name, t := range m.proxies {
+		s.Proxies[name] = t.snapshot()
+	}
+
+	m.m.RUnlock()
+
+	s.Upload = atomic.LoadInt64(&m.up)
+	s.Download = atomic.LoadInt64(&m.down)
+	s.Throttled = atomic.LoadUint64(&m.throttled)
+
+	s.DialLatency = m.dialLatency.Snapshot()
+	s.ConnDuration = m.connDuration.Snapshot()
+	s.TTFB = m.ttfb.Snapshot()
+
+	return s
+}
+
 // DialerAvg returns the 10 point average dial time
 // this average includes failed dials
 func (m *Metrics) DialerAvg() (milliseconds int) {
@@ -81,27 +236,79 @@ func (m *Metrics) DialerAvg() (milliseconds int) {
 
 // bandwidth will increment the bandwidth statistics
 func (m *Metrics) bandwidth(up, down int) {
-	m.m.Lock()
-	defer m.m.Unlock()
+	if up != 0 {
+		atomic.AddInt64(&m.up, int64(up))
+	}
 
-	m.up += up
-	m.down += down
+	if down != 0 {
+		atomic.AddInt64(&m.down, int64(down))
+	}
 }
 
-// dial will increment the dialer success/fail statistics
-func (m *Metrics) dial(success, failed uint64, t time.Time) {
+// dial will increment the dialer success/fail statistics of the relay,
+// destination and proxy. The returned stats are used to count the bytes
+// relayed over the connection.
+func (m *Metrics) dial(destination TargetLink, proxy string, success bool, t time.Time) []*targetStats {
+	elapsed := time.Since(t)
+
 	m.m.Lock()
-	defer m.m.Unlock()
 
-	m.dialSuccess += success
-	m.dialFail += failed
+	if success {
+		m.dialSuccess++
+	} else {
+		m.dialFail++
+	}
 
 	// 10 point moving average
 	if len(m.dialTimes) >= 10 {
-		m.dialTimes = append(m.dialTimes[1:], time.Since(t).Milliseconds())
+		m.dialTimes = append(m.dialTimes[1:], elapsed.Milliseconds())
 	} else {
-		m.dialTimes = append(m.dialTimes, time.Since(t).Milliseconds())
+		m.dialTimes = append(m.dialTimes, elapsed.Milliseconds())
+	}
+
+	if m.destinations == nil {
+		m.destinations = make(map[string]*targetStats)
+		m.proxies = make(map[string]*targetStats)
+	}
+
+	stats := []*targetStats{target(m.destinations, string(destination))}
+	if proxy != "" {
+		stats = append(stats, target(m.proxies, proxy))
+	}
+
+	m.m.Unlock()
+
+	for _, t := range stats {
+		if !success {
+			atomic.AddUint64(&t.failures, 1)
+			continue
+		}
+
+		atomic.AddUint64(&t.dials, 1)
+		t.latency.Observe(elapsed)
+	}
+
+	if success {
+		m.dialLatency.Observe(elapsed)
 	}
+
+	return stats
+}
+
+// target returns the stats for name, creating them if needed
+func target(targets map[string]*targetStats, name string) *targetStats {
+	t, ok := targets[name]
+	if !ok {
+		t = &targetStats{}
+		targets[name] = t
+	}
+
+	return t
+}
+
+// firstByte records the time until a HTTP response was received
+func (m *Metrics) firstByte(t time.Time) {
+	m.ttfb.Observe(time.Since(t))
 }
 
 // connections will update the active connections metric
@@ -117,6 +324,11 @@ func (m *Metrics) connections(delta int) {
 	m.activeConns += delta
 }
 
+// duration records how long a connection was open
+func (m *Metrics) duration(d time.Duration) {
+	m.connDuration.Observe(d)
+}
+
 // requests will update the requests metric
 func (m *Metrics) requests(delta int) {
 	m.m.Lock()
@@ -124,3 +336,28 @@ func (m *Metrics) requests(delta int) {
 
 	m.totalRequests += uint64(delta)
 }
+
+// throttle will increment the throttled metric
+func (m *Metrics) throttle() {
+	atomic.AddUint64(&m.throttled, 1)
+}
+
+// deny will increment the denied connections metric
+func (m *Metrics) deny() {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.denied++
+}
+
+// closed will increment the close reason metric
+func (m *Metrics) closed(reason CloseReason) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if m.closeReasons == nil {
+		m.closeReasons = make(map[CloseReason]uint64)
+	}
+
+	m.closeReasons[reason]++
+}
@@ -0,0 +1,43 @@
+package localrelay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMetricsTargets(t *testing.T) {
+	m := &Metrics{}
+
+	start := time.Now().Add(-time.Millisecond * 20)
+	stats := m.dial("tcp://example.com:80", "tor", true, start)
+	m.dial("tcp://example.com:80", "", false, start)
+
+	if len(stats) != 2 {
+		t.Fatalf("expected destination and proxy stats got %d", len(stats))
+	}
+
+	for _, s := range stats {
+		s.bandwidth(100, true)
+		s.bandwidth(50, false)
+	}
+
+	snap := m.Snapshot()
+	if snap.DialSuccess != 1 || snap.DialFail != 1 {
+		t.Fatalf("expected 1 success and 1 failure got %d/%d", snap.DialSuccess, snap.DialFail)
+	}
+
+	dst := snap.Destinations["tcp://example.com:80"]
+	if dst.Dials != 1 || dst.Failures != 1 || dst.Upload != 100 || dst.Download != 50 {
+		t.Fatalf("unexpected destination metrics %+v", dst)
+	}
+
+	proxy := snap.Proxies["tor"]
+	if proxy.Dials != 1 || proxy.Failures != 0 || proxy.DialLatency.Count != 1 {
+		t.Fatalf("unexpected proxy metrics %+v", proxy)
+	}
+
+	// failed dials are not included in the latency distribution
+	if snap.DialLatency.Count != 1 || snap.DialLatency.P50 < time.Millisecond*10 {
+		t.Fatalf("unexpected dial latency %+v", snap.DialLatency)
+	}
+}
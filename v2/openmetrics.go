@@ -0,0 +1,256 @@
+package localrelay
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenMetricsContentType is the content type served by MetricsExporter
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// MetricsExporter is a http.Handler which serves the metrics of relays in
+// the OpenMetrics text format, allowing them to be scraped by Prometheus
+type MetricsExporter struct {
+	// Relays returns the relays to export and is called on every scrape
+	Relays func() []*Relay
+
+	// Version and Started are exported when set
+	Version string
+	Started time.Time
+}
+
+// relaySnapshot is a relay's metrics captured at the start of a scrape
+type relaySnapshot struct {
+	name     string
+	running  bool
+	restarts int
+	metrics  MetricsSnapshot
+}
+
+// ServeHTTP writes the metrics of every relay
+func (e *MetricsExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", OpenMetricsContentType)
+
+	buf := bufio.NewWriter(w)
+	e.write(buf)
+	buf.Flush()
+}
+
+func (e *MetricsExporter) write(w *bufio.Writer) {
+	var relays []relaySnapshot
+	if e.Relays != nil {
+		for _, r := range e.Relays() {
+			relays = append(relays, relaySnapshot{
+				name:     r.Name,
+				running:  r.Running(),
+				restarts: r.Status().Restarts,
+				metrics:  r.Metrics.Snapshot(),
+			})
+		}
+	}
+
+	sort.Slice(relays, func(i, k int) bool {
+		return relays[i].name < relays[k].name
+	})
+
+	if e.Version != "" {
+		family(w, "localrelay", "info", "", "Localrelay build information")
+		sample(w, "localrelay_info", labels("version", e.Version), 1)
+	}
+
+	if !e.Started.IsZero() {
+		family(w, "localrelay_start_time_seconds", "gauge", "seconds", "Unix time the process was started")
+		sample(w, "localrelay_start_time_seconds", "", float64(e.Started.UnixNano())/1e9)
+
+		family(w, "localrelay_uptime_seconds", "gauge", "seconds", "Time since the process was started")
+		sample(w, "localrelay_uptime_seconds", "", time.Since(e.Started).Seconds())
+	}
+
+	family(w, "localrelay_relay_up", "gauge", "", "Whether the relay is running")
+	for _, r := range relays {
+		up := 0.0
+		if r.running {
+			up = 1
+		}
+
+		sample(w, "localrelay_relay_up", labels("relay", r.name), up)
+	}
+
+	family(w, "localrelay_relay_restarts", "counter", "", "Times the relay started listening again")
+	for _, r := range relays {
+		sample(w, "localrelay_relay_restarts_total", labels("relay", r.name), float64(r.restarts))
+	}
+
+	family(w, "localrelay_relayed_bytes", "counter", "bytes", "Bytes relayed by direction")
+	for _, r := range relays {
+		sample(w, "localrelay_relayed_bytes_total", labels("relay", r.name, "direction", "upload"), float64(r.metrics.Upload))
+		sample(w, "localrelay_relayed_bytes_total", labels("relay", r.name, "direction", "download"), float64(r.metrics.Download))
+	}
+
+	family(w, "localrelay_active_connections", "gauge", "", "Currently open connections")
+	for _, r := range relays {
+		sample(w, "localrelay_active_connections", labels("relay", r.name), float64(r.metrics.Active))
+	}
+
+	family(w, "localrelay_connections", "counter", "", "Accepted connections")
+	for _, r := range relays {
+		sample(w, "localrelay_connections_total", labels("relay", r.name), float64(r.metrics.TotalConns))
+	}
+
+	family(w, "localrelay_requests", "counter", "", "HTTP requests relayed")
+	for _, r := range relays {
+		sample(w, "localrelay_requests_total", labels("relay", r.name), float64(r.metrics.Requests))
+	}
+
+	family(w, "localrelay_dials", "counter", "", "Destination dials by result")
+	for _, r := range relays {
+		sample(w, "localrelay_dials_total", labels("relay", r.name, "result", "success"), float64(r.metrics.DialSuccess))
+		sample(w, "localrelay_dials_total", labels("relay", r.name, "result", "failure"), float64(r.metrics.DialFail))
+	}
+
+	family(w, "localrelay_denied_connections", "counter", "", "Connections rejected by the ACL or jail")
+	for _, r := range relays {
+		sample(w, "localrelay_denied_connections_total", labels("relay", r.name), float64(r.metrics.Denied))
+	}
+
+	family(w, "localrelay_throttled", "counter", "", "Times traffic was delayed by a bandwidth limit")
+	for _, r := range relays {
+		sample(w, "localrelay_throttled_total", labels("relay", r.name), float64(r.metrics.Throttled))
+	}
+
+	family(w, "localrelay_closed_connections", "counter", "", "Closed connections by reason")
+	for _, r := range relays {
+		reasons := make([]string, 0, len(r.metrics.CloseReasons))
+		for reason := range r.metrics.CloseReasons {
+			reasons = append(reasons, string(reason))
+		}
+
+		sort.Strings(reasons)
+
+		for _, reason := range reasons {
+			sample(w, "localrelay_closed_connections_total", labels("relay", r.name, "reason", reason),
+				float64(r.metrics.CloseReasons[CloseReason(reason)]))
+		}
+	}
+
+	family(w, "localrelay_dial_latency_seconds", "histogram", "seconds", "Successful dial latency")
+	for _, r := range relays {
+		histogram(w, "localrelay_dial_latency_seconds", r.metrics.DialLatency, "relay", r.name)
+	}
+
+	family(w, "localrelay_connection_duration_seconds", "histogram", "seconds", "How long connections were open")
+	for _, r := range relays {
+		histogram(w, "localrelay_connection_duration_seconds", r.metrics.ConnDuration, "relay", r.name)
+	}
+
+	family(w, "localrelay_http_ttfb_seconds", "histogram", "seconds", "Time to first byte of HTTP responses")
+	for _, r := range relays {
+		histogram(w, "localrelay_http_ttfb_seconds", r.metrics.TTFB, "relay", r.name)
+	}
+
+	targetFamilies(w, relays, "destination", func(m MetricsSnapshot) map[string]TargetMetrics {
+		return m.Destinations
+	})
+
+	targetFamilies(w, relays, "proxy", func(m MetricsSnapshot) map[string]TargetMetrics {
+		return m.Proxies
+	})
+
+	w.WriteString("# EOF\n")
+}
+
+// targetFamilies writes the per destination or per proxy metric families
+func targetFamilies(w *bufio.Writer, relays []relaySnapshot, kind string, targets func(MetricsSnapshot) map[string]TargetMetrics) {
+	prefix := "localrelay_" + kind + "_"
+
+	each := func(fn func(relay, name string, t TargetMetrics)) {
+		for _, r := range relays {
+			m := targets(r.metrics)
+
+			names := make([]string, 0, len(m))
+			for name := range m {
+				names = append(names, name)
+			}
+
+			sort.Strings(names)
+
+			for _, name := range names {
+				fn(r.name, name, m[name])
+			}
+		}
+	}
+
+	family(w, prefix+"dials", "counter", "", "Dials by "+kind+" and result")
+	each(func(relay, name string, t TargetMetrics) {
+		sample(w, prefix+"dials_total", labels("relay", relay, kind, name, "result", "success"), float64(t.Dials))
+		sample(w, prefix+"dials_total", labels("relay", relay, kind, name, "result", "failure"), float64(t.Failures))
+	})
+
+	family(w, prefix+"relayed_bytes", "counter", "bytes", "Bytes relayed by "+kind+" and direction")
+	each(func(relay, name string, t TargetMetrics) {
+		sample(w, prefix+"relayed_bytes_total", labels("relay", relay, kind, name, "direction", "upload"), float64(t.Upload))
+		sample(w, prefix+"relayed_bytes_total", labels("relay", relay, kind, name, "direction", "download"), float64(t.Download))
+	})
+
+	family(w, prefix+"dial_latency_seconds", "histogram", "seconds", "Successful dial latency by "+kind)
+	each(func(relay, name string, t TargetMetrics) {
+		histogram(w, prefix+"dial_latency_seconds", t.DialLatency, "relay", relay, kind, name)
+	})
+}
+
+// family writes the metadata of a metric family
+func family(w *bufio.Writer, name, kind, unit, help string) {
+	w.WriteString("# TYPE " + name + " " + kind + "\n")
+	if unit != "" {
+		w.WriteString("# UNIT " + name + " " + unit + "\n")
+	}
+
+	w.WriteString("# HELP " + name + " " + help + "\n")
+}
+
+// sample writes a single metric point. labels must already be formatted.
+func sample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name + labels + " " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+// histogram writes the buckets, count and sum of a histogram in seconds
+func histogram(w *bufio.Writer, name string, h HistogramSnapshot, pairs ...string) {
+	for _, b := range h.Buckets {
+		le := "+Inf"
+		if b.UpperBound != 0 {
+			le = strconv.FormatFloat(b.UpperBound.Seconds(), 'g', -1, 64)
+		}
+
+		sample(w, name+"_bucket", labels(append(pairs[:len(pairs):len(pairs)], "le", le)...), float64(b.Count))
+	}
+
+	sample(w, name+"_count", labels(pairs...), float64(h.Count))
+	sample(w, name+"_sum", labels(pairs...), h.Sum.Seconds())
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats key value pairs as a label set
+func labels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		b.WriteString(pairs[i] + `="` + labelEscaper.Replace(pairs[i+1]) + `"`)
+	}
+
+	b.WriteByte('}')
+	return b.String()
+}
@@ -0,0 +1,52 @@
+package localrelay
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsExporter(t *testing.T) {
+	relay, err := New("test-relay", io.Discard, "tcp://127.0.0.1:23832", "tcp://127.0.0.1:23838")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range relay.Metrics.dial("tcp://127.0.0.1:23838", `my "proxy"`, true, time.Now()) {
+		s.bandwidth(42, true)
+	}
+
+	e := &MetricsExporter{
+		Relays:  func() []*Relay { return []*Relay{relay} },
+		Version: "v2.0.0",
+		Started: time.Now(),
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != OpenMetricsContentType {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		`localrelay_info{version="v2.0.0"} 1`,
+		`localrelay_relay_up{relay="test-relay"} 0`,
+		`localrelay_relay_restarts_total{relay="test-relay"} 0`,
+		`localrelay_dials_total{relay="test-relay",result="success"} 1`,
+		`localrelay_dial_latency_seconds_bucket{relay="test-relay",le="+Inf"} 1`,
+		`localrelay_destination_relayed_bytes_total{relay="test-relay",destination="tcp://127.0.0.1:23838",direction="upload"} 42`,
+		`localrelay_proxy_dials_total{relay="test-relay",proxy="my \"proxy\"",result="success"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q", line)
+		}
+	}
+
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Fatal("exposition must end with # EOF")
+	}
+}
@@ -2,6 +2,7 @@ package localrelay
 
 import (
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
@@ -29,6 +30,9 @@ type Relay struct {
 	// Example B (listen on all interfaces):
 	// tcp://0.0.0.0:443
 	Listener TargetLink
+	// Listeners are all the addresses the relay listens on when set with
+	// SetListeners, Listener is always the first.
+	Listeners []TargetLink
 
 	// Destination is an array of connection URLs.
 	// Example A:
@@ -43,7 +47,10 @@ type Relay struct {
 	ProxyEnabled bool
 	proxies      map[string]ProxyURL
 
-	logger *Logger
+	// destinationStates holds the destinations which are not active
+	destinationStates map[TargetLink]DestinationState
+
+	logger *slog.Logger
 
 	// close is linked to the listener
 	close io.Closer
@@ -62,11 +69,42 @@ type Relay struct {
 
 	loadbalance Loadbalance
 
-	running bool
-	m       sync.Mutex
-
-	// connPool contains a list of ACTIVE connections
-	connPool []*PooledConn
+	// throttle applies the bandwidth limits
+	throttle *throttle
+
+	// acl filters clients before any dial is made
+	acl acl
+	// jail temporarily bans abusive clients
+	jail *Jail
+
+	timeouts  Timeouts
+	hooks     Hooks
+	accessLog *AccessLog
+	capture   *capture
+
+	// state, lastErr, started and restarts track the relay's lifecycle
+	state    RelayState
+	lastErr  error
+	started  time.Time
+	restarts int
+
+	// restart is set while Restart reopens the listeners, restartable
+	// relays were started by ListenServe
+	restart     bool
+	restartable bool
+	// restarted is closed once Restart's listeners are open again or the
+	// relay has stopped
+	restarted chan struct{}
+	// httpShutdown is set once httpServer has been shutdown
+	httpShutdown bool
+
+	m sync.Mutex
+
+	// conns contains the ACTIVE connections keyed by ID
+	conns map[uint64]*trackedConn
+	// idle is closed once the last connection is removed, it is only
+	// created while Shutdown waits for the connections
+	idle chan struct{}
 
 	// Tags are used to propogate relay properties to the API client/CLI
 	Targs map[string]struct{}
@@ -77,13 +115,6 @@ type Loadbalance struct {
 	Algorithm string
 }
 
-// PooledConn allows meta data to be attached to a connection
-type PooledConn struct {
-	Conn       net.Conn
-	RemoteAddr string
-	Opened     time.Time
-}
-
 type ProxyURL struct {
 	*url.URL
 }
@@ -93,6 +124,8 @@ const (
 	ProxyTCP ProxyType = "tcp"
 	// ProxyUDP forwards UDP traffic
 	ProxyUDP ProxyType = "udp"
+	// ProxyUnix forwards raw traffic over unix domain sockets
+	ProxyUnix ProxyType = "unix"
 	// ProxyHTTP creates a HTTP server and forwards the traffic to
 	// either a HTTP or HTTPs server
 	ProxyHTTP ProxyType = "http"
@@ -128,11 +161,12 @@ func New(name string, logger io.Writer, listener TargetLink, destination ...Targ
 		tags["failover"] = struct{}{}
 	}
 
-	// if a http(s) proxy enforce one destination only policy
-	if t := destination[0].ProxyType(); t == ProxyHTTP || t == ProxyHTTPS {
-		if len(destination) > 1 {
-			return nil, ErrManyDestinations
-		}
+	if err := validateDestinations(destination); err != nil {
+		return nil, err
+	}
+
+	if err := listener.Validate(); err != nil {
+		return nil, err
 	}
 
 	if logger == nil {
@@ -151,42 +185,44 @@ func New(name string, logger io.Writer, listener TargetLink, destination ...Targ
 
 		httpClient: http.DefaultClient,
 		proxies:    make(map[string]ProxyURL),
+		throttle:   newThrottle(),
+		conns:      make(map[uint64]*trackedConn),
+		state:      StateStopped,
 
-		logger: NewLogger(logger, name),
+		logger: slog.New(NewLogHandler(logger, LogOptions{})).With("relay", name),
 		Targs:  tags,
 	}, nil
 }
 
-// Running returns true if relay is running
-func (r *Relay) Running() bool {
-	r.m.Lock()
-	defer r.m.Unlock()
-
-	return r.running
-}
-
-func (r *Relay) setRunning(toggle bool) {
-	r.m.Lock()
-	defer r.m.Unlock()
-
-	r.running = toggle
-}
-
 // SetHTTP is used to set the relay as a type HTTP relay
 // addr will auto be set in the server object if left blank
 func (r *Relay) SetHTTP(server *http.Server) error {
+	// port ranges are not valid addresses so the first port is used
+	listener := r.Listener.atOffset(0)
+
 	// Auto set addr if left blank
 	if server.Addr == "" {
-		server.Addr = r.Listener.Addr()
-	} else if server.Addr != r.Listener.Addr() {
+		server.Addr = listener.Addr()
+	} else if server.Addr != listener.Addr() {
 		return ErrAddrNotMatch
 	}
 
+	r.m.Lock()
 	r.httpServer = server
+	r.m.Unlock()
 
 	return nil
 }
 
+// server returns the HTTP server, it is replaced each time an HTTP relay
+// starts after a shutdown
+func (r *Relay) server() *http.Server {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.httpServer
+}
+
 // SetClient will set the http client used by the relay
 func (r *Relay) SetClient(client *http.Client) {
 	r.httpClient = client
@@ -205,153 +241,324 @@ func (r *Relay) SetTLS(certificateFile, keyFile string) {
 
 // SetProxy sets the proxy dialer to be used
 // proxy.SOCKS5() can be used to setup a socks5 proxy
-// or a list of proxies
+// or a list of proxies.
+// Proxies can be changed while the relay is running, established
+// connections keep using the proxy they were dialled through.
 func (r *Relay) SetProxy(proxies map[string]ProxyURL) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
 	r.proxies = proxies
-	r.ProxyEnabled = true
+	r.ProxyEnabled = len(proxies) > 0
+}
+
+// proxy returns the proxy with the provided name
+func (r *Relay) proxy(name string) (ProxyURL, bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	proxy, found := r.proxies[name]
+	return proxy, found
 }
 
 func (r *Relay) SetLoadbalance(enabled bool) {
-	r.loadbalance.Enabled = true
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.loadbalance.Enabled = enabled
+	r.setTags()
+}
 
-	if enabled {
-		r.Targs["load-balancer"] = struct{}{}
-		delete(r.Targs, "failover")
-	} else {
-		delete(r.Targs, "load-balancer")
+// loadbalanced returns true if load balancing is enabled
+func (r *Relay) loadbalanced() bool {
+	r.m.Lock()
+	defer r.m.Unlock()
 
-		if len(r.Destination) < 2 {
-			delete(r.Targs, "failover")
+	return r.loadbalance.Enabled
+}
+
+// setTags updates the load balancer and failover tags, r.m must be held.
+// The tags are replaced rather than modified as copies of the relay share them.
+func (r *Relay) setTags() {
+	tags := make(map[string]struct{}, len(r.Targs))
+	for tag := range r.Targs {
+		if tag != "load-balancer" && tag != "failover" {
+			tags[tag] = struct{}{}
 		}
 	}
+
+	if r.loadbalance.Enabled {
+		tags["load-balancer"] = struct{}{}
+	} else if len(r.Destination) > 1 {
+		tags["failover"] = struct{}{}
+	}
+
+	r.Targs = tags
+}
+
+// SetLimits sets the bandwidth limits of the relay.
+// Limits can be changed while the relay is running and will be applied
+// to all active connections.
+func (r *Relay) SetLimits(limits Limits) {
+	r.throttle.set(limits)
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, tc := range r.conns {
+		tc.limiter.set(limits.Conn)
+	}
+}
+
+// Limits returns the bandwidth limits of the relay
+func (r *Relay) Limits() Limits {
+	return r.throttle.get()
+}
+
+// Throttling returns the amount of connections currently being delayed
+// by a bandwidth limit
+func (r *Relay) Throttling() int {
+	return r.throttle.throttling()
+}
+
+// SetACL replaces the relay's access control rules.
+// Rules are checked in order and the first match decides if the client
+// is allowed, clients matching no rules are allowed.
+// Rules can be changed while the relay is running.
+func (r *Relay) SetACL(rules []ACLRule) {
+	r.acl.set(rules)
+}
+
+// ACL returns a copy of the relay's access control rules
+func (r *Relay) ACL() []ACLRule {
+	return r.acl.get()
+}
+
+// SetJail sets the jail used to temporarily ban abusive clients.
+// A jail can be shared between relays.
+func (r *Relay) SetJail(jail *Jail) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.jail = jail
+}
+
+// Jail returns the relay's jail or nil if banning is disabled
+func (r *Relay) Jail() *Jail {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.jail
+}
+
+// Report records an offense for the client ip. If this results in the
+// client being banned all of its active connections are dropped.
+func (r *Relay) Report(ip string, offense Offense) {
+	jail := r.Jail()
+	if jail == nil || !jail.Report(ip, offense) {
+		return
+	}
+
+	r.Logger().Warn("client banned", "client", ip, "offense", offense)
+	r.dropIP(ip)
+}
+
+// permit checks the jail, ACL and connection rate for a newly accepted
+// connection. Denied connections are closed, counted and logged.
+func (r *Relay) permit(conn net.Conn) bool {
+	ip := addrHost(conn.RemoteAddr())
+
+	if jail := r.Jail(); jail != nil && jail.Banned(ip) {
+		r.Metrics.deny()
+		r.Logger().Warn("banned client rejected", "client", conn.RemoteAddr().String(), "listener", conn.LocalAddr().String())
+
+		conn.Close()
+		return false
+	}
+
+	if !r.acl.allowed(conn.RemoteAddr()) {
+		r.Metrics.deny()
+		r.Logger().Warn("client denied by acl", "client", conn.RemoteAddr().String(), "listener", conn.LocalAddr().String())
+
+		conn.Close()
+		r.Report(ip, OffenseRejected)
+
+		return false
+	}
+
+	if !r.throttle.allowConn(ip) {
+		r.Metrics.deny()
+		r.Logger().Warn("client exceeded connection rate", "client", conn.RemoteAddr().String(), "listener", conn.LocalAddr().String())
+
+		conn.Close()
+		r.Report(ip, OffenseRateLimit)
+
+		return false
+	}
+
+	return true
 }
 
 // Loadbalancer returns true if the relay is a load balancer
 func (r *Relay) Loadbalancer() bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
 	return r.loadbalance.Enabled || hasTag(r.Targs, "load-balancer")
 }
 
 // Failover returns true if the relay offers failover but is not a load balancer
 func (r *Relay) Failover() bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
 	return hasTag(r.Targs, "failover")
 }
 
+// Snapshot returns a copy of the relay's name, listeners, destinations,
+// proxy flag and tags which is safe to read while the relay is changed.
+// The copy can not be served.
+func (r *Relay) Snapshot() Relay {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	tags := make(map[string]struct{}, len(r.Targs))
+	for tag := range r.Targs {
+		tags[tag] = struct{}{}
+	}
+
+	return Relay{
+		Name:         r.Name,
+		Listener:     r.Listener,
+		Listeners:    append([]TargetLink(nil), r.Listeners...),
+		Destination:  append([]TargetLink(nil), r.Destination...),
+		ProxyEnabled: r.ProxyEnabled,
+		Targs:        tags,
+	}
+}
+
 // Close will close the relay's listener
 func (r *Relay) Close() error {
-	return r.close.Close()
+	r.m.Lock()
+	closer := r.close
+	r.m.Unlock()
+
+	if closer == nil {
+		return nil
+	}
+
+	return closer.Close()
 }
 
-// ListenServe will start a listener and handle the incoming requests
+// ListenServe will start the relay's listeners and handle the incoming
+// requests. ListenServe can be called again once it has returned.
 func (r *Relay) ListenServe() error {
+	if err := r.begin(true); err != nil {
+		return err
+	}
+
+	for restarting := false; ; restarting = true {
+		restart, err := r.listenServe(restarting)
+		if !restart {
+			return err
+		}
+	}
+}
+
+// listenServe opens the listeners and serves until they are closed,
+// restart is true if they were closed by Restart. The start and stop
+// hooks are not called when the listeners are reopened by a restart.
+func (r *Relay) listenServe(restarting bool) (restart bool, err error) {
 	defer func() {
-		r.logger.Info.Printf("STOPPING: %q on %q\n", r.Name, r.Listener)
-		r.setRunning(false)
-	}()
+		if restart = r.stopped(err); restart {
+			return
+		}
 
-	r.setRunning(true)
+		r.Logger().Info("relay stopped", "listener", r.Listener)
+		r.Hooks().stop(r, err)
+	}()
 
-	r.logger.Info.Printf("STARTING: %q on %q\n", r.Name, r.Listener)
+	r.Logger().Info("relay starting", "listener", r.Listener)
 
-	l, err := listener(r)
+	addrs, err := r.listenAddrs()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	switch r.Listener.ProxyType() {
-	case ProxyTCP:
-		r.close = l
+	listeners := make(listenerGroup, 0, len(addrs))
+	for _, addr := range addrs {
+		l, err := listen(addr.link)
+		if err != nil {
+			listeners.Close()
+			return false, err
+		}
 
-		return relayTCP(r, l)
-	case ProxyUDP:
-		r.close = l
+		listeners = append(listeners, l)
+	}
 
-		return relayUDP(r, l)
-	case ProxyHTTP:
-		r.close = l
+	r.listening(listeners)
+	if !restarting {
+		r.Hooks().start(r)
+	}
 
-		return relayHTTP(r, l)
-	case ProxyHTTPS:
-		r.close = l
+	errs := make(chan error, len(listeners))
+	for i, l := range listeners {
+		go func(l net.Listener, offset int) {
+			errs <- r.serve(l, offset)
+		}(l, addrs[i].offset)
+	}
 
-		return relayHTTPS(r, l)
-	default:
-		l.Close()
+	// once any listener stops, stop them all
+	for range listeners {
+		if serr := <-errs; serr != nil && err == nil {
+			err = serr
+		}
 
-		return ErrUnknownProxyType
+		listeners.Close()
 	}
+
+	return false, err
 }
 
-// Serve lets you set your own listener and then serve on it
-func (r *Relay) Serve(l net.Listener) error {
+// Serve lets you set your own listener and then serve on it. Relays
+// started by Serve can not be restarted.
+func (r *Relay) Serve(l net.Listener) (err error) {
+	if err := r.begin(false); err != nil {
+		return err
+	}
+
 	defer func() {
-		r.logger.Info.Printf("STOPPING: %q on %q\n", r.Name, r.Listener)
-		r.setRunning(false)
+		r.Logger().Info("relay stopped", "listener", r.Listener)
+		r.stopped(err)
+		r.Hooks().stop(r, err)
 	}()
 
-	r.setRunning(true)
+	r.Logger().Info("relay starting", "listener", r.Listener)
+	r.listening(l)
+	r.Hooks().start(r)
 
-	r.logger.Info.Printf("STARTING: %q on %q\n", r.Name, r.Listener)
-	r.close = l
+	return r.serve(l, 0)
+}
 
+// serve handles the connections of a single listener. offset is the
+// position of the listener's port within its port range.
+func (r *Relay) serve(l net.Listener, offset int) error {
 	switch r.Listener.ProxyType() {
-	case ProxyTCP:
-		return relayTCP(r, l)
+	case ProxyTCP, ProxyUnix:
+		return relayTCP(r, l, offset)
 	case ProxyUDP:
-		return relayUDP(r, l)
+		return relayUDP(r, l, offset)
 	case ProxyHTTP:
 		return relayHTTP(r, l)
 	case ProxyHTTPS:
 		return relayHTTPS(r, l)
 	default:
-		return ErrUnknownProxyType
-	}
-}
-
-// storeConn places the provided net.Conn into the connPoll.
-// To remove this conn from the pool, provide it to popConn()
-func (r *Relay) storeConn(conn net.Conn) {
-	r.m.Lock()
-	defer r.m.Unlock()
-
-	r.connPool = append(r.connPool, &PooledConn{conn, "\x1b[92mdialing\x1b[0m", time.Now()})
-}
-
-// popConn removes the provided connection from the conn pool
-func (r *Relay) popConn(conn net.Conn) {
-	r.m.Lock()
-	defer r.m.Unlock()
-
-	for i := 0; i < len(r.connPool); i++ {
-		if r.connPool[i].Conn == conn {
-			// remove conn
-			r.connPool = append(r.connPool[:i], r.connPool[i+1:]...)
-			return
-		}
-	}
-}
-
-// setConnRemote will update the conn pool with the remote
-func (r *Relay) setConnRemote(conn net.Conn, remote net.Addr) {
-	r.m.Lock()
-	defer r.m.Unlock()
+		l.Close()
 
-	for i := 0; i < len(r.connPool); i++ {
-		if r.connPool[i].Conn == conn {
-			// remove conn
-			r.connPool[i].RemoteAddr = remote.String()
-			return
-		}
+		return ErrUnknownProxyType
 	}
 }
 
-// GetConns returns all the active connections to this relay
-func (r *Relay) GetConns() []*PooledConn {
-	r.m.Lock()
-	defer r.m.Unlock()
-
-	return r.connPool
-}
-
 func NewProxyURL(u *url.URL) ProxyURL {
 	return ProxyURL{u}
 }
@@ -1,6 +1,8 @@
 package localrelay
 
 import (
+	"bytes"
+	"crypto/rand"
 	"io"
 	"net"
 	"sync"
@@ -9,26 +11,23 @@ import (
 )
 
 func TestConnPoolBasic(t *testing.T) {
-	conns := []net.Conn{}
+	conns := []*trackedConn{}
 	connAmount := 50
-	relay, err := New("test-relay", io.Discard, "127.0.0.1:23832", "127.0.0.1:23838")
+	relay, err := New("test-relay", io.Discard, "tcp://127.0.0.1:23832", "tcp://127.0.0.1:23838")
 	if err != nil {
 		t.Error(err)
 	}
 
 	for i := 0; i < connAmount; i++ {
-		conn := &net.TCPConn{}
-
-		conns = append(conns, conn)
-		relay.storeConn(conn)
+		conns = append(conns, relay.storeConn(&net.TCPConn{}))
 	}
 
 	for i := 0; i < connAmount; i++ {
 		relay.popConn(conns[i])
 	}
 
-	if len(relay.connPool) != 0 {
-		t.Fatal("connPool is not empty")
+	if len(relay.conns) != 0 {
+		t.Fatal("conn registry is not empty")
 	}
 }
 
@@ -42,7 +41,7 @@ func TestConnPool(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	relay, err := New("test-relay", io.Discard, "127.0.0.1:23838", "127.0.0.1:23838")
+	relay, err := New("test-relay", io.Discard, "tcp://127.0.0.1:23838", "tcp://127.0.0.1:23838")
 	if err != nil {
 		t.Error(err)
 	}
@@ -58,27 +57,25 @@ func TestConnPool(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		relay.storeConn(conn)
+		tc := relay.storeConn(conn)
 
 		// handle conn
-		go func(conn net.Conn, i int) {
+		go func(conn net.Conn, tc *trackedConn, i int) {
 			for {
 				time.Sleep(time.Millisecond * (10 * time.Duration(i)))
 				_, err := conn.Write([]byte("test"))
 				if err != nil {
-					relay.popConn(conn)
+					relay.popConn(tc)
 
-					for _, c := range relay.connPool {
-						if c.Conn == conn {
-							t.Fatal("correct conn was not removed")
-						}
+					if _, found := relay.GetConn(tc.id); found {
+						t.Error("correct conn was not removed")
 					}
 
 					wg.Done()
 					return
 				}
 			}
-		}(conn, i)
+		}(conn, tc, i)
 	}
 
 	wg.Wait()
@@ -117,3 +114,94 @@ func startTCPServer(errCh chan error) {
 		}(conn)
 	}
 }
+
+func TestRelayLargeTransfer(t *testing.T) {
+	_, addr := startTestRelay(t, TargetLink("tcp://"+startEchoServer(t)))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	payload := make([]byte, 4<<20)
+	rand.Read(payload)
+
+	go conn.Write(payload)
+
+	echoed := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, echoed); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(payload, echoed) {
+		t.Fatal("payload was corrupted by the relay")
+	}
+}
+
+func BenchmarkRelayThroughput(b *testing.B) {
+	// an idle timeout requires the relay to observe each read which
+	// forces the buffered copy path
+	b.Run("buffered", func(b *testing.B) {
+		benchmarkRelayThroughput(b, Timeouts{Idle: time.Hour})
+	})
+
+	b.Run("default", func(b *testing.B) {
+		benchmarkRelayThroughput(b, Timeouts{})
+	})
+}
+
+func benchmarkRelayThroughput(b *testing.B, timeouts Timeouts) {
+	sink, received := startSinkServer(b)
+	relay, addr := startTestRelay(b, TargetLink("tcp://"+sink))
+	relay.SetTimeouts(timeouts)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	payload := make([]byte, 1<<20)
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	conn.Close()
+
+	// wait for all data to pass through the relay
+	if n := <-received; n != int64(b.N*len(payload)) {
+		b.Fatalf("sink received %d bytes, expected %d", n, b.N*len(payload))
+	}
+}
+
+// startSinkServer discards all data and reports how many bytes were
+// received once the first connection closes
+func startSinkServer(t testing.TB) (string, chan int64) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan int64, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		n, _ := io.Copy(io.Discard, conn)
+		conn.Close()
+
+		received <- n
+	}()
+
+	return l.Addr().String(), received
+}
@@ -13,27 +13,31 @@ var (
 	Timeout = time.Second * 5
 )
 
-func dial(r *Relay, conn net.Conn, remoteAddress string, i int, network string, start time.Time) error {
-	r.logger.Info.Printf("DIALLING FORWARD ADDRESS [%d]\n", i+1)
+func dial(r *Relay, tc *trackedConn, destination TargetLink, i int) error {
+	tc.log.Debug("dialling destination", "destination", destination, "attempt", i+1)
 
-	c, err := net.DialTimeout(network, remoteAddress, Timeout)
+	event := DialEvent{ConnID: tc.id, Destination: destination}
+	r.Hooks().dialAttempt(r, event)
+
+	start := time.Now()
+
+	c, err := net.DialTimeout(destination.Protocol(), destination.Addr(), Timeout)
+	r.Hooks().dialed(r, event, start, err)
 	if err != nil {
-		r.Metrics.dial(0, 1, start)
+		r.Metrics.dial(destination, "", false, start)
 
-		r.logger.Error.Printf("DIAL FORWARD ADDR: %s\n", err)
+		tc.log.Warn("dial failed", "destination", destination, "error", err)
 		return ErrFailConnect
 	}
 
-	r.setConnRemote(conn, c.RemoteAddr())
-
-	r.Metrics.dial(1, 0, start)
+	tc.connected(c.RemoteAddr(), destination, "", r.Metrics.dial(destination, "", true, start))
 
-	r.logger.Info.Printf("CONNECTED TO %s\n", remoteAddress)
-	err = streamConns(conn, c, r.Metrics)
+	tc.log.Info("connected", "destination", destination, "latency", time.Since(start))
+	err = streamConns(r, tc, c)
 	if err != nil {
-		r.logger.Error.Printf("STREAM ERROR %q for %q\n", err, conn.RemoteAddr())
+		tc.log.Error("stream failed", "error", err)
 	}
 
-	r.logger.Info.Printf("CONNECTION CLOSED %q ON %q\n", conn.RemoteAddr(), conn.LocalAddr())
+	tc.closedLog()
 	return nil
 }
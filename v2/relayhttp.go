@@ -8,13 +8,20 @@ import (
 	"time"
 
 	"github.com/go-compile/localrelay/internal/httperror"
+	"github.com/pkg/errors"
 )
 
 func relayHTTP(r *Relay, l net.Listener) error {
 
-	r.logger.Info.Println("STARTING HTTP RELAY")
+	r.Logger().Debug("serving http")
 
-	return r.httpServer.Serve(l)
+	err := r.server().Serve(&aclListener{l, r})
+	if errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed) {
+		// the relay was closed or shutdown
+		return nil
+	}
+
+	return err
 }
 
 // HandleHTTP is to be used as the HTTP relay's handler set in the
@@ -22,21 +29,36 @@ func relayHTTP(r *Relay, l net.Listener) error {
 func HandleHTTP(relay *Relay) http.HandlerFunc {
 	// Forwards relay object to request handler
 	return func(w http.ResponseWriter, r *http.Request) {
-		handleHTTP(w, r, relay)
+		if relay.AccessLog() == nil {
+			handleHTTP(w, r, relay)
+			return
+		}
+
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+
+		handleHTTP(rec, r, relay)
+		relay.logRequest(r, rec, start)
 	}
 }
 
 func handleHTTP(w http.ResponseWriter, r *http.Request, re *Relay) {
 	re.Metrics.requests(1)
 
-	destination := re.Destination[0]
+	destinations := re.activeDestinations()
+	if len(destinations) == 0 {
+		serviceUnavaliable(w, r)
+		return
+	}
+
+	destination := destinations[0]
 
 	remoteURL := destination.Protocol() + "://" + destination.Addr() + r.URL.Path + "?" + r.URL.Query().Encode()
 
 	// BUG: sometimes requests redirect and cause a loop (Loop is auto stopped)
 	req, err := http.NewRequest(r.Method, remoteURL, r.Body)
 	if err != nil {
-		re.logger.Error.Println("BUILD REQUEST ERROR: ", err)
+		re.Logger().Error("building request failed", "client", r.RemoteAddr, "error", err)
 		serviceUnavaliable(w, r)
 		return
 	}
@@ -48,33 +70,30 @@ func handleHTTP(w http.ResponseWriter, r *http.Request, re *Relay) {
 		req.Header.Set(k, strings.Join(v, ","))
 	}
 
-	// used to record dial time
-	start := time.Now()
-
 	// clone http client, as to not cause a race condition when we apply a proxy
 	hclient := cloneHttpClient(*re.httpClient)
 
 	proxyStrings, proxyNames, err := destination.Proxy(re)
 	if err != nil {
-		re.logger.Error.Printf("destination proxy error: %s\n", err)
+		re.Logger().Error("proxy referenced but not defined", "destination", destination, "error", err)
 		serviceUnavaliable(w, r)
 		return
 	}
 
 	if len(proxyNames) == 0 {
-		if !forwardHttp(&hclient, re, req, w, start) {
+		if !forwardHttp(&hclient, re, r, req, w, destination, "") {
 			serviceUnavaliable(w, r)
 		}
 
 		return
 	}
 
-	for _, proxyString := range proxyStrings {
+	for i, proxyString := range proxyStrings {
 		hclient.Transport = &http.Transport{
 			Proxy: http.ProxyURL(proxyString.URL),
 		}
 
-		if forwardHttp(&hclient, re, req, w, start) {
+		if forwardHttp(&hclient, re, r, req, w, destination, proxyNames[i]) {
 			// success
 			return
 		}
@@ -83,15 +102,22 @@ func handleHTTP(w http.ResponseWriter, r *http.Request, re *Relay) {
 	serviceUnavaliable(w, r)
 }
 
-func forwardHttp(hclient *http.Client, re *Relay, req *http.Request, w http.ResponseWriter, start time.Time) bool {
+// forwardHttp sends req to the destination and writes the response for
+// the client's request
+func forwardHttp(hclient *http.Client, re *Relay, client, req *http.Request, w http.ResponseWriter, destination TargetLink, proxy string) bool {
+	// used to record dial time
+	start := time.Now()
+
 	response, err := hclient.Do(req)
 	if err != nil {
-		re.logger.Error.Println("FORWARD REQUEST ERROR: ", err)
-		re.Metrics.dial(0, 1, start)
+		re.Logger().Warn("forwarding request failed", "destination", destination, "proxy", proxy, "error", err)
+		re.Metrics.dial(destination, proxy, false, start)
 		return false
 	}
 
-	re.Metrics.dial(1, 0, start)
+	// the response headers have been received
+	re.Metrics.firstByte(start)
+	stats := re.Metrics.dial(destination, proxy, true, start)
 
 	defer response.Body.Close()
 
@@ -102,9 +128,24 @@ func forwardHttp(hclient *http.Client, re *Relay, req *http.Request, w http.Resp
 
 	w.WriteHeader(response.StatusCode)
 
+	// failed logins count towards a ban
+	if response.StatusCode == http.StatusUnauthorized {
+		if ip, _, err := net.SplitHostPort(client.RemoteAddr); err == nil {
+			re.Report(ip, OffenseAuth)
+		}
+	}
+
 	in, _ := io.Copy(w, response.Body)
 	re.Metrics.bandwidth(0, int(in))
 
+	for _, t := range stats {
+		if req.ContentLength > 0 {
+			t.bandwidth(int(req.ContentLength), true)
+		}
+
+		t.bandwidth(int(in), false)
+	}
+
 	return true
 }
 
@@ -2,10 +2,19 @@ package localrelay
 
 import (
 	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
 )
 
 func relayHTTPS(r *Relay, l net.Listener) error {
-	r.logger.Info.Println("STARTING HTTPS RELAY")
+	r.Logger().Debug("serving https")
+
+	err := r.server().ServeTLS(&aclListener{l, r}, r.certificateFile, r.keyFile)
+	if errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed) {
+		// the relay was closed or shutdown
+		return nil
+	}
 
-	return r.httpServer.ServeTLS(l, r.certificateFile, r.keyFile)
+	return err
 }
@@ -2,7 +2,6 @@ package localrelay
 
 import (
 	"io"
-	"log"
 	"net"
 	"sync"
 	"time"
@@ -10,14 +9,17 @@ import (
 	"github.com/pkg/errors"
 )
 
-func listener(r *Relay) (net.Listener, error) {
+func listen(t TargetLink) (net.Listener, error) {
 
 	network := "tcp"
-	if r.Listener.ProxyType() == ProxyUDP {
+	switch t.ProxyType() {
+	case ProxyUDP:
 		network = "udp"
+	case ProxyUnix:
+		return unixListener(t)
 	}
 
-	l, err := net.Listen(network, r.Listener.Addr())
+	l, err := net.Listen(network, t.Addr())
 	if err != nil {
 		return nil, err
 	}
@@ -25,101 +27,122 @@ func listener(r *Relay) (net.Listener, error) {
 	return l, nil
 }
 
-func relayTCP(r *Relay, l net.Listener) error {
-	r.logger.Info.Println("STARTING TCP RELAY")
+func relayTCP(r *Relay, l net.Listener, offset int) error {
+	r.Logger().Debug("serving tcp")
 
 	for {
 		conn, err := l.Accept()
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
-				r.logger.Warning.Println("LISTENER CLOSED")
+				r.Logger().Debug("listener closed")
 				return nil
 			}
 
-			r.logger.Warning.Println("ACCEPT FAILED: ", err)
+			r.Logger().Warn("accept failed", "error", err)
 			continue
 		}
 
-		go handleConn(r, conn, "tcp")
+		if !r.permit(conn) {
+			continue
+		}
+
+		go handleConn(r, conn, "tcp", offset)
 	}
 }
 
-func handleConn(r *Relay, conn net.Conn, network string) {
-	r.storeConn(conn)
+// handleConn relays the connection to the first destination which can be
+// dialled. offset is the position of the listener's port within its range.
+func handleConn(r *Relay, conn net.Conn, network string, offset int) {
+	tc := r.storeConn(conn)
 
 	defer func() {
 		conn.Close()
 
-		// remove conn from connPool
-		r.popConn(conn)
+		// remove conn from the registry
+		r.popConn(tc)
 
 		r.Metrics.connections(-1)
+		r.Metrics.duration(time.Since(tc.opened))
+		r.logConn(tc)
 	}()
 
 	r.Metrics.connections(1)
 
-	r.logger.Info.Printf("NEW CONNECTION %q ON %q\n", conn.RemoteAddr(), conn.LocalAddr())
+	tc.log.Info("connection accepted", "listener", conn.LocalAddr().String())
+	r.Hooks().accept(r, tc)
 
-	start := time.Now()
-
-	destinationCandiates := make([]TargetLink, len(r.Destination))
-	copy(destinationCandiates, r.Destination)
+	// targets are kept in step with the candidates so each candidate's
+	// relay destination is known
+	targets := r.activeDestinations()
+	destinationCandiates := make([]TargetLink, len(targets))
+	for i := range targets {
+		destinationCandiates[i] = targets[i].atOffset(offset)
+	}
 
 	for i := 0; len(destinationCandiates) > 0; i++ {
 		di, destination, err := nextDestination(r, destinationCandiates)
 		if err != nil {
-			log.Fatal(err)
+			tc.log.Error("choosing destination failed", "error", err)
+
+			tc.setReason(CloseError)
+			r.Metrics.closed(CloseError)
+			return
 		}
 
+		tc.setTarget(targets[di])
+		targets = removeTargetlink(targets, di)
 		destinationCandiates = removeTargetlink(destinationCandiates, di)
+		r.Hooks().destination(r, DestinationEvent{ConnID: tc.id, Destination: destination, Attempt: i + 1})
 
 		// Retrieve proxy config for destination
 		proxies, proxyNames, err := destination.Proxy(r)
 		if err != nil {
-			r.logger.Error.Printf("A PROXY FOR DESTINATION %q WAS REFERENCED BUT NOT DEFINED\n", destination)
+			tc.log.Error("proxy referenced but not defined", "destination", destination)
+
+			tc.setReason(CloseError)
+			r.Metrics.closed(CloseError)
 			return
 		}
 
 		// if no proxy is set direct dial
 		if proxies == nil {
-			r.logger.Info.Printf("DIALING REMOTE [%s]\n", destination)
-
-			if err := dial(r, conn, destination.Addr(), i, destination.Protocol(), start); err != nil {
-				r.logger.Info.Printf("FAILED DIALING REMOTE [%s]\n", destination)
+			if err := dial(r, tc, destination, i); err != nil {
 				// errored dialing, continue to try next destination
 				continue
 			}
 
-			r.logger.Info.Printf("CONNECTION CLOSED %q ON %q\n", conn.RemoteAddr(), conn.LocalAddr())
 			// close connection
 			return
 		}
 
 		// proxies are set for this destination
 		for pi, proxy := range proxies {
-			r.logger.Info.Printf("DIALLING DESTINATION [%d] ADDRESS [%s] THROUGH PROXY %q\n", i+1, destination, proxyNames[pi])
+			tc.log.Debug("dialling destination", "destination", destination, "proxy", proxyNames[pi], "attempt", i+1)
 
 			// Dial destination through proxy
+			event := DialEvent{ConnID: tc.id, Destination: destination, Proxy: proxyNames[pi]}
+			r.Hooks().dialAttempt(r, event)
+
+			start := time.Now()
 			c, err := proxy.Dialer().Dial(destination.Protocol(), destination.Addr())
+			r.Hooks().dialed(r, event, start, err)
 			if err != nil {
-				r.Metrics.dial(0, 1, start)
+				r.Metrics.dial(destination, proxyNames[pi], false, start)
 
-				r.logger.Error.Printf("FAILED TO DIAL DESTINATION ADDR: %s\n", err)
+				tc.log.Warn("dial failed", "destination", destination, "proxy", proxyNames[pi], "error", err)
 				// try next proxy
 				continue
 			}
 
-			r.setConnRemote(conn, c.RemoteAddr())
-
-			r.Metrics.dial(1, 0, start)
+			tc.connected(c.RemoteAddr(), destination, proxyNames[pi], r.Metrics.dial(destination, proxyNames[pi], true, start))
 
-			r.logger.Info.Printf("CONNECTED TO %s\n", destination)
-			err = streamConns(conn, c, r.Metrics)
+			tc.log.Info("connected", "destination", destination, "proxy", proxyNames[pi], "latency", time.Since(start))
+			err = streamConns(r, tc, c)
 			if err != nil {
-				r.logger.Error.Printf("STREAM ERROR %q for %q\n", err, conn.RemoteAddr())
+				tc.log.Error("stream failed", "error", err)
 			}
 
-			r.logger.Info.Printf("CONNECTION CLOSED %q ON %q\n", conn.RemoteAddr(), conn.LocalAddr())
+			tc.closedLog()
 			// close connection
 			return
 		}
@@ -175,146 +198,69 @@ func handleConn(r *Relay, conn net.Conn, network string) {
 	// 	}
 	// }
 
-	r.logger.Info.Printf("UNABLE TO MAKE A CONNECTION FROM %q TO %q\n", conn.RemoteAddr(), conn.LocalAddr())
+	tc.log.Warn("all destinations failed")
+
+	tc.setReason(CloseError)
+	r.Metrics.closed(CloseError)
 }
 
-func streamConns(client net.Conn, remote net.Conn, m *Metrics) error {
+// streamConns relays traffic between the client and remote until either
+// side closes or a timeout is reached. The close reason is recorded on tc.
+func streamConns(r *Relay, tc *trackedConn, remote net.Conn) error {
+	timeouts := r.Timeouts()
+	setKeepAlive(tc.conn, timeouts.KeepAlive)
+	setKeepAlive(remote, timeouts.KeepAlive)
+
+	stop := watchdog(tc, remote, timeouts)
+	defer stop()
+
+	tc.capture = r.startCapture(tc)
+
+	// splice is only used when the relay does not need to observe each read
+	splice := canSplice(tc.conn, remote) && timeouts.Idle <= 0 && tc.capture == nil
+
 	wg := sync.WaitGroup{}
 
 	var copyInErr error
 
 	wg.Add(1)
 	go func() {
-		copyInErr = copierIn(client, remote, 128, m)
+		copyInErr = copier(tc.conn, remote, false, splice, r, tc)
+		tc.setState(ConnClosing)
 		wg.Done()
 	}()
 
 	wg.Add(1)
-	err := copierOut(client, remote, 128, m)
+	err := copier(remote, tc.conn, true, splice, r, tc)
+	tc.setState(ConnClosing)
 	wg.Done()
 
+	// wait for both directions to finish, one side may have only half
+	// closed the connection and still be sending data
 	wg.Wait()
 
+	tc.conn.Close()
+	remote.Close()
+
 	// if error is reporting that the conn is closed ignore both
 	if errors.Is(copyInErr, io.EOF) || errors.Is(err, io.EOF) || errors.Is(copyInErr, net.ErrClosed) {
 		// if any of the errors are EOFs or ErrClosed we are not
 		//  bothered with any additional errors.
-		return nil
+		err = nil
 	}
 
-	// else propagate error
-	return err
-}
-
-// NOTE: static function for maximum performance
-func copierIn(client net.Conn, dst net.Conn, buffer int, m *Metrics) error {
-
-	buf := make([]byte, buffer)
-	for {
-		n, err := dst.Read(buf)
-		m.bandwidth(0, n)
-		if err != nil {
-
-			var err1 error
-			// if we read some data, flush it then return a error
-			if n > 0 {
-				_, err1 = dst.Write(buf[:n])
-			}
-
-			err2 := client.Close()
-			err3 := dst.Close()
-
-			// wrap all errors into one
-			if err1 != nil {
-				err = errors.Wrap(err, err1.Error())
-			}
-
-			// wrap all errors into one
-			if err2 != nil {
-				err = errors.Wrap(err, err2.Error())
-			}
-
-			// wrap all errors into one
-			if err3 != nil {
-				err = errors.Wrap(err, err3.Error())
-			}
-
-			return errors.WithStack(err)
-		}
-
-		if n2, err := client.Write(buf[:n]); err != nil || n2 != n {
-			err1 := client.Close()
-			err2 := dst.Close()
-
-			// wrap all errors into one
-			if err1 != nil {
-				err = errors.Wrap(err, err1.Error())
-			}
-
-			// wrap all errors into one
-			if err2 != nil {
-				err = errors.Wrap(err, err2.Error())
-			}
-
-			return errors.WithStack(err)
-		}
+	if err != nil {
+		tc.setReason(CloseError)
+	} else {
+		tc.setReason(CloseEOF)
 	}
-}
-
-// NOTE: static function for maximum performance
-func copierOut(client net.Conn, dst net.Conn, buffer int, m *Metrics) error {
-
-	buf := make([]byte, buffer)
-	for {
-
-		n, err := client.Read(buf)
-		m.bandwidth(n, 0)
-		if err != nil {
-
-			var err1 error
-			// if we read some data, flush it then return a error
-			if n > 0 {
-				_, err1 = dst.Write(buf[:n])
-			}
 
-			err2 := client.Close()
-			err3 := dst.Close()
+	tc.endCapture()
+	r.Metrics.closed(tc.closeReason())
+	r.Hooks().streamEnd(r, tc, err)
 
-			// wrap all errors into one
-			if err1 != nil {
-				err = errors.Wrap(err, err1.Error())
-			}
-
-			// wrap all errors into one
-			if err2 != nil {
-				err = errors.Wrap(err, err2.Error())
-			}
-
-			// wrap all errors into one
-			if err3 != nil {
-				err = errors.Wrap(err, err3.Error())
-			}
-
-			return errors.WithStack(err)
-		}
-
-		if n2, err := dst.Write(buf[:n]); err != nil || n2 != n {
-			err1 := client.Close()
-			err2 := dst.Close()
-
-			// wrap all errors into one
-			if err1 != nil {
-				err = errors.Wrap(err, err1.Error())
-			}
-
-			// wrap all errors into one
-			if err2 != nil {
-				err = errors.Wrap(err, err2.Error())
-			}
-
-			return errors.WithStack(err)
-		}
-	}
+	// else propagate error
+	return err
 }
 
 func removeTargetlink(slice []TargetLink, s int) []TargetLink {
@@ -5,22 +5,26 @@ import (
 	"net"
 )
 
-func relayUDP(r *Relay, l net.Listener) error {
+func relayUDP(r *Relay, l net.Listener, offset int) error {
 
-	r.logger.Info.Println("STARTING UDP RELAY")
+	r.Logger().Debug("serving udp")
 
 	for {
 		conn, err := l.Accept()
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
-				r.logger.Warning.Println("LISTENER CLOSED")
+				r.Logger().Debug("listener closed")
 				return nil
 			}
 
-			r.logger.Warning.Println("ACCEPT FAILED: ", err)
+			r.Logger().Warn("accept failed", "error", err)
 			continue
 		}
 
-		go handleConn(r, conn, "udp")
+		if !r.permit(conn) {
+			continue
+		}
+
+		go handleConn(r, conn, "udp", offset)
 	}
 }
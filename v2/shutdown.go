@@ -0,0 +1,78 @@
+package localrelay
+
+import "context"
+
+// Shutdown gracefully stops the relay. The listeners are closed so no new
+// connections are accepted, then Shutdown waits for the active connections
+// to close. HTTP relays wait for their active requests instead. Once the
+// context is done the remaining connections are closed and the context's
+// error is returned. The relay is draining until Shutdown returns.
+func (r *Relay) Shutdown(ctx context.Context) error {
+	r.Logger().Info("relay shutting down", "connections", r.activeConns())
+
+	srv := r.drain()
+	defer r.drained()
+
+	if srv != nil {
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			// force close the requests which did not finish in time
+			srv.Close()
+		}
+
+		r.Close()
+		return err
+	}
+
+	r.Close()
+
+	for {
+		idle := r.idleConns()
+		if idle == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			r.closeConns(CloseShutdown)
+			return ctx.Err()
+		case <-idle:
+		}
+	}
+}
+
+// idleConns returns a channel which is closed once the relay has no
+// active connections, nil is returned if it already has none
+func (r *Relay) idleConns() <-chan struct{} {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if len(r.conns) == 0 {
+		return nil
+	}
+
+	if r.idle == nil {
+		r.idle = make(chan struct{})
+	}
+
+	return r.idle
+}
+
+// activeConns returns the amount of connections being relayed
+func (r *Relay) activeConns() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return len(r.conns)
+}
+
+// closeConns closes every active connection recording the reason
+func (r *Relay) closeConns(reason CloseReason) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, tc := range r.conns {
+		tc.setReason(reason)
+		tc.conn.Close()
+	}
+}
@@ -0,0 +1,140 @@
+package localrelay
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// dialRelay connects to a relay and waits for the destination to reply
+func dialRelay(t *testing.T, addr string) net.Conn {
+	t.Helper()
+
+	var conn net.Conn
+	var err error
+	waitFor(t, func() bool {
+		conn, err = net.Dial("tcp", addr)
+		return err == nil
+	})
+
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	conn.Write([]byte("ping"))
+
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func TestShutdownDrains(t *testing.T) {
+	addr := "127.0.0.1:" + strconv.Itoa(freePortRange(t, 1))
+
+	relay, err := New("test-relay", io.Discard, TargetLink("tcp://"+addr), TargetLink("tcp://"+startEchoServer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go relay.ListenServe()
+
+	conn := dialRelay(t, addr)
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		done <- relay.Shutdown(ctx)
+	}()
+
+	waitFor(t, func() bool { return !relay.Running() })
+
+	if _, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		t.Fatal("expected new connections to be refused")
+	}
+
+	// the established connection keeps working while draining
+	conn.Write([]byte("ping"))
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatalf("draining closed the connection: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown returned before the connection closed: %v", err)
+	default:
+	}
+
+	conn.Close()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	addr := "127.0.0.1:" + strconv.Itoa(freePortRange(t, 1))
+
+	relay, err := New("test-relay", io.Discard, TargetLink("tcp://"+addr), TargetLink("tcp://"+startEchoServer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go relay.ListenServe()
+
+	conn := dialRelay(t, addr)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	if err := relay.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded got %v", err)
+	}
+
+	// the connection outlived the deadline so was closed
+	if _, err := io.ReadFull(conn, make([]byte, 1)); err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+
+	waitFor(t, func() bool { return relay.Metrics.Snapshot().CloseReasons[CloseShutdown] == 1 })
+}
+
+func TestShutdownHTTPServeAgain(t *testing.T) {
+	addr := "127.0.0.1:" + strconv.Itoa(freePortRange(t, 1))
+
+	relay, err := New("test-relay", io.Discard, TargetLink("http://"+addr), "http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := relay.SetHTTP(&http.Server{Handler: HandleHTTP(relay)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// each serve replaces the shutdown server while Shutdown may read it
+	for i := 0; i < 3; i++ {
+		served := make(chan error, 1)
+		go func() { served <- relay.ListenServe() }()
+
+		waitFor(t, relay.Running)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		err := relay.Shutdown(ctx)
+		cancel()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := <-served; err != nil {
+			t.Fatalf("serve %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build linux
+// +build linux
+
+package localrelay
+
+// spliceSupported enables the zero copy path using splice(2)
+const spliceSupported = true
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package localrelay
+
+// spliceSupported is false as net.TCPConn.ReadFrom would fall back to
+// allocating a new buffer for every chunk
+const spliceSupported = false
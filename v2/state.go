@@ -0,0 +1,228 @@
+package localrelay
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RelayState is the stage of a relay's lifecycle
+type RelayState string
+
+const (
+	// StateStopped relays are not listening
+	StateStopped RelayState = "stopped"
+	// StateStarting relays are opening their listeners
+	StateStarting RelayState = "starting"
+	// StateRunning relays are accepting connections
+	StateRunning RelayState = "running"
+	// StateDraining relays have closed their listeners and are waiting
+	// for their connections to finish
+	StateDraining RelayState = "draining"
+	// StateFailed relays stopped with an error
+	StateFailed RelayState = "failed"
+)
+
+var (
+	// ErrAlreadyRunning is returned when serving a relay which has not stopped
+	ErrAlreadyRunning = errors.New("relay is already running")
+	// ErrNotRunning is returned when restarting a relay which is not running
+	ErrNotRunning = errors.New("relay is not running")
+	// ErrNotRestartable is returned when restarting a relay which was
+	// started with Serve, its listener can not be reopened
+	ErrNotRestartable = errors.New("relay was not started by ListenServe")
+)
+
+// RelayStatus is a snapshot of a relay's lifecycle
+type RelayStatus struct {
+	State RelayState
+	// LastError is the error the relay last failed with
+	LastError error
+	// Started is when the relay last started listening
+	Started time.Time
+	// Restarts is how many times the relay has started listening again
+	Restarts int
+}
+
+// State returns the relay's lifecycle stage
+func (r *Relay) State() RelayState {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.state
+}
+
+// Status returns the relay's state, last error, start time and restarts
+func (r *Relay) Status() RelayStatus {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return RelayStatus{
+		State:     r.state,
+		LastError: r.lastErr,
+		Started:   r.started,
+		Restarts:  r.restarts,
+	}
+}
+
+// Running returns true if relay is accepting connections
+func (r *Relay) Running() bool {
+	return r.State() == StateRunning
+}
+
+// begin marks the relay as starting unless it is already being served
+func (r *Relay) begin(restartable bool) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	switch r.state {
+	case StateStarting, StateRunning, StateDraining:
+		return errors.Wrapf(ErrAlreadyRunning, "%q", r.Name)
+	}
+
+	r.state = StateStarting
+	r.restartable = restartable
+
+	// a http server can not serve again once it has been shutdown
+	if r.httpShutdown {
+		r.httpServer = cloneHTTPServer(r.httpServer)
+		r.httpShutdown = false
+	}
+
+	return nil
+}
+
+// listening marks the relay as running, closer stops its listeners
+func (r *Relay) listening(closer io.Closer) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if !r.started.IsZero() {
+		r.restarts++
+	}
+
+	r.close = closer
+	r.state = StateRunning
+	r.started = time.Now()
+	r.restartDone()
+}
+
+// stopped records why the relay stopped and returns true if it should
+// listen again because of a restart
+func (r *Relay) stopped(err error) (restart bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	switch {
+	case r.restart:
+		r.restart = false
+		r.state = StateStarting
+		return true
+	case err != nil:
+		r.state = StateFailed
+		r.lastErr = err
+	case r.state == StateDraining:
+		// Shutdown stops the relay once its connections have closed
+	default:
+		r.state = StateStopped
+	}
+
+	r.restartDone()
+	return false
+}
+
+// restartDone wakes Restart once the relay is listening again or stopped,
+// the caller must hold r.m
+func (r *Relay) restartDone() {
+	if r.restarted != nil {
+		close(r.restarted)
+		r.restarted = nil
+	}
+}
+
+// drain marks a running relay as draining and returns its HTTP server
+func (r *Relay) drain() *http.Server {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.httpServer != nil {
+		r.httpShutdown = true
+	}
+
+	if r.state == StateRunning {
+		r.state = StateDraining
+	}
+
+	return r.httpServer
+}
+
+// drained marks a draining relay as stopped
+func (r *Relay) drained() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.state == StateDraining {
+		r.state = StateStopped
+	}
+}
+
+// Restart closes the relay's listeners and opens them again. Established
+// connections are kept and ListenServe does not return. If the listeners
+// can not be opened again the relay fails with the returned error.
+func (r *Relay) Restart() error {
+	r.m.Lock()
+	if r.state != StateRunning {
+		r.m.Unlock()
+		return errors.Wrapf(ErrNotRunning, "%q", r.Name)
+	}
+
+	if !r.restartable {
+		r.m.Unlock()
+		return errors.Wrapf(ErrNotRestartable, "%q", r.Name)
+	}
+
+	r.restart = true
+	closer := r.close
+
+	restarted := r.restarted
+	if restarted == nil {
+		restarted = make(chan struct{})
+		r.restarted = restarted
+	}
+	r.m.Unlock()
+
+	r.Logger().Info("relay restarting", "listener", r.Listener)
+	closer.Close()
+
+	<-restarted
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.state == StateFailed {
+		return r.lastErr
+	}
+
+	return nil
+}
+
+// cloneHTTPServer copies the config of a server so it can serve again
+func cloneHTTPServer(srv *http.Server) *http.Server {
+	return &http.Server{
+		Addr:              srv.Addr,
+		Handler:           srv.Handler,
+		TLSConfig:         srv.TLSConfig,
+		ReadTimeout:       srv.ReadTimeout,
+		ReadHeaderTimeout: srv.ReadHeaderTimeout,
+		WriteTimeout:      srv.WriteTimeout,
+		IdleTimeout:       srv.IdleTimeout,
+		MaxHeaderBytes:    srv.MaxHeaderBytes,
+		TLSNextProto:      srv.TLSNextProto,
+		ConnState:         srv.ConnState,
+		ErrorLog:          srv.ErrorLog,
+		BaseContext:       srv.BaseContext,
+		ConnContext:       srv.ConnContext,
+	}
+}
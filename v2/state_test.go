@@ -0,0 +1,116 @@
+package localrelay
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRelayRestart(t *testing.T) {
+	addr := "127.0.0.1:" + strconv.Itoa(freePortRange(t, 1))
+
+	relay, err := New("test-relay", io.Discard, TargetLink("tcp://"+addr), TargetLink("tcp://"+startEchoServer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := relay.Restart(); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("expected ErrNotRunning got %v", err)
+	}
+
+	var starts, stops int32
+	relay.SetHooks(Hooks{
+		OnStart: func(r *Relay) { atomic.AddInt32(&starts, 1) },
+		OnStop:  func(r *Relay, err error) { atomic.AddInt32(&stops, 1) },
+	})
+
+	served := make(chan error, 1)
+	go func() { served <- relay.ListenServe() }()
+
+	conn := dialRelay(t, addr)
+	defer conn.Close()
+
+	if err := relay.ListenServe(); !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("expected ErrAlreadyRunning got %v", err)
+	}
+
+	started := relay.Status().Started
+	if err := relay.Restart(); err != nil {
+		t.Fatal(err)
+	}
+
+	status := relay.Status()
+	if status.State != StateRunning || status.Restarts != 1 || !status.Started.After(started) {
+		t.Fatalf("unexpected status after restart %+v", status)
+	}
+
+	// the established connection is kept and the listener accepts again
+	conn.Write([]byte("ping"))
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatalf("restart closed the connection: %v", err)
+	}
+
+	dialRelay(t, addr).Close()
+
+	// a restart does not start or stop the relay as far as hooks can tell
+	if started, stopped := atomic.LoadInt32(&starts), atomic.LoadInt32(&stops); started != 1 || stopped != 0 {
+		t.Fatalf("expected 1 start and 0 stops got %d and %d", started, stopped)
+	}
+
+	relay.Close()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+
+	if stops := atomic.LoadInt32(&stops); stops != 1 {
+		t.Fatalf("expected 1 stop got %d", stops)
+	}
+
+	if state := relay.State(); state != StateStopped {
+		t.Fatalf("expected stopped got %q", state)
+	}
+
+	// a stopped relay can be served again
+	go func() { served <- relay.ListenServe() }()
+	dialRelay(t, addr).Close()
+
+	relay.Close()
+	<-served
+
+	if restarts := relay.Status().Restarts; restarts != 2 {
+		t.Fatalf("expected 2 restarts got %d", restarts)
+	}
+}
+
+func TestRelayFailed(t *testing.T) {
+	// occupy the relay's address so listening fails
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	relay, err := New("test-relay", io.Discard, TargetLink("tcp://"+l.Addr().String()), "tcp://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = relay.ListenServe()
+	if err == nil {
+		t.Fatal("expected listening to fail")
+	}
+
+	status := relay.Status()
+	if status.State != StateFailed || status.LastError != err {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	if err := relay.Restart(); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("expected ErrNotRunning got %v", err)
+	}
+}
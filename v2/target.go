@@ -1,24 +1,316 @@
 package localrelay
 
 import (
-	"errors"
-	"log"
+	"container/list"
+	"net"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
 
 	"github.com/mroth/weightedrand"
 )
 
 var (
 	ErrProxyDefine = errors.New("proxy is not defined")
+
+	// ErrInvalidTarget is returned when a TargetLink can not be parsed
+	ErrInvalidTarget = errors.New("invalid target")
+	// ErrUnknownScheme is returned when a TargetLink's protocol is not supported
+	ErrUnknownScheme = errors.New("unknown protocol, expected tcp, udp, unix, http or https")
+	// ErrUnknownOption is returned when a TargetLink has an unrecognised query option
+	ErrUnknownOption = errors.New("unknown option")
+	// ErrInvalidOption is returned when a TargetLink's query option has an invalid value
+	ErrInvalidOption = errors.New("invalid option")
 )
 
+// targetOptions are the query options accepted for each protocol
+var targetOptions = map[ProxyType][]string{
+	ProxyTCP:   {"proxy", "lb", "lb_weight"},
+	ProxyUDP:   {"proxy", "lb", "lb_weight"},
+	ProxyHTTP:  {"proxy", "lb", "lb_weight"},
+	ProxyHTTPS: {"proxy", "lb", "lb_weight"},
+	ProxyUnix:  {"lb", "lb_weight", "mode", "owner", "group"},
+}
+
+// maxCachedTargets is how many parsed TargetLinks are kept, the least
+// recently used are evicted as destinations are added and reweighted
+const maxCachedTargets = 1024
+
+// targetCache holds the most recently parsed TargetLinks
+var targetCache = newTargetLRU(maxCachedTargets)
+
+type targetCacheEntry struct {
+	link   string
+	target *Target
+	err    error
+}
+
+// targetLRU is a least recently used cache of parsed TargetLinks
+type targetLRU struct {
+	m       sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newTargetLRU(size int) *targetLRU {
+	return &targetLRU{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// load returns the cached entry for link and marks it as recently used
+func (c *targetLRU) load(link string) (targetCacheEntry, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	e, found := c.entries[link]
+	if !found {
+		return targetCacheEntry{}, false
+	}
+
+	c.order.MoveToFront(e)
+	return e.Value.(targetCacheEntry), true
+}
+
+// store caches entry, evicting the least recently used once full
+func (c *targetLRU) store(entry targetCacheEntry) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if e, found := c.entries[entry.link]; found {
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[entry.link] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(targetCacheEntry).link)
+	}
+}
+
+// len returns the amount of cached targets
+func (c *targetLRU) len() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.order.Len()
+}
+
 type TargetLink string
 
+// Target is a parsed and validated TargetLink
+type Target struct {
+	Link     TargetLink
+	Protocol ProxyType
+
+	// Host is the hostname or IP, IPv6 addresses are not bracketed
+	Host string
+	// FirstPort and LastPort are equal unless a port range is used,
+	// both are 0 when no port was provided
+	FirstPort, LastPort int
+	// Path is the socket path of unix targets
+	Path string
+
+	// Proxies are the names of the proxies to dial through in order
+	Proxies  []string
+	Lb       bool
+	LbWeight uint
+
+	// Options are the target's query options
+	Options url.Values
+}
+
+// ParseTargetLink parses and validates a target such as
+// tcp://127.0.0.1:443, tcp://[::1]:9000-9010?proxy=tor or
+// unix:///run/app.sock. The result is cached, the returned Target is
+// shared and must not be modified.
+func ParseTargetLink(link string) (*Target, error) {
+	if entry, found := targetCache.load(link); found {
+		return entry.target, entry.err
+	}
+
+	target, err := parseTargetLink(TargetLink(link))
+	if err != nil {
+		err = errors.Wrapf(err, "target %q", link)
+	}
+
+	targetCache.store(targetCacheEntry{link, target, err})
+	return target, err
+}
+
+func parseTargetLink(t TargetLink) (*Target, error) {
+	target := &Target{
+		Link:     t,
+		Protocol: t.ProxyType(),
+		Lb:       true,
+		LbWeight: 100,
+	}
+
+	allowed, found := targetOptions[target.Protocol]
+	if !found {
+		return nil, ErrUnknownScheme
+	}
+
+	rest := string(t)[len(target.Protocol)+len("://"):]
+	rest, query, _ := strings.Cut(rest, "?")
+
+	options, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidTarget, err.Error())
+	}
+
+	target.Options = options
+
+	if target.Protocol == ProxyUnix {
+		// URL parsing can not be used as @ would be treated as user info
+		if rest == "" {
+			return nil, errors.Wrap(ErrInvalidTarget, "missing socket path")
+		}
+
+		target.Path = rest
+	} else if authority, _, _ := strings.Cut(rest, "/"); strings.Contains(authority, "@") {
+		// credentials are never sent to the destination, proxies have their own
+		return nil, errors.Wrap(ErrInvalidTarget, "user info is not supported")
+	} else if err := target.parseHost(); err != nil {
+		return nil, err
+	}
+
+	for key, values := range options {
+		if !contains(allowed, key) {
+			return nil, errors.Wrapf(ErrUnknownOption, "%q", key)
+		}
+
+		if err := target.parseOption(key, values[len(values)-1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return target, nil
+}
+
+// parseHost parses the host and port or port range of a network target
+func (target *Target) parseHost() error {
+	t := target.Link
+
+	first, last, err := t.splitPortRange()
+	if err != nil {
+		return err
+	}
+
+	// port ranges are not valid URLs so only the first port is parsed
+	link := string(t)
+	if first != last {
+		link = string(t.withPort(first))
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return errors.Wrap(ErrInvalidTarget, err.Error())
+	}
+
+	// without brackets the port of an IPv6 address is ambiguous
+	if strings.Count(u.Host, ":") > 1 && !strings.HasPrefix(u.Host, "[") {
+		return errors.Wrap(ErrInvalidTarget, "IPv6 addresses must be in brackets")
+	}
+
+	target.Host = u.Hostname()
+	target.FirstPort, target.LastPort = first, last
+
+	switch target.Protocol {
+	case ProxyTCP, ProxyUDP:
+		if u.Port() == "" {
+			return errors.Wrap(ErrInvalidTarget, "missing port")
+		}
+	case ProxyHTTP, ProxyHTTPS:
+		if target.Host == "" {
+			return errors.Wrap(ErrInvalidTarget, "missing host")
+		}
+	}
+
+	return nil
+}
+
+// parseOption validates a query option
+func (target *Target) parseOption(key, value string) error {
+	switch key {
+	case "proxy":
+		target.Proxies = strings.Split(value, ",")
+		for _, name := range target.Proxies {
+			if name == "" {
+				return errors.Wrapf(ErrInvalidOption, "proxy %q", value)
+			}
+		}
+	case "lb":
+		switch strings.ToLower(value) {
+		case "false", "off", "disabled", "inactive", "0", "no":
+			target.Lb = false
+		case "true", "on", "enabled", "active", "1", "yes", "":
+			target.Lb = true
+		default:
+			return errors.Wrapf(ErrInvalidOption, "lb %q", value)
+		}
+	case "lb_weight":
+		weight, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return errors.Wrapf(ErrInvalidOption, "lb_weight %q", value)
+		}
+
+		if weight == 0 {
+			return errors.Wrapf(ErrInvalidWeight, "lb_weight %q", value)
+		}
+
+		target.LbWeight = uint(weight)
+	case "mode":
+		if mode, err := strconv.ParseUint(value, 8, 32); err != nil || mode > 0777 {
+			return errors.Wrapf(ErrInvalidSocketMode, "%q", value)
+		}
+	case "owner", "group":
+		if value == "" {
+			return errors.Wrapf(ErrInvalidOption, "%s is empty", key)
+		}
+	}
+
+	return nil
+}
+
+// parsed returns the parsed target, or a Target holding only the protocol
+// and defaults if the link is invalid
+func (t *TargetLink) parsed() *Target {
+	target, err := ParseTargetLink(string(*t))
+	if err != nil {
+		return &Target{Link: *t, Protocol: t.ProxyType(), Lb: true, LbWeight: 100}
+	}
+
+	return target
+}
+
+// Validate returns an error describing why the target is invalid
+func (t *TargetLink) Validate() error {
+	_, err := ParseTargetLink(string(*t))
+	return err
+}
+
 func (t *TargetLink) String() string {
-	u, _ := url.Parse(string(*t))
-	return u.Host
+	if t.ProxyType() == ProxyUnix {
+		return t.Addr()
+	}
+
+	target := t.parsed()
+	if target.FirstPort == 0 {
+		return target.Host
+	}
+
+	return net.JoinHostPort(target.Host, strconv.Itoa(target.FirstPort))
 }
 
 // ProxyType returns the protocol as a ProxyType
@@ -27,25 +319,31 @@ func (t *TargetLink) ProxyType() ProxyType {
 }
 
 // Addr returns the address within the target link.
-// Example: 127.0.0.1:443
+// Example: 127.0.0.1:443 or [::1]:443
+//
+// For unix sockets the socket path is returned, abstract sockets are
+// prefixed with @.
+// Example: /var/run/docker.sock
 func (t *TargetLink) Addr() string {
-	u, _ := url.Parse(string(*t))
-	return u.Hostname() + ":" + t.Port()
+	if t.ProxyType() == ProxyUnix {
+		return t.parsed().Path
+	}
+
+	return net.JoinHostPort(t.Host(), t.Port())
 }
 
 // Host returns the host/ip of the target
 func (t *TargetLink) Host() string {
-	u, _ := url.Parse(string(*t))
-	return u.Hostname()
+	return t.parsed().Host
 }
 
-// Port returns the port number of the target
+// Port returns the port number of the target, for port ranges the first
+// port is returned
 func (t *TargetLink) Port() string {
-	u, _ := url.Parse(string(*t))
-
-	if len(u.Port()) > 0 {
-		return u.Port()
+	if _, ports, _ := t.splitPorts(); ports != "" {
+		return strconv.Itoa(t.parsed().FirstPort)
 	}
+
 	switch t.Protocol() {
 	case "https":
 		return "443"
@@ -56,26 +354,33 @@ func (t *TargetLink) Port() string {
 	}
 }
 
+// query returns the options of the target link
+func (t *TargetLink) query() url.Values {
+	return t.parsed().Options
+}
+
 // Protocol returns the protocol of the target
 func (t *TargetLink) Protocol() string {
-	u, _ := url.Parse(string(*t))
-	return strings.ToLower(u.Scheme)
+	// not parsed as a URL as port ranges are not valid URLs
+	scheme, _, found := strings.Cut(string(*t), "://")
+	if !found {
+		return ""
+	}
+
+	return strings.ToLower(scheme)
 }
 
 // Proxy parses the TargetLink and uses the relay to lookup proxy dialers.
 // The returned array is in the same order as written.
 func (t *TargetLink) Proxy(r *Relay) ([]ProxyURL, []string, error) {
-	u, _ := url.Parse(string(*t))
-
-	// get ?proxy=<value> from TargetLink and split into comma seperated array
-	proxieNames := strings.Split(u.Query().Get("proxy"), ",")
-	if len(proxieNames) == 0 || len(proxieNames[0]) == 0 {
+	proxieNames := t.parsed().Proxies
+	if len(proxieNames) == 0 {
 		return nil, nil, nil
 	}
 
 	proxies := make([]ProxyURL, len(proxieNames))
 	for i := 0; i < len(proxies); i++ {
-		proxy, found := r.proxies[proxieNames[i]]
+		proxy, found := r.proxy(proxieNames[i])
 		if !found {
 			return proxies, proxieNames, ErrProxyDefine
 		}
@@ -93,30 +398,22 @@ func (t *TargetLink) Print() string {
 
 // LbWeight returns the weight provided or the default value of 100
 func (t *TargetLink) LbWeight() uint {
-	u, _ := url.Parse(string(*t))
-	weight := u.Query().Get("lb_weight")
-
-	if weight == "" {
-		return 100
-	}
-
-	n, err := strconv.Atoi(weight)
-	if err != nil {
-		log.Fatalf("Weight could not be parsed for %s\n", t)
-	}
-
-	return uint(n)
+	return t.parsed().LbWeight
 }
 
 // Lb returns true if loadbalancing is enabled
 func (t *TargetLink) Lb() bool {
-	u, _ := url.Parse(string(*t))
-	switch strings.ToLower(u.Query().Get("lb")) {
-	case "false", "off", "disabled", "inactive", "0", "no":
-		return false
-	default:
-		return true
+	return t.parsed().Lb
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
 	}
+
+	return false
 }
 
 // nextDestination using the provided list of potential destinations, find the appripriate
@@ -124,7 +421,7 @@ func (t *TargetLink) Lb() bool {
 func nextDestination(r *Relay, dsts []TargetLink) (int, TargetLink, error) {
 	candiates := []TargetLink{}
 
-	if r.loadbalance.Enabled {
+	if r.loadbalanced() {
 		choices := []weightedrand.Choice{}
 		// Remove all non loadbalanced dsts
 		for i := 0; i < len(dsts); i++ {
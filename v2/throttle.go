@@ -0,0 +1,360 @@
+package localrelay
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limit is a bandwidth limit measured in bytes per second.
+// A value of zero disables the limit for that direction.
+type Limit struct {
+	// Upload limits traffic sent from the client to the destination
+	Upload int64
+	// Download limits traffic sent from the destination to the client
+	Download int64
+}
+
+// Limits holds the bandwidth and connection rate limits applied to a relay
+type Limits struct {
+	// Relay is shared between every connection on the relay
+	Relay Limit
+	// Conn is applied to each connection individually
+	Conn Limit
+	// IP is shared between all connections from the same client IP
+	IP Limit
+	// ConnRate is the amount of new connections each client IP may open
+	// per second, connections over the rate are rejected. Zero disables it.
+	ConnRate int64
+}
+
+// Enabled returns true if any limit has been set
+func (l Limit) Enabled() bool {
+	return l.Upload > 0 || l.Download > 0
+}
+
+// Enabled returns true if any limit has been set
+func (l Limits) Enabled() bool {
+	return l.bandwidth() || l.ConnRate > 0
+}
+
+// bandwidth returns true if any bandwidth limit has been set
+func (l Limits) bandwidth() bool {
+	return l.Relay.Enabled() || l.Conn.Enabled() || l.IP.Enabled()
+}
+
+// bucket is a token bucket which refills at rate bytes per second and
+// allows bursts of up to one second of traffic.
+type bucket struct {
+	m      sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate int64) *bucket {
+	return &bucket{
+		rate:   rate,
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// setRate changes the refill rate of the bucket
+func (b *bucket) setRate(rate int64) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.rate = rate
+	if b.tokens > float64(rate) {
+		b.tokens = float64(rate)
+	}
+}
+
+// take removes n tokens from the bucket and returns how long the caller
+// must wait for the bucket to pay back its debt.
+func (b *bucket) take(n int) time.Duration {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	now := time.Now()
+	if b.rate <= 0 {
+		b.last = now
+		return 0
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * float64(b.rate)
+	if b.tokens > float64(b.rate) {
+		b.tokens = float64(b.rate)
+	}
+
+	b.last = now
+	b.tokens -= float64(n)
+
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+}
+
+// allow removes n tokens from the bucket only if it holds enough of them,
+// unlike take a refused caller does not put the bucket into debt
+func (b *bucket) allow(n int) bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	now := time.Now()
+	if b.rate <= 0 {
+		b.last = now
+		return true
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * float64(b.rate)
+	if b.tokens > float64(b.rate) {
+		b.tokens = float64(b.rate)
+	}
+
+	b.last = now
+	if b.tokens < float64(n) {
+		return false
+	}
+
+	b.tokens -= float64(n)
+	return true
+}
+
+// idle returns true if the bucket has not been used for long enough to
+// have refilled, forgetting it loses no state
+func (b *bucket) idle(now time.Time) bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return now.Sub(b.last) >= time.Second
+}
+
+// limiter holds an upload and download bucket
+type limiter struct {
+	up, down *bucket
+}
+
+func newLimiter(l Limit) *limiter {
+	return &limiter{
+		up:   newBucket(l.Upload),
+		down: newBucket(l.Download),
+	}
+}
+
+func (l *limiter) set(limit Limit) {
+	l.up.setRate(limit.Upload)
+	l.down.setRate(limit.Download)
+}
+
+func (l *limiter) take(n int, upload bool) time.Duration {
+	if upload {
+		return l.up.take(n)
+	}
+
+	return l.down.take(n)
+}
+
+// ipLimiter is shared by all connections from a single client IP
+type ipLimiter struct {
+	*limiter
+	refs int
+}
+
+// maxConnRates is how many client IPs have their connection rate tracked
+// before idle IPs are forgotten
+const maxConnRates = 4096
+
+// throttle applies the relay, per IP and per connection limits
+type throttle struct {
+	// active counts the connections currently being delayed.
+	// Kept first for 64-bit alignment on 32-bit platforms.
+	active int64
+	// limited is set to 1 when any bandwidth limit is enabled
+	limited int32
+
+	m      sync.Mutex
+	limits Limits
+	relay  *limiter
+	ips    map[string]*ipLimiter
+	// connRates holds each client IP's new connection bucket
+	connRates map[string]*bucket
+}
+
+func newThrottle() *throttle {
+	return &throttle{
+		relay:     newLimiter(Limit{}),
+		ips:       make(map[string]*ipLimiter),
+		connRates: make(map[string]*bucket),
+	}
+}
+
+// allowConn returns false if the client IP has opened more new
+// connections than the connection rate permits
+func (t *throttle) allowConn(ip string) bool {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if t.limits.ConnRate <= 0 {
+		return true
+	}
+
+	b, ok := t.connRates[ip]
+	if !ok {
+		if len(t.connRates) >= maxConnRates {
+			t.pruneConnRates()
+		}
+
+		b = newBucket(t.limits.ConnRate)
+		t.connRates[ip] = b
+	}
+
+	return b.allow(1)
+}
+
+// pruneConnRates forgets the client IPs whose buckets have refilled. If
+// every IP is active the map is cleared so it can not grow unbounded.
+func (t *throttle) pruneConnRates() {
+	now := time.Now()
+	for ip, b := range t.connRates {
+		if b.idle(now) {
+			delete(t.connRates, ip)
+		}
+	}
+
+	if len(t.connRates) >= maxConnRates {
+		t.connRates = make(map[string]*bucket)
+	}
+}
+
+// acquire returns the limiters for a new connection from addr
+func (t *throttle) acquire(addr net.Addr) (conn *limiter, ip *limiter) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	host := addrHost(addr)
+
+	l, ok := t.ips[host]
+	if !ok {
+		l = &ipLimiter{limiter: newLimiter(t.limits.IP)}
+		t.ips[host] = l
+	}
+
+	l.refs++
+
+	return newLimiter(t.limits.Conn), l.limiter
+}
+
+// release drops the reference to the IP limiter once a connection closes
+func (t *throttle) release(addr net.Addr) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	host := addrHost(addr)
+
+	l, ok := t.ips[host]
+	if !ok {
+		return
+	}
+
+	if l.refs--; l.refs <= 0 {
+		delete(t.ips, host)
+	}
+}
+
+// set updates the limits of the relay and every client IP.
+// Connection limiters are updated by the relay.
+func (t *throttle) set(limits Limits) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.limits = limits
+	t.relay.set(limits.Relay)
+
+	if limits.bandwidth() {
+		atomic.StoreInt32(&t.limited, 1)
+	} else {
+		atomic.StoreInt32(&t.limited, 0)
+	}
+
+	for _, l := range t.ips {
+		l.set(limits.IP)
+	}
+
+	for ip, b := range t.connRates {
+		if limits.ConnRate <= 0 {
+			delete(t.connRates, ip)
+			continue
+		}
+
+		b.setRate(limits.ConnRate)
+	}
+}
+
+func (t *throttle) get() Limits {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	return t.limits
+}
+
+// enabled returns true if any bandwidth limit is set
+func (t *throttle) enabled() bool {
+	return atomic.LoadInt32(&t.limited) == 1
+}
+
+// wait blocks until n bytes are permitted by all limiters.
+// Returns true if the caller was delayed.
+func (t *throttle) wait(n int, upload bool, limiters ...*limiter) bool {
+	delay := t.relay.take(n, upload)
+
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+
+		if d := l.take(n, upload); d > delay {
+			delay = d
+		}
+	}
+
+	if delay <= 0 {
+		return false
+	}
+
+	atomic.AddInt64(&t.active, 1)
+	time.Sleep(delay)
+	atomic.AddInt64(&t.active, -1)
+
+	return true
+}
+
+// throttling returns the amount of connections currently being delayed
+func (t *throttle) throttling() int {
+	return int(atomic.LoadInt64(&t.active))
+}
+
+func addrHost(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	return addr.String()
+}
@@ -0,0 +1,92 @@
+package localrelay
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBucketUnlimited(t *testing.T) {
+	b := newBucket(0)
+
+	if d := b.take(1 << 20); d != 0 {
+		t.Fatalf("unlimited bucket should not delay, got %s", d)
+	}
+}
+
+func TestBucketDelay(t *testing.T) {
+	b := newBucket(1000)
+
+	// the initial burst allowance is one second of traffic
+	if d := b.take(1000); d != 0 {
+		t.Fatalf("burst should not delay, got %s", d)
+	}
+
+	d := b.take(500)
+	if d < 400*time.Millisecond || d > 600*time.Millisecond {
+		t.Fatalf("expected a delay of roughly 500ms, got %s", d)
+	}
+}
+
+func TestThrottleIPRefs(t *testing.T) {
+	th := newThrottle()
+	th.set(Limits{IP: Limit{Upload: 100}})
+
+	addr := testAddr("10.0.0.1:1234")
+	_, a := th.acquire(addr)
+	_, b := th.acquire(testAddr("10.0.0.1:4321"))
+
+	if a != b {
+		t.Fatal("connections from the same IP must share a limiter")
+	}
+
+	th.release(addr)
+	th.release(addr)
+
+	if len(th.ips) != 0 {
+		t.Fatal("IP limiter was not released")
+	}
+}
+
+func TestThrottleConnRate(t *testing.T) {
+	th := newThrottle()
+
+	if !th.allowConn("10.0.0.1") || len(th.connRates) != 0 {
+		t.Fatal("connections must not be tracked without a rate")
+	}
+
+	th.set(Limits{ConnRate: 2})
+
+	for i := 0; i < 2; i++ {
+		if !th.allowConn("10.0.0.1") {
+			t.Fatal("connection within the rate was refused")
+		}
+	}
+
+	if th.allowConn("10.0.0.1") {
+		t.Fatal("expected the connection over the rate to be refused")
+	}
+
+	if !th.allowConn("10.0.0.2") {
+		t.Fatal("the rate must be applied per client IP")
+	}
+
+	// the tracked IPs are bounded
+	for i := 0; i < maxConnRates*2; i++ {
+		th.allowConn(strconv.Itoa(i))
+	}
+
+	if len(th.connRates) > maxConnRates {
+		t.Fatalf("expected at most %d tracked IPs got %d", maxConnRates, len(th.connRates))
+	}
+
+	th.set(Limits{})
+	if len(th.connRates) != 0 {
+		t.Fatal("expected the tracked IPs to be cleared once disabled")
+	}
+}
+
+type testAddr string
+
+func (a testAddr) Network() string { return "tcp" }
+func (a testAddr) String() string  { return string(a) }
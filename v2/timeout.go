@@ -0,0 +1,131 @@
+package localrelay
+
+import (
+	"net"
+	"time"
+)
+
+// CloseReason records why a relayed connection was closed
+type CloseReason string
+
+const (
+	// CloseEOF is used when either side closed the connection
+	CloseEOF CloseReason = "eof"
+	// CloseIdle is used when no traffic was sent within the idle timeout
+	CloseIdle CloseReason = "idle"
+	// CloseLifetime is used when the connection exceeded its maximum lifetime
+	CloseLifetime CloseReason = "lifetime"
+	// CloseDropped is used when the connection was dropped by the user
+	CloseDropped CloseReason = "dropped"
+	// CloseError is used when the connection failed
+	CloseError CloseReason = "error"
+	// CloseShutdown is used when the connection outlived a graceful shutdown
+	CloseShutdown CloseReason = "shutdown"
+)
+
+// Timeouts controls how long relayed connections are kept alive
+type Timeouts struct {
+	// Idle closes a connection when no traffic has been sent in either
+	// direction for this duration. Zero disables the idle timeout.
+	Idle time.Duration
+	// Lifetime closes a connection once it has been open for this duration.
+	// Zero disables the maximum lifetime.
+	Lifetime time.Duration
+	// KeepAlive sets the TCP keepalive period on both the client and
+	// destination connections. Zero uses the system default and a
+	// negative value disables keepalives.
+	KeepAlive time.Duration
+}
+
+// SetTimeouts sets the idle timeout, max lifetime and keepalive of
+// connections. Changes only apply to new connections.
+func (r *Relay) SetTimeouts(t Timeouts) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.timeouts = t
+}
+
+// Timeouts returns the relay's connection timeouts
+func (r *Relay) Timeouts() Timeouts {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.timeouts
+}
+
+// watchdog closes both connections once the idle timeout or max lifetime
+// has been exceeded. The returned function stops the watchdog.
+func watchdog(tc *trackedConn, remote net.Conn, t Timeouts) (stop func()) {
+	if t.Idle <= 0 && t.Lifetime <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(watchdogInterval(t))
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				reason := CloseReason("")
+
+				if t.Lifetime > 0 && now.Sub(tc.opened) >= t.Lifetime {
+					reason = CloseLifetime
+				} else if t.Idle > 0 && now.Sub(tc.lastActiveTime()) >= t.Idle {
+					reason = CloseIdle
+				}
+
+				if reason == "" {
+					continue
+				}
+
+				tc.setReason(reason)
+				tc.conn.Close()
+				remote.Close()
+
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// watchdogInterval checks the timeouts four times per period,
+// at most once every 10ms and at least once a second
+func watchdogInterval(t Timeouts) time.Duration {
+	interval := time.Second
+
+	for _, d := range []time.Duration{t.Idle, t.Lifetime} {
+		if d > 0 && d/4 < interval {
+			interval = d / 4
+		}
+	}
+
+	if interval < time.Millisecond*10 {
+		return time.Millisecond * 10
+	}
+
+	return interval
+}
+
+// setKeepAlive applies the keepalive period to TCP connections
+func setKeepAlive(conn net.Conn, period time.Duration) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok || period == 0 {
+		return
+	}
+
+	if period < 0 {
+		tcp.SetKeepAlive(false)
+		return
+	}
+
+	tcp.SetKeepAlive(true)
+	tcp.SetKeepAlivePeriod(period)
+}
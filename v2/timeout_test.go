@@ -0,0 +1,131 @@
+package localrelay
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	relay, addr := startTestRelay(t, TargetLink("tcp://"+startEchoServer(t)))
+	relay.SetTimeouts(Timeouts{Idle: time.Millisecond * 100})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// the relay should close the connection once idle
+	conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	if _, err := conn.Read(buf); err != io.EOF {
+		t.Fatalf("expected EOF from idle timeout, got %v", err)
+	}
+
+	waitFor(t, func() bool {
+		return relay.Metrics.CloseReasons()[CloseIdle] == 1
+	})
+}
+
+func TestMaxLifetime(t *testing.T) {
+	relay, addr := startTestRelay(t, TargetLink("tcp://"+startEchoServer(t)))
+	relay.SetTimeouts(Timeouts{Lifetime: time.Millisecond * 200})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	// keep the connection busy so only the lifetime can close it
+	start := time.Now()
+	buf := make([]byte, 4)
+	for time.Since(start) < time.Second*2 {
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			break
+		}
+
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			break
+		}
+
+		time.Sleep(time.Millisecond * 20)
+	}
+
+	if time.Since(start) >= time.Second*2 {
+		t.Fatal("connection outlived its max lifetime")
+	}
+
+	waitFor(t, func() bool {
+		return relay.Metrics.CloseReasons()[CloseLifetime] == 1
+	})
+}
+
+// startTestRelay serves a TCP relay on a random loopback port
+func startTestRelay(t testing.TB, destination TargetLink) (*Relay, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relay, err := New("test-relay", io.Discard, TargetLink("tcp://"+l.Addr().String()), destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go relay.Serve(l)
+	t.Cleanup(func() { relay.Close() })
+
+	return relay, l.Addr().String()
+}
+
+// startEchoServer listens on a random loopback port and echos all data
+func startEchoServer(t testing.TB) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// waitFor polls the condition for up to two seconds
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	for start := time.Now(); time.Since(start) < time.Second*2; time.Sleep(time.Millisecond * 10) {
+		if condition() {
+			return
+		}
+	}
+
+	t.Fatal("condition was not met in time")
+}
@@ -0,0 +1,160 @@
+package localrelay
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrSocketInUse is returned when a unix socket is already being listened on
+	ErrSocketInUse = errors.New("unix socket is in use by another process")
+	// ErrNotSocket is returned when a unix listener's path exists but is not a socket
+	ErrNotSocket = errors.New("path exists and is not a unix socket")
+	// ErrInvalidSocketMode is returned when the mode option is not an octal permission
+	ErrInvalidSocketMode = errors.New("invalid unix socket mode, expected octal e.g. 0660")
+)
+
+// unixListener listens on the unix socket of the target. A stale socket
+// file left by a previous run is removed first. Once listening the mode,
+// owner and group query options are applied to the socket file:
+//
+//	unix:///run/localrelay/app.sock?mode=0660&owner=www-data&group=www-data
+//
+// Abstract sockets (unix://@name) have no file so only exist on Linux and
+// ignore these options.
+func unixListener(t TargetLink) (net.Listener, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	path := t.Addr()
+
+	if !isAbstractSocket(path) {
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if isAbstractSocket(path) {
+		return l, nil
+	}
+
+	if err := setSocketOptions(path, t.query()); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// isAbstractSocket returns true for sockets in the Linux abstract namespace
+func isAbstractSocket(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+// removeStaleSocket removes the socket file at path if nothing is
+// listening on it. Files which are not sockets are never removed.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.Wrapf(ErrNotSocket, "%q", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return errors.Wrapf(ErrSocketInUse, "%q", path)
+	}
+
+	return os.Remove(path)
+}
+
+// setSocketOptions applies the mode, owner and group options to the socket file
+func setSocketOptions(path string, query url.Values) error {
+	if mode := query.Get("mode"); mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || perm > 0777 {
+			return errors.Wrapf(ErrInvalidSocketMode, "%q", mode)
+		}
+
+		if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+			return err
+		}
+	}
+
+	owner, group := query.Get("owner"), query.Get("group")
+	if owner == "" && group == "" {
+		return nil
+	}
+
+	// -1 leaves the id unchanged
+	uid, gid := -1, -1
+
+	if owner != "" {
+		id, err := lookupID(owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+
+			return u.Uid, nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "unix socket owner")
+		}
+
+		uid = id
+	}
+
+	if group != "" {
+		id, err := lookupID(group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+
+			return g.Gid, nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "unix socket group")
+		}
+
+		gid = id
+	}
+
+	return os.Chown(path, uid, gid)
+}
+
+// lookupID parses a numeric id or resolves a name using lookup
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(id)
+}
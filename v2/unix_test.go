@@ -0,0 +1,202 @@
+package localrelay
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestTargetUnixAddr(t *testing.T) {
+	tests := map[TargetLink]string{
+		"unix:///var/run/docker.sock":         "/var/run/docker.sock",
+		"unix:///run/app.sock?mode=0660":      "/run/app.sock",
+		"unix://@localrelay":                  "@localrelay",
+		"UNIX://@localrelay?lb_weight=5":      "@localrelay",
+		"unix://relative.sock?owner=www-data": "relative.sock",
+	}
+
+	for target, expected := range tests {
+		if target.ProxyType() != ProxyUnix {
+			t.Errorf("%s: expected unix proxy type got %q", target, target.ProxyType())
+		}
+
+		if addr := target.Addr(); addr != expected {
+			t.Errorf("%s: expected addr %q got %q", target, expected, addr)
+		}
+	}
+}
+
+// pingRelay sends a ping through the relay and expects it echoed back
+func pingRelay(t *testing.T, network, addr string) {
+	t.Helper()
+
+	conn, err := net.DialTimeout(network, addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	conn.Write([]byte("ping"))
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "ping" {
+		t.Fatalf("expected ping got %q", buf)
+	}
+}
+
+func TestUnixListener(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket permissions are not supported on windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "relay.sock")
+
+	relay, err := New("test-relay", io.Discard, TargetLink("unix://"+path+"?mode=0660&owner="+strconv.Itoa(os.Getuid())),
+		TargetLink("tcp://"+startEchoServer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go relay.ListenServe()
+	t.Cleanup(func() { relay.Close() })
+
+	waitFor(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil && relay.Running()
+	})
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if perm := info.Mode().Perm(); perm != 0660 {
+		t.Fatalf("expected mode 0660 got %o", perm)
+	}
+
+	pingRelay(t, "unix", path)
+}
+
+func TestUnixDestination(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backend.sock")
+
+	backend, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { backend.Close() })
+
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relay, err := New("test-relay", io.Discard, TargetLink("tcp://"+l.Addr().String()), TargetLink("unix://"+path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go relay.Serve(l)
+	t.Cleanup(func() { relay.Close() })
+
+	pingRelay(t, "tcp", l.Addr().String())
+}
+
+func TestUnixAbstractListener(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract unix sockets are only supported on linux")
+	}
+
+	name := "@localrelay-test-" + strconv.Itoa(os.Getpid())
+
+	relay, err := New("test-relay", io.Discard, TargetLink("unix://"+name), TargetLink("tcp://"+startEchoServer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go relay.ListenServe()
+	t.Cleanup(func() { relay.Close() })
+
+	waitFor(t, relay.Running)
+
+	pingRelay(t, "unix", name)
+}
+
+func TestUnixStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stale.sock")
+
+	// leave a socket file behind which nothing listens on
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal("expected stale socket file to exist")
+	}
+
+	l, err = unixListener(TargetLink("unix://" + path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the socket is now in use so must not be removed
+	if _, err := unixListener(TargetLink("unix://" + path)); !errors.Is(err, ErrSocketInUse) {
+		t.Fatalf("expected ErrSocketInUse got %v", err)
+	}
+
+	l.Close()
+}
+
+func TestUnixNotSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("keep me"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := unixListener(TargetLink("unix://" + path)); !errors.Is(err, ErrNotSocket) {
+		t.Fatalf("expected ErrNotSocket got %v", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal("file was removed")
+	}
+}
+
+func TestUnixInvalidMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay.sock")
+
+	if _, err := unixListener(TargetLink("unix://" + path + "?mode=rw")); !errors.Is(err, ErrInvalidSocketMode) {
+		t.Fatalf("expected ErrInvalidSocketMode got %v", err)
+	}
+}